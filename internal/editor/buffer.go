@@ -1,72 +1,512 @@
 package editor
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"strings"
 )
 
+var ErrNoFileName = errors.New("E32: No file name")
+
+var ErrIndexing = errors.New("E21: Cannot make changes while the file is being indexed")
+
 /*
-Buffer manages text content as a slice of lines, tracking modifications and file I/O.
-Line-based storage simplifies newline handling and line-oriented operations while
-maintaining reasonable performance for typical editing tasks.
+Buffer manages text content as a rope of lines, tracking modifications and file I/O.
+Line-based storage simplifies newline handling and line-oriented operations, and the
+rope keeps line lookup, insertion and deletion logarithmic so huge files stay editable.
+Files of at least largeFileThreshold bytes are not read into memory at all: index
+locates their lines on disk and the rope refers to it until lines are edited.
 */
 type Buffer struct {
-	lines    []string
+	lines    *rope
 	filename string
 	dirty    bool
+	history  *history
+	layout   fileLayout
+	disk     diskState
+	base     []string
+	index    *lineIndex
+	indexing bool
+
+	// Why the content cannot be edited as text, such as "binary"; empty for text
+	undecodable string
+
+	// Set by -R, :view or :set readonly, and on load when the file is not writable
+	readOnly bool
+
+	// Called after every edit while the editor applies an operation to several
+	// selections, so it can shift the ones not yet visited
+	observer func(edit)
+
+	// Line marks set by :mark, and '< and '> for the last visual selection;
+	// edits shift them along with the text
+	marks map[rune]Position
+
+	// The lines a running :g has yet to visit
+	globalMarks []Position
+
+	largeFileThreshold int64
 }
 
 func NewBuffer() *Buffer {
 	return &Buffer{
-		lines: []string{""},
-		dirty: false,
+		lines:   newRope([]string{""}),
+		dirty:   false,
+		history: newHistory(),
+		layout:  fileLayout{finalNewline: true},
+
+		largeFileThreshold: DefaultLargeFileThreshold,
 	}
 }
 
+/*
+LoadFile reads a file into the buffer, remembering its layout, a fingerprint to
+detect later changes on disk, and the loaded lines as the base for merging. The
+encoding is detected: UTF-16 by its byte order mark, anything else is UTF-8, and
+content that is binary or not valid UTF-8 is marked undecodable.
+*/
 func (b *Buffer) LoadFile(filename string) error {
-	content, err := os.ReadFile(filename)
+	return b.load(filename, EncodingUTF8, false)
+}
+
+/*
+LoadFileEncoding reads a file decoding it from enc, whatever its content looks like.
+*/
+func (b *Buffer) LoadFileEncoding(filename string, enc Encoding) error {
+	return b.load(filename, enc, true)
+}
+
+func (b *Buffer) load(filename string, enc Encoding, explicit bool) error {
+	info, err := os.Stat(filename)
+	if err == nil && b.largeFileThreshold > 0 && info.Size() >= b.largeFileThreshold {
+		// Large-file mode indexes UTF-8 bytes in place; other encodings are decoded whole
+		head, err := readHead(filename, binarySniffSize)
+		if err != nil {
+			return err
+		}
+		if !explicit {
+			enc = sniffEncoding(head)
+		}
+		if enc == EncodingUTF8 {
+			if err := b.loadLarge(filename, info); err != nil {
+				return err
+			}
+			b.undecodable = undecodable(head, true)
+			b.readOnly = !writable(filename)
+			return nil
+		}
+	}
+	var content []byte
+	if err == nil {
+		content, err = os.ReadFile(filename)
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
-			b.lines = []string{""}
+			b.closeIndex()
+			b.lines = newRope([]string{""})
 			b.filename = filename
 			b.dirty = false
+			b.history = newHistory()
+			b.layout = fileLayout{finalNewline: true}
+			b.disk = diskState{}
+			b.base = []string{""}
+			b.undecodable = ""
+			b.readOnly = false
+			if explicit {
+				b.layout.encoding = enc
+			}
 			return nil
 		}
 		return err
 	}
 
-	text := string(content)
-	if text == "" {
-		b.lines = []string{""}
-	} else {
-		b.lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if !explicit {
+		enc = sniffEncoding(content)
+	}
+	lines, layout, err := decodeContent(content, enc)
+	if err != nil {
+		return err
+	}
+	b.undecodable = ""
+	if enc == EncodingUTF8 {
+		b.undecodable = undecodable(content, false)
+	}
+	b.readOnly = !writable(filename)
+	b.closeIndex()
+	b.lines = newRope(lines)
+	b.layout = layout
+	b.disk = newDiskState(info, contentHash(content))
+	b.base = lines
+	b.filename = filename
+	b.dirty = false
+	b.history = loadHistory(filename, contentHash(content))
+	return nil
+}
+
+/*
+viewing wraps a load function so the buffer it loads is read-only.
+*/
+func (b *Buffer) viewing(load func(string) error) func(string) error {
+	return func(filename string) error {
+		if err := load(filename); err != nil {
+			return err
+		}
+		b.readOnly = true
+		return nil
 	}
+}
+
+func readHead(filename string, n int) ([]byte, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, n)
+	k, err := io.ReadFull(f, head)
+	if err == io.ErrUnexpectedEOF {
+		err = nil
+	}
+	return head[:k], err
+}
+
+/*
+loadLarge opens a file in large-file mode. Only the first chunk is indexed before
+returning, so the top of the file shows immediately while the rest is indexed in
+the background; the buffer is read-only until PollIndex sees indexing finish.
+Undo history is not recorded, since every snapshot of a huge edit would be held in
+memory, and there is no base copy of the text for merging. Edits made since the
+last save are still kept for the swap file, which is what a crash costs most on.
+*/
+func (b *Buffer) loadLarge(filename string, info fs.FileInfo) error {
+	index, err := openLineIndex(filename, info.Size())
+	if err != nil {
+		return err
+	}
+
+	b.closeIndex()
+	b.index = index
+	b.indexing = true
+	b.lines = newLazyRope(index, index.Len())
 	b.filename = filename
 	b.dirty = false
+	b.history = newHistory()
+	b.history.disabled = true
+	b.base = nil
+	b.disk = diskState{exists: true, modTime: info.ModTime(), size: info.Size()}
+	b.PollIndex()
 	return nil
 }
 
-func (b *Buffer) SaveFile() error {
+/*
+PollIndex extends the buffer over the lines indexed since the last poll and
+reports whether indexing is still running. Once it finished the file's layout
+and hash are known and the buffer becomes editable. A read error ends indexing
+but leaves the buffer read-only.
+*/
+func (b *Buffer) PollIndex() (bool, error) {
+	x := b.index
+	if !b.Indexing() {
+		return false, nil
+	}
+
+	done := x.Done()
+	b.lines = newLazyRope(x, x.Len())
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	b.layout = x.layout
+	if x.err != nil {
+		// Stay read-only: saving the part that was read would truncate the file
+		return false, fmt.Errorf("E484: Can't read file %s: %w", b.filename, x.err)
+	}
+	if done {
+		b.indexing = false
+		if b.disk.hash == "" {
+			b.disk.hash = x.hash
+		}
+	}
+	return !done, nil
+}
+
+/*
+IsLarge reports whether the buffer is in large-file mode, in which undo, merging
+and other features that copy the text are disabled.
+*/
+func (b *Buffer) IsLarge() bool {
+	return b.index != nil
+}
+
+/*
+Indexing reports whether a large file is still being indexed. The buffer shows
+what has been indexed so far but cannot be changed or saved until it finishes.
+*/
+func (b *Buffer) Indexing() bool {
+	return b.indexing
+}
+
+func (b *Buffer) IndexProgress() float64 {
+	if b.index == nil {
+		return 1
+	}
+	return b.index.Progress()
+}
+
+func (b *Buffer) closeIndex() {
+	if b.index != nil {
+		b.index.Close()
+		b.index = nil
+		b.indexing = false
+	}
+}
+
+/*
+Close releases the file held open by a large buffer.
+*/
+func (b *Buffer) Close() {
+	b.closeIndex()
+}
+
+/*
+SaveFile writes the buffer to its file and returns the number of bytes written.
+Errors carry vim's message numbers since they are shown to the user verbatim.
+*/
+func (b *Buffer) SaveFile() (int, error) {
 	if b.filename == "" {
+		return 0, ErrNoFileName
+	}
+	write, err := b.content()
+	if err != nil {
+		return 0, err
+	}
+	return b.writeFile(write)
+}
+
+/*
+WriteCopy writes the buffer to another file, as :w other.txt does, leaving the
+buffer's own file, saved state and history as they are.
+*/
+func (b *Buffer) WriteCopy(filename string) (int, error) {
+	write, err := b.content()
+	if err != nil {
+		return 0, err
+	}
+	return writeCopy(filename, write)
+}
+
+/*
+content returns the function that writes the buffer in its file's encoding.
+Other encodings are converted in memory first so a conversion error leaves the
+file untouched; large files are always UTF-8 and streamed.
+*/
+func (b *Buffer) content() (func(w io.Writer) (int64, error), error) {
+	if b.Indexing() {
+		return nil, ErrIndexing
+	}
+	if b.layout.encoding == EncodingUTF8 {
+		return b.writeContent, nil
+	}
+	var text bytes.Buffer
+	b.writeContent(&text)
+	encoded, err := encodeContent(text.Bytes(), b.layout)
+	if err != nil {
+		return nil, err
+	}
+	return writeBytes(encoded), nil
+}
+
+func writeBytes(data []byte) func(w io.Writer) (int64, error) {
+	return func(w io.Writer) (int64, error) {
+		n, err := w.Write(data)
+		return int64(n), err
+	}
+}
+
+/*
+writeCopy writes what write produces to a file other than the buffer's own,
+which records nothing as saved.
+*/
+func writeCopy(filename string, write func(w io.Writer) (int64, error)) (int, error) {
+	var size int64
+	err := writeFileFunc(filename, 0644, func(w io.Writer) error {
+		var err error
+		size, err = write(w)
+		return err
+	})
+	if err != nil {
+		return 0, writeError(err)
+	}
+	return int(size), nil
+}
+
+/*
+writeError reports a failed write by its underlying cause, since the path may
+name a temporary file.
+*/
+func writeError(err error) error {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	return fmt.Errorf("E212: Can't open file for writing: %w", err)
+}
+
+/*
+saveBytes writes data to the file verbatim, for hex mode, whose bytes are the
+file exactly. The buffer's text must already have been set from them.
+*/
+func (b *Buffer) saveBytes(data []byte) (int, error) {
+	if b.filename == "" {
+		return 0, ErrNoFileName
+	}
+	return b.writeFile(writeBytes(data))
+}
+
+/*
+writeFile atomically replaces the file with what write produces, then records
+the result as the saved state: clean and fingerprinted. Persisting the undo
+history is left to saveHistory, so failing to do so never fails the save.
+*/
+func (b *Buffer) writeFile(write func(w io.Writer) (int64, error)) (int, error) {
+	sum := sha256.New()
+	var size int64
+	err := writeFileFunc(b.filename, 0644, func(w io.Writer) error {
+		var err error
+		size, err = write(io.MultiWriter(w, sum))
+		return err
+	})
+	if err != nil {
+		return 0, writeError(err)
+	}
+
+	hash := hex.EncodeToString(sum.Sum(nil))
+	b.dirty = false
+	if info, err := os.Stat(b.filename); err == nil {
+		b.disk = newDiskState(info, hash)
+	}
+	b.history.markSaved()
+	if !b.IsLarge() {
+		b.base = b.allLines()
+	}
+	return int(size), nil
+}
+
+/*
+saveHistory persists the undo history of the content just saved, for files that
+keep one.
+*/
+func (b *Buffer) saveHistory() error {
+	if b.IsLarge() || !b.disk.exists {
 		return nil
 	}
-	content := strings.Join(b.lines, "\n")
-	err := os.WriteFile(b.filename, []byte(content), 0644)
-	if err == nil {
-		b.dirty = false
+	return saveHistory(b.filename, b.history, b.disk.hash)
+}
+
+/*
+writeContent streams every line in the file's byte representation, restoring the
+byte order mark, line endings and final newline the file was loaded with. Lines
+are written as they are visited, so saving a large file never holds it in memory.
+The text is UTF-8; a byte order mark for another encoding is left to encodeContent.
+*/
+func (b *Buffer) writeContent(w io.Writer) (int64, error) {
+	out := bufio.NewWriterSize(w, 64<<10)
+	var size int64
+	write := func(s string) {
+		n, _ := out.WriteString(s)
+		size += int64(n)
+	}
+	if b.layout.bom && b.layout.encoding == EncodingUTF8 {
+		write(string(utf8BOM))
+	}
+
+	ending := b.layout.format.lineEnding()
+	first := true
+	b.lines.Each(func(line string) {
+		if !first {
+			write(ending)
+		}
+		first = false
+		write(line)
+	})
+	if b.layout.finalNewline {
+		write(ending)
 	}
-	return err
+	return size, out.Flush()
+}
+
+func (b *Buffer) FileFormat() FileFormat {
+	return b.layout.format
+}
+
+/*
+SetFileFormat converts the line endings used on the next save. The text itself
+is unchanged, but the file will differ on disk, so the buffer becomes dirty.
+*/
+func (b *Buffer) SetFileFormat(format FileFormat) {
+	if b.layout.format != format {
+		b.layout.format = format
+		b.dirty = true
+	}
+}
+
+func (b *Buffer) Encoding() Encoding {
+	return b.layout.encoding
+}
+
+/*
+SetEncoding changes the encoding used on the next save, which makes the buffer
+dirty like a line ending conversion. UTF-16 is only recognised by its byte order
+mark, so converting to it adds one and converting away drops it.
+*/
+func (b *Buffer) SetEncoding(enc Encoding) {
+	if b.layout.encoding == enc {
+		return
+	}
+	wasUTF16 := b.layout.encoding == EncodingUTF16LE || b.layout.encoding == EncodingUTF16BE
+	b.layout.encoding = enc
+	if enc == EncodingUTF16LE || enc == EncodingUTF16BE {
+		b.layout.bom = true
+	} else if wasUTF16 {
+		b.layout.bom = false
+	}
+	b.dirty = true
+}
+
+/*
+Undecodable explains why the buffer's content is not editable text, such as
+"binary" or "invalid UTF-8", or returns the empty string for ordinary text.
+*/
+func (b *Buffer) Undecodable() string {
+	return b.undecodable
+}
+
+func (b *Buffer) ReadOnly() bool {
+	return b.readOnly
+}
+
+func (b *Buffer) HasBOM() bool {
+	return b.layout.bom
+}
+
+func (b *Buffer) HasFinalNewline() bool {
+	return b.layout.finalNewline
 }
 
 func (b *Buffer) LineCount() int {
-	return len(b.lines)
+	return b.lines.Len()
 }
 
 func (b *Buffer) GetLine(n int) string {
-	if n < 0 || n >= len(b.lines) {
+	if n < 0 || n >= b.lines.Len() {
 		return ""
 	}
-	return b.lines[n]
+	return b.lines.Line(n)
 }
 
 /*
@@ -74,59 +514,49 @@ InsertChar inserts a single rune at the given position. Returns the new cursor p
 after insertion. Clamps column to line length to handle out-of-bounds positions gracefully.
 */
 func (b *Buffer) InsertChar(pos Position, ch rune) Position {
-	if pos.Line >= len(b.lines) {
+	if pos.Line >= b.lines.Len() {
 		return pos
 	}
 
-	line := b.lines[pos.Line]
+	line := b.lines.Line(pos.Line)
 	if pos.Col > len(line) {
 		pos.Col = len(line)
 	}
 
-	b.lines[pos.Line] = line[:pos.Col] + string(ch) + line[pos.Col:]
-	b.dirty = true
-	return Position{Line: pos.Line, Col: pos.Col + 1}
+	return b.insertText(pos, string(ch))
 }
 
 func (b *Buffer) InsertNewline(pos Position) Position {
-	if pos.Line >= len(b.lines) {
+	if pos.Line >= b.lines.Len() {
 		return pos
 	}
 
-	line := b.lines[pos.Line]
+	line := b.lines.Line(pos.Line)
 	if pos.Col > len(line) {
 		pos.Col = len(line)
 	}
 
-	before := line[:pos.Col]
-	after := line[pos.Col:]
-
-	b.lines[pos.Line] = before
-	newLines := append([]string{}, b.lines[:pos.Line+1]...)
-	newLines = append(newLines, after)
-	newLines = append(newLines, b.lines[pos.Line+1:]...)
-	b.lines = newLines
-
-	b.dirty = true
-	return Position{Line: pos.Line + 1, Col: 0}
+	return b.insertText(pos, "\n")
 }
 
+/*
+DeleteChar removes the grapheme cluster before pos, or joins the line with the
+previous one at column zero. Returns the position the cursor should move to.
+*/
 func (b *Buffer) DeleteChar(pos Position) Position {
-	if pos.Line >= len(b.lines) {
+	if pos.Line >= b.lines.Len() {
 		return pos
 	}
 
-	line := b.lines[pos.Line]
+	line := b.lines.Line(pos.Line)
 	if pos.Col > 0 && pos.Col <= len(line) {
-		b.lines[pos.Line] = line[:pos.Col-1] + line[pos.Col:]
-		b.dirty = true
-		return Position{Line: pos.Line, Col: pos.Col - 1}
+		start := Position{Line: pos.Line, Col: prevGrapheme(line, pos.Col)}
+		b.deleteRange(start, pos)
+		return start
 	} else if pos.Col == 0 && pos.Line > 0 {
-		prevLine := b.lines[pos.Line-1]
-		b.lines[pos.Line-1] = prevLine + line
-		b.lines = append(b.lines[:pos.Line], b.lines[pos.Line+1:]...)
-		b.dirty = true
-		return Position{Line: pos.Line - 1, Col: len(prevLine)}
+		start := Position{Line: pos.Line - 1, Col: len(b.lines.Line(pos.Line - 1))}
+		b.deleteRange(start, pos)
+		return start
 	}
 
 	return pos
@@ -139,24 +569,208 @@ Returns cursor position at the start of the deleted range.
 */
 func (b *Buffer) DeleteSelection(sel Selection) Position {
 	start, end := sel.Start(), sel.End()
+	b.deleteRange(start, end)
+	return start
+}
 
-	if start.Line == end.Line {
-		line := b.lines[start.Line]
-		b.lines[start.Line] = line[:start.Col] + line[end.Col:]
+/*
+insertText splices text, which may span several lines, into the buffer at pos and
+returns the position just past it. insertText and deleteRange are the only places
+that mutate lines, so every change reaches the undo history.
+*/
+func (b *Buffer) insertText(pos Position, text string) Position {
+	parts := strings.Split(text, "\n")
+	line := b.lines.Line(pos.Line)
+	before, after := line[:pos.Col], line[pos.Col:]
+
+	var end Position
+	if len(parts) == 1 {
+		b.lines.SetLine(pos.Line, before+text+after)
+		end = Position{Line: pos.Line, Col: pos.Col + len(text)}
 	} else {
-		startLine := b.lines[start.Line][:start.Col]
-		endLine := b.lines[end.Line][end.Col:]
+		last := len(parts) - 1
+		b.lines.SetLine(pos.Line, before+parts[0])
+		rest := append(parts[1:last:last], parts[last]+after)
+		b.lines.Insert(pos.Line+1, rest)
+		end = Position{Line: pos.Line + last, Col: len(parts[last])}
+	}
 
-		b.lines[start.Line] = startLine + endLine
-		if end.Line+1 < len(b.lines) {
-			b.lines = append(b.lines[:start.Line+1], b.lines[end.Line+1:]...)
-		} else {
-			b.lines = b.lines[:start.Line+1]
-		}
+	b.history.record(edit{Pos: pos, Inserted: text})
+	b.dirty = true
+	b.shiftMarks(edit{Pos: pos, Inserted: text})
+	if b.observer != nil {
+		b.observer(edit{Pos: pos, Inserted: text})
+	}
+	return end
+}
+
+/*
+deleteRange removes the text between start and end, merging the boundary lines,
+and returns what was removed.
+*/
+func (b *Buffer) deleteRange(start, end Position) string {
+	text := b.textRange(start, end)
+	if text == "" {
+		return ""
 	}
 
+	head := b.lines.Line(start.Line)[:start.Col]
+	tail := b.lines.Line(end.Line)[end.Col:]
+	b.lines.SetLine(start.Line, head+tail)
+	b.lines.Delete(start.Line+1, end.Line+1)
+
+	b.history.record(edit{Pos: start, Deleted: text})
 	b.dirty = true
-	return start
+	b.shiftMarks(edit{Pos: start, Deleted: text})
+	if b.observer != nil {
+		b.observer(edit{Pos: start, Deleted: text})
+	}
+	return text
+}
+
+/*
+textRange returns the exact text between two ordered positions, joining lines
+with newlines. Unlike GetSelectedText it never drops partial ranges, which the
+undo history relies on to reconstruct deletions.
+*/
+func (b *Buffer) textRange(start, end Position) string {
+	if start.Line == end.Line {
+		return b.lines.Line(start.Line)[start.Col:end.Col]
+	}
+
+	var result strings.Builder
+	result.WriteString(b.lines.Line(start.Line)[start.Col:])
+	for i := start.Line + 1; i < end.Line; i++ {
+		result.WriteString("\n")
+		result.WriteString(b.lines.Line(i))
+	}
+	result.WriteString("\n")
+	result.WriteString(b.lines.Line(end.Line)[:end.Col])
+	return result.String()
+}
+
+/*
+undo reverts the change that produced the current state and returns it so the
+editor can restore the cursor and selection recorded with it. Returns nil at the
+root of the history.
+*/
+func (b *Buffer) undo() *change {
+	node := b.history.current
+	if node.parent == nil {
+		return nil
+	}
+
+	b.history.applying = true
+	for i := len(node.change.edits) - 1; i >= 0; i-- {
+		b.revertEdit(node.change.edits[i])
+	}
+	b.history.applying = false
+
+	node.parent.redoChild = node
+	b.history.current = node.parent
+	b.dirty = !b.history.atSaved()
+	return node.change
+}
+
+/*
+redo reapplies the most recently travelled branch below the current state.
+*/
+func (b *Buffer) redo() *change {
+	node := b.history.current.redoChild
+	if node == nil {
+		return nil
+	}
+
+	b.history.applying = true
+	for _, ed := range node.change.edits {
+		b.applyEdit(ed)
+	}
+	b.history.applying = false
+
+	b.history.current = node
+	b.dirty = !b.history.atSaved()
+	return node.change
+}
+
+/*
+travel moves the buffer to any state in the undo tree by undoing up to the common
+ancestor and redoing down the target's branch. Returns the cursor position the
+last applied step recorded, and false if the buffer was already there.
+*/
+func (b *Buffer) travel(target *undoNode) (Position, bool) {
+	up, down := b.history.path(b.history.current, target)
+	if len(up) == 0 && len(down) == 0 {
+		return Position{}, false
+	}
+
+	var cursor Position
+	for range up {
+		cursor = b.undo().cursorBefore
+	}
+	for _, node := range down {
+		b.history.current.redoChild = node
+		cursor = b.redo().cursorAfter
+	}
+	return cursor, true
+}
+
+func (b *Buffer) applyEdit(ed edit) {
+	if ed.Deleted != "" {
+		b.deleteRange(ed.Pos, textEnd(ed.Pos, ed.Deleted))
+	}
+	if ed.Inserted != "" {
+		b.insertText(ed.Pos, ed.Inserted)
+	}
+}
+
+func (b *Buffer) revertEdit(ed edit) {
+	if ed.Inserted != "" {
+		b.deleteRange(ed.Pos, textEnd(ed.Pos, ed.Inserted))
+	}
+	if ed.Deleted != "" {
+		b.insertText(ed.Pos, ed.Deleted)
+	}
+}
+
+/*
+deleteLines removes whole lines first to last and returns them, each ending in
+a line break. Deleting every line leaves one empty line.
+*/
+func (b *Buffer) deleteLines(first, last int) string {
+	if last+1 < b.LineCount() {
+		return b.deleteRange(Position{Line: first}, Position{Line: last + 1})
+	}
+	end := Position{Line: last, Col: len(b.GetLine(last))}
+	if first == 0 {
+		return b.deleteRange(Position{}, end) + "\n"
+	}
+	// Take the line break before the first line instead of the missing one after the last
+	text := b.deleteRange(Position{Line: first - 1, Col: len(b.GetLine(first - 1))}, end)
+	return text[1:] + "\n"
+}
+
+/*
+insertLines inserts whole lines, as deleteLines returns them, below line after,
+or above the first line when after is -1.
+*/
+func (b *Buffer) insertLines(after int, text string) {
+	if after+1 < b.LineCount() {
+		b.insertText(Position{Line: after + 1}, text)
+		return
+	}
+	// Below the last line the line break goes before the text instead of after it
+	b.insertText(Position{Line: after, Col: len(b.GetLine(after))}, "\n"+strings.TrimSuffix(text, "\n"))
+}
+
+/*
+textEnd computes where text ends when placed at pos, accounting for embedded newlines.
+*/
+func textEnd(pos Position, text string) Position {
+	nl := strings.LastIndex(text, "\n")
+	if nl < 0 {
+		return Position{Line: pos.Line, Col: pos.Col + len(text)}
+	}
+	return Position{Line: pos.Line + strings.Count(text, "\n"), Col: len(text) - nl - 1}
 }
 
 func (b *Buffer) GetSelectedText(sel Selection) string {
@@ -171,7 +785,7 @@ func (b *Buffer) GetSelectedText(sel Selection) string {
 	}
 
 	var result strings.Builder
-	for i := start.Line; i <= end.Line && i < len(b.lines); i++ {
+	for i := start.Line; i <= end.Line && i < b.LineCount(); i++ {
 		line := b.GetLine(i)
 		if i == start.Line {
 			if start.Col < len(line) {
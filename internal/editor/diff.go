@@ -0,0 +1,210 @@
+package editor
+
+/*
+diffHunk is a region where two line sequences differ: lines [AStart, AEnd) of
+the first were replaced by lines [BStart, BEnd) of the second. Either side may
+be empty, for pure insertions and deletions.
+*/
+type diffHunk struct {
+	AStart, AEnd int
+	BStart, BEnd int
+}
+
+/*
+diffLines computes the hunks turning a into b using Myers' O(ND) algorithm, so
+the cost grows with the size of the difference rather than the size of the
+files. Common leading and trailing lines are trimmed first, since most edits
+touch a small region of a large file.
+*/
+func diffLines(a, b []string) []diffHunk {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	hunks := myersHunks(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for i := range hunks {
+		hunks[i].AStart += prefix
+		hunks[i].AEnd += prefix
+		hunks[i].BStart += prefix
+		hunks[i].BEnd += prefix
+	}
+	return hunks
+}
+
+/*
+myersHunks runs the greedy forward search, keeping each round's frontier so the
+shortest edit script can be traced back, then turns the gaps between matched
+lines into hunks.
+*/
+func myersHunks(a, b []string) []diffHunk {
+	n, m := len(a), len(b)
+	if n == 0 && m == 0 {
+		return nil
+	}
+
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+search:
+	for d := 0; d <= n+m; d++ {
+		// Frontier for k in [-d-1, d+1], stored at index k+d+1
+		trace = append(trace, append([]int(nil), v[offset-d-1:offset+d+2]...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end, collecting matched line pairs in reverse
+	type match struct{ a, b int }
+	var matches []match
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		frontier := trace[d]
+		at := func(k int) int { return frontier[k+d+1] }
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		if d == 0 {
+			prevX, prevY = 0, 0
+		}
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			matches = append(matches, match{x, y})
+		}
+		x, y = prevX, prevY
+	}
+
+	var hunks []diffHunk
+	ai, bi := 0, 0
+	for i := len(matches) - 1; i >= -1; i-- {
+		na, nb := n, m
+		if i >= 0 {
+			na, nb = matches[i].a, matches[i].b
+		}
+		if na > ai || nb > bi {
+			hunks = append(hunks, diffHunk{AStart: ai, AEnd: na, BStart: bi, BEnd: nb})
+		}
+		ai, bi = na+1, nb+1
+	}
+	return hunks
+}
+
+/*
+mergeLines performs a three-way merge of two versions descended from a common
+base. Changes made on only one side are taken as they are; overlapping changes
+that agree are taken once; overlapping changes that disagree become a conflict
+wrapped in git-style markers. Returns the merged lines and the conflict count.
+*/
+func mergeLines(base, ours, theirs []string, oursLabel, theirsLabel string) ([]string, int) {
+	oursHunks := diffLines(base, ours)
+	theirsHunks := diffLines(base, theirs)
+
+	var result []string
+	conflicts := 0
+	pos := 0
+	oursDelta, theirsDelta := 0, 0
+	oi, ti := 0, 0
+
+	for oi < len(oursHunks) || ti < len(theirsHunks) {
+		// Start a group with the earliest hunk, then absorb every hunk from
+		// either side that overlaps or touches the growing base range
+		lo := 0
+		if ti >= len(theirsHunks) || (oi < len(oursHunks) && oursHunks[oi].AStart <= theirsHunks[ti].AStart) {
+			lo = oursHunks[oi].AStart
+		} else {
+			lo = theirsHunks[ti].AStart
+		}
+		hi := lo
+		oFirst, tFirst := oi, ti
+		for {
+			grew := false
+			if oi < len(oursHunks) && oursHunks[oi].AStart <= hi {
+				hi = max(hi, oursHunks[oi].AEnd)
+				oi++
+				grew = true
+			}
+			if ti < len(theirsHunks) && theirsHunks[ti].AStart <= hi {
+				hi = max(hi, theirsHunks[ti].AEnd)
+				ti++
+				grew = true
+			}
+			if !grew {
+				break
+			}
+		}
+
+		groupDelta := func(hunks []diffHunk) int {
+			delta := 0
+			for _, h := range hunks {
+				delta += (h.BEnd - h.BStart) - (h.AEnd - h.AStart)
+			}
+			return delta
+		}
+		oGroup, tGroup := groupDelta(oursHunks[oFirst:oi]), groupDelta(theirsHunks[tFirst:ti])
+		oursPart := ours[lo+oursDelta : hi+oursDelta+oGroup]
+		theirsPart := theirs[lo+theirsDelta : hi+theirsDelta+tGroup]
+
+		result = append(result, base[pos:lo]...)
+		switch {
+		case oi == oFirst:
+			result = append(result, theirsPart...)
+		case ti == tFirst, equalLines(oursPart, theirsPart):
+			result = append(result, oursPart...)
+		default:
+			conflicts++
+			result = append(result, "<<<<<<< "+oursLabel)
+			result = append(result, oursPart...)
+			result = append(result, "=======")
+			result = append(result, theirsPart...)
+			result = append(result, ">>>>>>> "+theirsLabel)
+		}
+
+		oursDelta += oGroup
+		theirsDelta += tGroup
+		pos = hi
+	}
+
+	result = append(result, base[pos:]...)
+	return result, conflicts
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
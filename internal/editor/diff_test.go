@@ -0,0 +1,137 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+/*
+wordLines reads lines written as space-separated words, which keeps the tables
+short.
+*/
+func wordLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, " ")
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []diffHunk
+	}{
+		{"equal", "a b c", "a b c", nil},
+		{"both empty", "", "", nil},
+		{"insert", "a c", "a b c", []diffHunk{{1, 1, 1, 2}}},
+		{"delete", "a b c", "a c", []diffHunk{{1, 2, 1, 1}}},
+		{"replace", "a b c", "a x c", []diffHunk{{1, 2, 1, 2}}},
+		{"from nothing", "", "a b", []diffHunk{{0, 0, 0, 2}}},
+		{"to nothing", "a b", "", []diffHunk{{0, 2, 0, 0}}},
+		{"two regions", "a b c d e", "x b c d y", []diffHunk{{0, 1, 0, 1}, {4, 5, 4, 5}}},
+		{"moved line", "a b c", "b c a", []diffHunk{{0, 1, 0, 0}, {3, 3, 2, 3}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := wordLines(tt.a), wordLines(tt.b)
+			got := diffLines(a, b)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("diffLines(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+
+			// Applying the hunks to a must give b
+			var applied []string
+			pos := 0
+			for _, h := range got {
+				applied = append(applied, a[pos:h.AStart]...)
+				applied = append(applied, b[h.BStart:h.BEnd]...)
+				pos = h.AEnd
+			}
+			applied = append(applied, a[pos:]...)
+			if !slices.Equal(applied, b) {
+				t.Errorf("hunks turn %q into %q, want %q", tt.a, applied, b)
+			}
+		})
+	}
+}
+
+func TestMergeLines(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		conflicts          int
+	}{
+		{
+			name: "no changes",
+			base: "a b c", ours: "a b c", theirs: "a b c",
+			want: "a b c",
+		},
+		{
+			name: "only ours changed",
+			base: "a b c", ours: "a x c", theirs: "a b c",
+			want: "a x c",
+		},
+		{
+			name: "only theirs changed",
+			base: "a b c", ours: "a b c", theirs: "a b y",
+			want: "a b y",
+		},
+		{
+			name: "separate changes on both sides",
+			base: "a b c d e", ours: "x b c d e", theirs: "a b c d y",
+			want: "x b c d y",
+		},
+		{
+			name: "insertions on both sides",
+			base: "a b c d", ours: "a x b c d", theirs: "a b c y d",
+			want: "a x b c y d",
+		},
+		{
+			name: "same change on both sides",
+			base: "a b c", ours: "a x c", theirs: "a x c",
+			want: "a x c",
+		},
+		{
+			name: "deletion against an untouched line",
+			base: "a b c d", ours: "a c d", theirs: "a b c y",
+			want: "a c y",
+		},
+		{
+			name: "clashing changes",
+			base: "a b c", ours: "a x c", theirs: "a y c",
+			want:      "a <<<<<<<_buffer x ======= y >>>>>>>_disk c",
+			conflicts: 1,
+		},
+		{
+			name: "change against a deletion",
+			base: "a b c", ours: "a x c", theirs: "a c",
+			want:      "a <<<<<<<_buffer x ======= >>>>>>>_disk c",
+			conflicts: 1,
+		},
+		{
+			name: "one conflict among clean changes",
+			base: "a b c d e", ours: "x b m d e", theirs: "a b n d y",
+			want:      "x b <<<<<<<_buffer m ======= n >>>>>>>_disk d y",
+			conflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := mergeLines(wordLines(tt.base), wordLines(tt.ours), wordLines(tt.theirs), "buffer", "disk")
+			// Markers are written with _ for the space so a line splits as one word
+			want := wordLines(tt.want)
+			for i := range want {
+				want[i] = strings.ReplaceAll(want[i], "_", " ")
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("merged %q, want %q", got, want)
+			}
+			if conflicts != tt.conflicts {
+				t.Errorf("%d conflicts, want %d", conflicts, tt.conflicts)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package editor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+)
+
+/*
+diskState fingerprints the file as the buffer last saw it on disk. Modification
+time and size are a cheap first check; the content hash settles whether a file
+that was merely touched really changed.
+*/
+type diskState struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+	hash    string
+}
+
+func newDiskState(info fs.FileInfo, hash string) diskState {
+	return diskState{
+		exists:  true,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+		hash:    hash,
+	}
+}
+
+/*
+readDisk reads the buffer's file together with its fingerprint.
+*/
+func (b *Buffer) readDisk() ([]byte, diskState, error) {
+	info, err := os.Stat(b.filename)
+	if err != nil {
+		return nil, diskState{}, err
+	}
+	content, err := os.ReadFile(b.filename)
+	if err != nil {
+		return nil, diskState{}, err
+	}
+	return content, newDiskState(info, contentHash(content)), nil
+}
+
+/*
+statDisk fingerprints the buffer's file without keeping its content, hashing it
+as it streams past so even a large file is never held in memory.
+*/
+func (b *Buffer) statDisk() (diskState, error) {
+	f, err := os.Open(b.filename)
+	if err != nil {
+		return diskState{}, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return diskState{}, err
+	}
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return diskState{}, err
+	}
+	return newDiskState(info, hex.EncodeToString(sum.Sum(nil))), nil
+}
+
+/*
+DiskChanged reports whether the file on disk differs from the version the buffer
+was loaded from or last saved as. A file deleted from disk is not a conflict,
+since saving simply recreates it.
+*/
+func (b *Buffer) DiskChanged() (bool, error) {
+	if b.filename == "" {
+		return false, nil
+	}
+	info, err := os.Stat(b.filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if b.disk.exists && info.ModTime().Equal(b.disk.modTime) && info.Size() == b.disk.size {
+		return false, nil
+	}
+
+	state, err := b.statDisk()
+	if err != nil {
+		return false, err
+	}
+	if b.disk.exists && state.hash == b.disk.hash {
+		// Touched but not modified; remember the new timestamp
+		b.disk = state
+		return false, nil
+	}
+	return true, nil
+}
+
+/*
+replaceLines swaps the whole text for lines through the recorded primitives, so
+reloading or merging is one undoable edit like any other.
+*/
+func (b *Buffer) replaceLines(lines []string) {
+	last := b.LineCount() - 1
+	b.deleteRange(Position{}, Position{Line: last, Col: len(b.GetLine(last))})
+	if text := joinLines(lines); text != "" {
+		b.insertText(Position{}, text)
+	}
+}
+
+func (b *Buffer) allLines() []string {
+	lines := make([]string, 0, b.LineCount())
+	b.lines.Each(func(line string) {
+		lines = append(lines, line)
+	})
+	return lines
+}
+
+func joinLines(lines []string) string {
+	size := len(lines)
+	for _, line := range lines {
+		size += len(line)
+	}
+	out := make([]byte, 0, size)
+	for i, line := range lines {
+		if i > 0 {
+			out = append(out, '\n')
+		}
+		out = append(out, line...)
+	}
+	return string(out)
+}
+
+/*
+CheckDisk warns when the file was changed by another program, such as a git
+checkout or a formatter, while it was open. Each external version is reported
+once, so refocusing the terminal does not repeat the warning.
+*/
+func (e *Editor) CheckDisk() {
+	changed, err := e.buffer.DiskChanged()
+	if err != nil || !changed {
+		return
+	}
+	state, err := e.buffer.statDisk()
+	if err != nil || state.hash == e.diskWarned {
+		return
+	}
+	e.diskWarned = state.hash
+
+	if e.buffer.IsDirty() {
+		e.SetMessage(MessageWarning, fmt.Sprintf("W12: %q changed on disk and in the buffer: :e! to reload, :keep to keep yours, :merge to combine", e.buffer.filename))
+		return
+	}
+	e.SetMessage(MessageWarning, fmt.Sprintf("W11: %q changed on disk: :e! to reload, :keep to keep yours", e.buffer.filename))
+}
+
+/*
+Reload replaces the buffer with the file on disk, discarding unsaved changes.
+The replacement is recorded as a change, so even a forced reload can be undone.
+A large file has no undo history and is simply opened afresh.
+*/
+func (e *Editor) Reload() error {
+	if e.buffer.filename == "" {
+		return ErrNoFileName
+	}
+	if e.buffer.IsLarge() {
+		e.diskWarned = ""
+		load := e.buffer.LoadFile
+		if e.buffer.readOnly {
+			load = e.buffer.viewing(load)
+		}
+		if err := e.loadFile(e.buffer.filename, load); err != nil {
+			return err
+		}
+		e.clampCursor()
+		e.collapse()
+		return nil
+	}
+	content, state, err := e.buffer.readDisk()
+	if err != nil {
+		return err
+	}
+	lines, layout, err := decodeContent(content, e.buffer.layout.encoding)
+	if err != nil {
+		return err
+	}
+
+	e.beginChange()
+	e.buffer.replaceLines(lines)
+	e.endChange()
+
+	b := e.buffer
+	b.layout = layout
+	b.disk = state
+	b.base = lines
+	b.history.markSaved()
+	b.dirty = false
+	e.diskWarned = ""
+
+	e.clampCursor()
+	e.collapse()
+	e.SetMessage(MessageInfo, fileSummary(b.filename, b.LineCount(), len(content))+" reloaded")
+	return nil
+}
+
+/*
+KeepBuffer accepts the buffer as the version to save, ignoring the change on
+disk. The buffer stays modified since it no longer matches the file.
+*/
+func (e *Editor) KeepBuffer() error {
+	b := e.buffer
+	if b.IsLarge() {
+		state, err := b.statDisk()
+		if err != nil {
+			return err
+		}
+		b.history.diverge(nil)
+		b.disk = state
+	} else {
+		content, state, err := b.readDisk()
+		if err != nil {
+			return err
+		}
+		base, _, err := decodeContent(content, b.layout.encoding)
+		if err != nil {
+			return err
+		}
+		b.history.diverge([]edit{{Deleted: joinLines(b.allLines()), Inserted: joinLines(base)}})
+		b.disk = state
+		b.base = base
+	}
+	b.dirty = true
+	e.diskWarned = ""
+	e.SetMessage(MessageInfo, "Keeping buffer; :w will overwrite the file on disk")
+	return nil
+}
+
+/*
+Merge combines the buffer with the file on disk using the text both started from
+as the common base. Non-overlapping changes from each side are kept; clashing
+ones are left as conflict markers to resolve by hand before saving.
+*/
+func (e *Editor) Merge() error {
+	if e.buffer.IsLarge() {
+		return errors.New("E21: Merging is not available for large files; :e! or :keep")
+	}
+	if e.buffer.readOnly {
+		return ErrReadOnly
+	}
+	content, state, err := e.buffer.readDisk()
+	if err != nil {
+		return err
+	}
+	b := e.buffer
+	theirs, _, err := decodeContent(content, b.layout.encoding)
+	if err != nil {
+		return err
+	}
+
+	merged, conflicts := mergeLines(b.base, b.allLines(), theirs, "buffer", "disk")
+
+	e.beginChange()
+	b.replaceLines(merged)
+	e.endChange()
+
+	b.history.diverge([]edit{{Deleted: joinLines(merged), Inserted: joinLines(theirs)}})
+	b.disk = state
+	b.base = theirs
+	b.dirty = true
+	e.diskWarned = ""
+
+	e.clampCursor()
+	e.collapse()
+	if conflicts > 0 {
+		e.SetMessage(MessageWarning, fmt.Sprintf("Merged with %d conflicts; resolve the <<<<<<< markers before :w", conflicts))
+	} else {
+		e.SetMessage(MessageInfo, "Merged changes from disk")
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package editor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  string
+		disk  string
+		want  []string
+		level MessageLevel
+	}{
+		{
+			name:  "separate changes are combined",
+			keys:  "iX<Esc>",
+			disk:  "one\ntwo\nthree!\n",
+			want:  []string{"Xone", "two", "three!"},
+			level: MessageInfo,
+		},
+		{
+			name:  "clashing changes are marked",
+			keys:  "jiX<Esc>",
+			disk:  "one\nTWO\nthree\n",
+			want:  []string{"one", "<<<<<<< buffer", "Xtwo", "=======", "TWO", ">>>>>>> disk", "three"},
+			level: MessageWarning,
+		},
+		{
+			name:  "disk matching the buffer",
+			keys:  "iX<Esc>",
+			disk:  "Xone\ntwo\nthree\n",
+			want:  []string{"Xone", "two", "three"},
+			level: MessageInfo,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "one\ntwo\nthree\n")
+			e := openTestFile(t, filename)
+			feed(t, e, tt.keys)
+			before := e.buffer.allLines()
+			mustWrite(t, filename, tt.disk, 0644)
+			if changed, err := e.buffer.DiskChanged(); err != nil || !changed {
+				t.Fatalf("DiskChanged() = %v, %v after the file was rewritten", changed, err)
+			}
+
+			feed(t, e, ":merge<CR>")
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("merged %q, want %q", got, tt.want)
+			}
+			if msg := e.GetMessage(); msg.Level != tt.level {
+				t.Errorf("message %q at level %v, want %v", msg.Text, msg.Level, tt.level)
+			}
+			if changed, _ := e.buffer.DiskChanged(); changed {
+				t.Error("file still counts as changed on disk after merging")
+			}
+			// The merge is one step, undone back to the buffer's own version
+			feed(t, e, "u")
+			if got := e.buffer.allLines(); !slices.Equal(got, before) {
+				t.Errorf("undo after merge gives %q, want %q", got, before)
+			}
+		})
+	}
+}
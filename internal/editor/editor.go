@@ -1,9 +1,25 @@
 package editor
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+var ErrNoWrite = errors.New("E37: No write since last change (add ! to override)")
+
+var ErrUndoDisabled = errors.New("Undo is disabled for large files")
+
+var ErrReadOnly = errors.New("E21: Cannot make changes, 'readonly' is set; :set noreadonly to allow them")
+
+var ErrNotText = errors.New("E21: Cannot make changes to a file that is not text; :e ++enc=latin1 to edit it as Latin-1")
+
 /*
 Editor coordinates the editing state, integrating buffer management, cursor control,
 selection handling, and mode switching. Acts as the central state machine for all
@@ -15,7 +31,64 @@ type Editor struct {
 	selection Selection
 	mode      Mode
 	command   string
-	clipboard string
+	message   Message
+	swap      swapState
+	hex       *hexState
+
+	// Numeric prefix typed before a key in normal or visual mode; 0 when none
+	count int
+	// Prefix key waiting for the rest of its command, such as "g" or "f"
+	pending  string
+	lastFind *findState
+
+	// Lines on screen, for H, M, L and half-page scrolling
+	viewTop    int
+	viewHeight int
+	// Lines ctrl+d and ctrl+u scroll, as set by a count; 0 for half the screen
+	scroll int
+
+	diskWarned string
+
+	undoTreeIndex int
+
+	// Every selection in document order while there is more than one, nil
+	// otherwise. The primary one is mirrored in cursor and selection, which
+	// take precedence over its entry here.
+	selections []Selection
+	primary    int
+	iterating  bool
+
+	// Yanked text, one entry per selection
+	clipboard []string
+
+	// What the command line is read for, and the mode to return to after it
+	prompt       Prompt
+	promptReturn Mode
+
+	lastSearch *searchState
+	// Whether matches of the last search are highlighted; :nohlsearch clears it
+	highlight     bool
+	searchHistory []string
+	historyIndex  int
+	// Where the cursor was when the search prompt opened, for incremental search
+	searchOrigin Position
+
+	// The :s waiting for its matches to be confirmed
+	subst *substitution
+	// The :g running its command on each line
+	global *globalRun
+	// How deeply FeedKeys calls are nested
+	feeding int
+	// Set when a key fails, so replayed keys stop after it
+	failed bool
+
+	// Registers a to z, the one selected with " for the next yank or paste,
+	// and the macro being recorded with the keys typed for it so far
+	registers map[rune][]string
+	register  rune
+	recording rune
+	recorded  []string
+	lastMacro rune
 }
 
 func New() *Editor {
@@ -24,25 +97,369 @@ func New() *Editor {
 		cursor:    Position{Line: 0, Col: 0},
 		selection: NewSelection(Position{Line: 0, Col: 0}),
 		mode:      ModeNormal,
+
+		viewHeight: 1,
 	}
 }
 
+/*
+LoadFile reads a file into the buffer and reports the outcome in the message
+area, so failures are visible even though the editor owns the whole screen.
+*/
 func (e *Editor) LoadFile(filename string) error {
-	return e.buffer.LoadFile(filename)
+	return e.loadFile(filename, e.buffer.LoadFile)
+}
+
+/*
+ViewFile is LoadFile for a file that must not be changed by accident, as with -R.
+*/
+func (e *Editor) ViewFile(filename string) error {
+	return e.loadFile(filename, e.buffer.viewing(e.buffer.LoadFile))
+}
+
+/*
+LoadFileEncoding is LoadFile for a file in a known encoding.
+*/
+func (e *Editor) LoadFileEncoding(filename string, enc Encoding) error {
+	return e.loadFile(filename, func(filename string) error {
+		return e.buffer.LoadFileEncoding(filename, enc)
+	})
+}
+
+func (e *Editor) loadFile(filename string, load func(string) error) error {
+	if err := load(filename); err != nil {
+		e.SetMessage(MessageError, fmt.Sprintf("%q %v", filename, err))
+		return err
+	}
+
+	info, err := os.Stat(filename)
+	switch {
+	case err != nil:
+		e.SetMessage(MessageInfo, fmt.Sprintf("%q [New]", filename))
+	case e.buffer.Indexing():
+		e.SetMessage(MessageInfo, fmt.Sprintf("%q %s [large file] indexing...", filename, formatSize(int(info.Size()))))
+	case e.buffer.IsLarge():
+		e.SetMessage(MessageInfo, fileSummary(filename, e.buffer.LineCount(), int(info.Size()))+" [large file] undo disabled")
+	default:
+		e.SetMessage(MessageInfo, fileSummary(filename, e.buffer.LineCount(), int(info.Size())))
+	}
+	if enc := e.buffer.Encoding(); enc != EncodingUTF8 {
+		e.message.Text += " [" + enc.String() + "]"
+	}
+	if e.buffer.ReadOnly() {
+		e.message.Text += " [readonly]"
+	}
+	if reason := e.buffer.Undecodable(); reason != "" {
+		e.SetMessage(MessageWarning, fmt.Sprintf("%s [%s] read-only; :e ++enc=latin1 to edit as Latin-1", e.message.Text, reason))
+	}
+	e.openSwap()
+	return nil
+}
+
+/*
+Edit implements ":e[dit][!] [++enc=NAME] [file]". Without a file it rereads the
+current one, which with ++enc is how a file is reopened in another encoding.
+Unsaved changes block it unless forced.
+*/
+func (e *Editor) Edit(arg string, force bool) error {
+	return e.edit(arg, force, false)
+}
+
+/*
+View implements ":vie[w] [file]", which is ":edit" leaving the buffer read-only.
+Without a file it only makes the current buffer read-only.
+*/
+func (e *Editor) View(arg string) error {
+	if strings.TrimSpace(arg) == "" {
+		e.buffer.readOnly = true
+		e.SetMessage(MessageInfo, fmt.Sprintf("%q [readonly]", e.buffer.filename))
+		return nil
+	}
+	return e.edit(arg, false, true)
 }
 
-func (e *Editor) SaveFile() error {
-	return e.buffer.SaveFile()
+/*
+edit opens a file for Edit and View. Rereading a read-only file keeps it
+read-only.
+*/
+func (e *Editor) edit(arg string, force, readOnly bool) error {
+	var filename, encName string
+	for _, field := range strings.Fields(arg) {
+		if name, ok := strings.CutPrefix(field, "++enc="); ok {
+			encName = name
+		} else if filename == "" {
+			filename = field
+		} else {
+			return errors.New("E172: Only one file name allowed")
+		}
+	}
+
+	if e.buffer.IsDirty() && !force {
+		return ErrNoWrite
+	}
+	if filename == "" && encName == "" {
+		return e.Reload()
+	}
+	if filename == "" {
+		filename = e.buffer.filename
+	}
+	if filename == "" {
+		return ErrNoFileName
+	}
+
+	load := e.buffer.LoadFile
+	if encName != "" {
+		enc, err := ParseEncoding(encName)
+		if err != nil {
+			return err
+		}
+		load = func(filename string) error { return e.buffer.LoadFileEncoding(filename, enc) }
+	}
+	if filename != e.buffer.filename {
+		e.cursor = Position{}
+		e.buffer.marks = nil
+	} else if e.buffer.readOnly {
+		readOnly = true
+	}
+	if readOnly {
+		load = e.buffer.viewing(load)
+	}
+	e.diskWarned = ""
+	if err := e.loadFile(filename, load); err != nil {
+		return nil // Already reported
+	}
+	e.clampCursor()
+	e.collapse()
+	return nil
+}
+
+/*
+PollIndex advances the background indexing of a large file, announcing the line
+count once it finishes. Returns whether indexing is still running.
+*/
+func (e *Editor) PollIndex() bool {
+	if !e.buffer.Indexing() {
+		return false
+	}
+	running, err := e.buffer.PollIndex()
+	if err != nil {
+		e.SetMessage(MessageError, err.Error())
+		return false
+	}
+	if !running {
+		b := e.buffer
+		e.SetMessage(MessageInfo, fileSummary(b.filename, b.LineCount(), int(b.disk.size))+" [large file] undo disabled")
+	}
+	return running
+}
+
+/*
+editable reports whether the buffer may be changed, explaining in the message
+area why not. A large file is read-only until it has been fully indexed.
+*/
+func (e *Editor) editable() bool {
+	switch {
+	case e.buffer.Indexing():
+		e.SetMessage(MessageError, ErrIndexing.Error())
+		return false
+	case e.readOnly():
+		return false
+	case e.buffer.Undecodable() != "":
+		e.SetMessage(MessageError, ErrNotText.Error())
+		return false
+	}
+	return true
+}
+
+/*
+readOnly is the part of editable that also covers changes which do not go
+through the text, like undo and hex editing.
+*/
+func (e *Editor) readOnly() bool {
+	if e.buffer.readOnly {
+		e.SetMessage(MessageError, ErrReadOnly.Error())
+		return true
+	}
+	return false
+}
+
+/*
+SaveFile writes the buffer unless the file changed on disk since it was loaded,
+which would silently discard someone else's work, is read-only or is not text.
+force overwrites regardless; a file that is not text is then written back byte for
+byte.
+In hex mode the session's bytes are written exactly as they are.
+*/
+func (e *Editor) SaveFile(force bool) error {
+	if !force && e.buffer.readOnly {
+		err := errors.New("E45: 'readonly' option is set (add ! to override)")
+		e.SetMessage(MessageError, err.Error())
+		return err
+	}
+	if !force && e.buffer.Undecodable() != "" && e.hex == nil {
+		err := fmt.Errorf("E21: %q is %s and opened read-only; :w! to write it back unchanged", e.buffer.filename, e.buffer.Undecodable())
+		e.SetMessage(MessageError, err.Error())
+		return err
+	}
+	if !force {
+		changed, err := e.buffer.DiskChanged()
+		if err == nil && changed {
+			err = fmt.Errorf("W12: %q changed on disk; :w! to overwrite, :merge to combine, :e! to reload", e.buffer.filename)
+		}
+		if err != nil {
+			e.SetMessage(MessageError, err.Error())
+			return err
+		}
+	}
+
+	var size int
+	var err error
+	var switched Encoding
+	if e.hex != nil {
+		// The bytes are the file whatever they are; text the encoding cannot
+		// decode follows them as UTF-8
+		if e.syncHex(e.buffer.layout.encoding) != nil {
+			switched = e.buffer.layout.encoding
+			e.syncHex(EncodingUTF8)
+		}
+		size, err = e.buffer.saveBytes(e.hex.data)
+	} else {
+		size, err = e.buffer.SaveFile()
+	}
+	if err != nil {
+		e.SetMessage(MessageError, err.Error())
+		return err
+	}
+	written := fileSummary(e.buffer.GetFilename(), e.buffer.LineCount(), size) + " written"
+	level := MessageInfo
+	if switched != EncodingUTF8 {
+		written += fmt.Sprintf("; not valid %s, now edited as %s", switched, EncodingUTF8)
+		level = MessageWarning
+	}
+	if err := e.buffer.saveHistory(); err != nil {
+		written += fmt.Sprintf("; undo history not saved: %v", err)
+		level = MessageWarning
+	}
+	e.SetMessage(level, written)
+	return nil
+}
+
+/*
+WriteFile implements ":w[rite][!] [file]". Without a file, or naming the
+buffer's own, it saves the buffer. A buffer without a file takes the name and is
+saved to it; otherwise the text is written to the other file as a copy and the
+buffer stays as it was. An existing file is only overwritten when forced.
+*/
+func (e *Editor) WriteFile(name string, force bool) error {
+	if name == "" || sameFile(name, e.buffer.filename) {
+		return e.SaveFile(force)
+	}
+	if _, err := os.Stat(name); err == nil && !force {
+		err := errors.New("E13: File exists (add ! to override)")
+		e.SetMessage(MessageError, err.Error())
+		return err
+	}
+	if e.buffer.filename == "" {
+		e.buffer.filename = name
+		if err := e.SaveFile(true); err != nil {
+			e.buffer.filename = ""
+			return err
+		}
+		e.openSwap()
+		return nil
+	}
+
+	var size int
+	var err error
+	if e.hex != nil {
+		size, err = writeCopy(name, writeBytes(e.hex.data))
+	} else {
+		size, err = e.buffer.WriteCopy(name)
+	}
+	if err != nil {
+		e.SetMessage(MessageError, err.Error())
+		return err
+	}
+	e.SetMessage(MessageInfo, fileSummary(name, e.buffer.LineCount(), size)+" written")
+	return nil
+}
+
+/*
+sameFile reports whether two names refer to the same file, through symlinks and
+relative paths alike.
+*/
+func sameFile(a, b string) bool {
+	if a == "" || b == "" {
+		return false
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA == nil && errB == nil {
+		return os.SameFile(infoA, infoB)
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func (e *Editor) GetMessage() Message {
+	return e.message
+}
+
+/*
+SetMessage shows text in the message area. An error also fails the key being
+handled, which stops replayed keys.
+*/
+func (e *Editor) SetMessage(level MessageLevel, text string) {
+	e.message = Message{Text: text, Level: level}
+	if level == MessageError {
+		e.failed = true
+	}
+}
+
+func (e *Editor) ClearMessage() {
+	e.message = Message{}
+}
+
+/*
+reportError shows err in the message area, doing nothing for a nil error so
+command results can be routed through it unconditionally.
+*/
+func (e *Editor) reportError(err error) {
+	if err != nil {
+		e.SetMessage(MessageError, err.Error())
+	}
 }
 
 func (e *Editor) GetMode() Mode {
 	return e.mode
 }
 
+/*
+SetMode switches modes. Entering insert mode opens an undo group that stays open
+until insert mode is left, so a whole typing session undoes as one step.
+*/
 func (e *Editor) SetMode(mode Mode) {
+	if mode == ModeInsert && !e.editable() {
+		return
+	}
+	if mode == ModeInsert && e.mode != ModeInsert {
+		e.beginChange()
+	} else if mode != ModeInsert && e.mode == ModeInsert {
+		e.endChange()
+	}
+	if e.mode == ModeVisual && mode != ModeVisual && !e.selection.IsEmpty() {
+		e.buffer.markSelection(e.selection)
+	}
 	e.mode = mode
 	if mode != ModeVisual {
 		e.selection = NewSelection(e.cursor)
+		if !e.iterating {
+			for i := range e.selections {
+				e.selections[i] = NewSelection(e.selections[i].Head)
+			}
+			e.mergeSelections()
+		}
 	}
 }
 
@@ -78,10 +495,11 @@ func (e *Editor) clampCursor() {
 		e.cursor.Line = 0
 	}
 
-	lineLen := len(e.buffer.GetLine(e.cursor.Line))
+	line := e.buffer.GetLine(e.cursor.Line)
+	lineLen := len(line)
 	if e.mode == ModeNormal && lineLen > 0 {
 		if e.cursor.Col >= lineLen {
-			e.cursor.Col = lineLen - 1
+			e.cursor.Col = prevGrapheme(line, lineLen)
 		}
 	} else {
 		if e.cursor.Col > lineLen {
@@ -91,265 +509,554 @@ func (e *Editor) clampCursor() {
 	if e.cursor.Col < 0 {
 		e.cursor.Col = 0
 	}
+	e.cursor.Col = graphemeStart(line, e.cursor.Col)
 }
 
+/*
+MoveCursor moves by whole lines and grapheme clusters. Vertical moves keep the
+cursor in the same screen column rather than the same byte offset, so it does not
+drift when lines mix ASCII with wide or multibyte characters.
+*/
 func (e *Editor) MoveCursor(dLine, dCol int) {
-	e.cursor.Line += dLine
-	e.cursor.Col += dCol
-	e.clampCursor()
+	e.each(func() {
+		if dLine != 0 {
+			cell := DisplayColumn(e.buffer.GetLine(e.cursor.Line), e.cursor.Col)
+			e.cursor.Line = min(max(e.cursor.Line+dLine, 0), e.buffer.LineCount()-1)
+			e.cursor.Col = columnForDisplay(e.buffer.GetLine(e.cursor.Line), cell)
+		}
 
-	if e.mode == ModeVisual {
-		e.selection.ExtendTo(e.cursor)
-	} else {
-		e.selection = NewSelection(e.cursor)
-	}
+		line := e.buffer.GetLine(e.cursor.Line)
+		for n := dCol; n > 0; n-- {
+			e.cursor.Col = nextGrapheme(line, e.cursor.Col)
+		}
+		for n := dCol; n < 0; n++ {
+			e.cursor.Col = prevGrapheme(line, e.cursor.Col)
+		}
+		e.clampCursor()
+
+		if e.mode == ModeVisual {
+			e.selection.ExtendTo(e.cursor)
+		} else {
+			e.selection = NewSelection(e.cursor)
+		}
+	})
 }
 
 func (e *Editor) MoveCursorTo(pos Position) {
-	e.cursor = pos
-	e.clampCursor()
+	e.each(func() {
+		e.cursor = pos
+		e.clampCursor()
 
-	if e.mode == ModeVisual {
-		e.selection.ExtendTo(e.cursor)
-	} else {
-		e.selection = NewSelection(e.cursor)
-	}
+		if e.mode == ModeVisual {
+			e.selection.ExtendTo(e.cursor)
+		} else {
+			e.selection = NewSelection(e.cursor)
+		}
+	})
 }
 
 func (e *Editor) MoveToLineStart() {
-	e.cursor.Col = 0
-	if e.mode == ModeVisual {
-		e.selection.ExtendTo(e.cursor)
-	} else {
-		e.selection = NewSelection(e.cursor)
-	}
+	e.each(func() {
+		e.cursor.Col = 0
+		if e.mode == ModeVisual {
+			e.selection.ExtendTo(e.cursor)
+		} else {
+			e.selection = NewSelection(e.cursor)
+		}
+	})
 }
 
 func (e *Editor) MoveToLineEnd() {
-	lineLen := len(e.buffer.GetLine(e.cursor.Line))
-	if e.mode == ModeNormal && lineLen > 0 {
-		e.cursor.Col = lineLen - 1
-	} else {
-		e.cursor.Col = lineLen
-	}
-	if e.mode == ModeVisual {
-		e.selection.ExtendTo(e.cursor)
-	} else {
-		e.selection = NewSelection(e.cursor)
-	}
+	e.each(func() {
+		line := e.buffer.GetLine(e.cursor.Line)
+		lineLen := len(line)
+		if e.mode == ModeNormal && lineLen > 0 {
+			e.cursor.Col = prevGrapheme(line, lineLen)
+		} else {
+			e.cursor.Col = lineLen
+		}
+		if e.mode == ModeVisual {
+			e.selection.ExtendTo(e.cursor)
+		} else {
+			e.selection = NewSelection(e.cursor)
+		}
+	})
 }
 
 /*
-MoveWordForward implements word-wise forward navigation. Words are defined as
-contiguous alphanumeric characters plus underscore. Moves to next line if at
-end of current line. Updates selection in visual mode.
+SelectLine selects count whole lines starting at the cursor's, fewer if the
+buffer ends first.
 */
-func (e *Editor) MoveWordForward() {
-	line := e.buffer.GetLine(e.cursor.Line)
-	col := e.cursor.Col
+func (e *Editor) SelectLine(count int) {
+	e.each(func() {
+		e.selection.Anchor = Position{Line: e.cursor.Line, Col: 0}
+		last := min(e.cursor.Line+max(count, 1)-1, e.buffer.LineCount()-1)
+		e.selection.Head = Position{Line: last, Col: len(e.buffer.GetLine(last))}
+		e.cursor = e.selection.Head
+	})
+}
 
-	inWord := false
-	for col < len(line) {
-		isAlphaNum := (line[col] >= 'a' && line[col] <= 'z') ||
-			(line[col] >= 'A' && line[col] <= 'Z') ||
-			(line[col] >= '0' && line[col] <= '9') ||
-			line[col] == '_'
+func (e *Editor) SelectWord() {
+	e.each(func() {
+		line := e.buffer.GetLine(e.cursor.Line)
+		if e.cursor.Col >= len(line) {
+			return
+		}
+
+		start := e.cursor.Col
+		end := e.cursor.Col
 
-		if !inWord && isAlphaNum {
-			inWord = true
-		} else if inWord && !isAlphaNum {
-			break
+		isAlphaNum := func(ch byte) bool {
+			return (ch >= 'a' && ch <= 'z') ||
+				(ch >= 'A' && ch <= 'Z') ||
+				(ch >= '0' && ch <= '9') ||
+				ch == '_'
 		}
-		col++
-	}
 
-	if col == e.cursor.Col && e.cursor.Line < e.buffer.LineCount()-1 {
-		e.cursor.Line++
-		e.cursor.Col = 0
-	} else {
-		e.cursor.Col = col
-	}
+		if !isAlphaNum(line[start]) {
+			return
+		}
 
-	e.clampCursor()
-	if e.mode == ModeVisual {
-		e.selection.ExtendTo(e.cursor)
-	} else {
-		e.selection = NewSelection(e.cursor)
-	}
+		for start > 0 && isAlphaNum(line[start-1]) {
+			start--
+		}
+
+		for end < len(line) && isAlphaNum(line[end]) {
+			end++
+		}
+
+		e.selection.Anchor = Position{Line: e.cursor.Line, Col: start}
+		e.selection.Head = Position{Line: e.cursor.Line, Col: end}
+		e.cursor = e.selection.Head
+	})
 }
 
-func (e *Editor) MoveWordBackward() {
-	if e.cursor.Col == 0 {
-		if e.cursor.Line > 0 {
-			e.cursor.Line--
-			e.cursor.Col = len(e.buffer.GetLine(e.cursor.Line))
+func (e *Editor) DeleteSelection() {
+	e.each(func() {
+		if !e.selection.IsEmpty() && e.editable() {
+			e.beginChange()
+			e.cursor = e.buffer.DeleteSelection(e.selection)
+			e.selection = NewSelection(e.cursor)
+			e.clampCursor()
+			e.endChange()
 		}
-	} else {
-		line := e.buffer.GetLine(e.cursor.Line)
-		col := e.cursor.Col - 1
+	})
+}
 
-		for col > 0 && line[col] == ' ' {
-			col--
+/*
+ChangeSelection deletes the selection and enters insert mode as a single undo
+step, so undoing a change restores the original text in one go.
+*/
+func (e *Editor) ChangeSelection() {
+	e.each(func() {
+		if !e.editable() {
+			return
 		}
+		sel := e.selection
+		e.SetMode(ModeInsert)
+		if !sel.IsEmpty() {
+			e.cursor = e.buffer.DeleteSelection(sel)
+			e.selection = NewSelection(e.cursor)
+			e.clampCursor()
+		}
+	})
+}
 
-		for col > 0 {
-			isAlphaNum := (line[col-1] >= 'a' && line[col-1] <= 'z') ||
-				(line[col-1] >= 'A' && line[col-1] <= 'Z') ||
-				(line[col-1] >= '0' && line[col-1] <= '9') ||
-				line[col-1] == '_'
+/*
+YankSelection copies the text of every selection, keeping one clipboard entry
+per selection so pasting into as many selections gives each its own. With a
+register selected the text goes there too.
+*/
+func (e *Editor) YankSelection() {
+	register := e.takeRegister()
+	var texts []string
+	e.each(func() {
+		texts = append(texts, e.buffer.GetSelectedText(e.selection))
+	})
+	if strings.Join(texts, "") == "" {
+		return
+	}
+	// each visits selections from last to first
+	slices.Reverse(texts)
+	e.clipboard = texts
+	if register != 0 {
+		e.setRegister(register, texts)
+	}
+}
 
-			currentIsAlphaNum := (line[col] >= 'a' && line[col] <= 'z') ||
-				(line[col] >= 'A' && line[col] <= 'Z') ||
-				(line[col] >= '0' && line[col] <= '9') ||
-				line[col] == '_'
+/*
+Paste inserts the clipboard, or the selected register, count times at the
+cursor as a single change. When there are as many selections as entries each
+selection gets its own entry; otherwise every selection gets all of them, one
+per line.
+*/
+func (e *Editor) Paste(count int) {
+	texts := e.registerText(e.takeRegister())
+	if len(texts) == 0 || !e.editable() {
+		return
+	}
 
-			if !isAlphaNum && currentIsAlphaNum {
-				break
+	if len(texts) != len(e.GetSelections()) {
+		texts = []string{strings.Join(texts, "\n")}
+	}
+	next := len(texts) - 1
+	e.beginChange()
+	e.each(func() {
+		lines := strings.Split(texts[max(next, 0)], "\n")
+		next--
+		for range max(count, 1) {
+			for i, line := range lines {
+				for _, ch := range line {
+					e.cursor = e.buffer.InsertChar(e.cursor, ch)
+				}
+				if i < len(lines)-1 {
+					e.cursor = e.buffer.InsertNewline(e.cursor)
+				}
 			}
-			col--
 		}
+		e.selection = NewSelection(e.cursor)
+	})
+	e.endChange()
+}
 
-		e.cursor.Col = col
-	}
+func (e *Editor) InsertChar(ch rune) {
+	e.each(func() {
+		if !e.editable() {
+			return
+		}
+		e.beginChange()
+		e.cursor = e.buffer.InsertChar(e.cursor, ch)
+		e.selection = NewSelection(e.cursor)
+		e.endChange()
+	})
+}
 
-	e.clampCursor()
-	if e.mode == ModeVisual {
-		e.selection.ExtendTo(e.cursor)
-	} else {
+func (e *Editor) InsertNewline() {
+	e.each(func() {
+		if !e.editable() {
+			return
+		}
+		e.beginChange()
+		e.cursor = e.buffer.InsertNewline(e.cursor)
 		e.selection = NewSelection(e.cursor)
-	}
+		e.endChange()
+	})
 }
 
-func (e *Editor) SelectLine() {
-	e.selection.Anchor = Position{Line: e.cursor.Line, Col: 0}
-	lineLen := len(e.buffer.GetLine(e.cursor.Line))
-	e.selection.Head = Position{Line: e.cursor.Line, Col: lineLen}
-	e.cursor = e.selection.Head
+func (e *Editor) Backspace() {
+	e.each(func() {
+		if !e.editable() {
+			return
+		}
+		e.beginChange()
+		e.cursor = e.buffer.DeleteChar(e.cursor)
+		e.selection = NewSelection(e.cursor)
+		e.clampCursor()
+		e.endChange()
+	})
 }
 
-func (e *Editor) SelectWord() {
-	line := e.buffer.GetLine(e.cursor.Line)
-	if e.cursor.Col >= len(line) {
+/*
+Undo reverts the most recent change and restores the cursor and selection that
+were current when it began. A non-empty selection is restored in visual mode so
+the text an operator acted on is highlighted again.
+*/
+func (e *Editor) Undo() {
+	if e.buffer.history.depth > 0 {
+		// Replayed keys cannot undo the change they are part of
 		return
 	}
-
-	start := e.cursor.Col
-	end := e.cursor.Col
-
-	isAlphaNum := func(ch byte) bool {
-		return (ch >= 'a' && ch <= 'z') ||
-			(ch >= 'A' && ch <= 'Z') ||
-			(ch >= '0' && ch <= '9') ||
-			ch == '_'
+	if e.buffer.history.disabled {
+		e.SetMessage(MessageWarning, ErrUndoDisabled.Error())
+		return
 	}
-
-	if !isAlphaNum(line[start]) {
+	if e.readOnly() {
+		return
+	}
+	ch := e.buffer.undo()
+	if ch == nil {
+		e.SetMessage(MessageWarning, "Already at oldest change")
 		return
 	}
+	e.cursor = ch.cursorBefore
+	e.collapse()
+	if ch.selBefore.IsEmpty() {
+		e.clampCursor()
+		e.selection = NewSelection(e.cursor)
+		return
+	}
+	e.mode = ModeVisual
+	e.selection = ch.selBefore
+	e.cursor = ch.selBefore.Head
+}
 
-	for start > 0 && isAlphaNum(line[start-1]) {
-		start--
+func (e *Editor) Redo() {
+	if e.buffer.history.depth > 0 {
+		// Replayed keys cannot undo the change they are part of
+		return
+	}
+	if e.buffer.history.disabled {
+		e.SetMessage(MessageWarning, ErrUndoDisabled.Error())
+		return
+	}
+	if e.readOnly() {
+		return
+	}
+	ch := e.buffer.redo()
+	if ch == nil {
+		e.SetMessage(MessageWarning, "Already at newest change")
+		return
 	}
+	e.cursor = ch.cursorAfter
+	e.clampCursor()
+	e.collapse()
+}
+
+/*
+Earlier travels back through the undo tree, crossing branches as needed. A bare
+count moves that many states back in creation order; a count suffixed with s, m,
+h or d moves to the state the buffer was in that long ago.
+*/
+func (e *Editor) Earlier(arg string) error {
+	return e.timeTravel(arg, -1)
+}
 
-	for end < len(line) && isAlphaNum(line[end]) {
-		end++
+func (e *Editor) Later(arg string) error {
+	return e.timeTravel(arg, 1)
+}
+
+func (e *Editor) timeTravel(arg string, direction int) error {
+	if e.buffer.history.disabled {
+		return ErrUndoDisabled
+	}
+	if e.buffer.readOnly {
+		return ErrReadOnly
+	}
+	steps, span, ok := parseTravelArg(arg)
+	if !ok {
+		return fmt.Errorf("E475: Invalid argument: %s", arg)
 	}
 
-	e.selection.Anchor = Position{Line: e.cursor.Line, Col: start}
-	e.selection.Head = Position{Line: e.cursor.Line, Col: end}
-	e.cursor = e.selection.Head
+	h := e.buffer.history
+	target := h.nodeBySeq(h.current.seq + direction*steps)
+	if span > 0 {
+		target = h.nodeAtTime(h.current.time.Add(time.Duration(direction) * span))
+	}
+	e.travelTo(target)
+	return nil
 }
 
-func (e *Editor) DeleteSelection() {
-	if !e.selection.IsEmpty() {
-		e.cursor = e.buffer.DeleteSelection(e.selection)
-		e.selection = NewSelection(e.cursor)
-		e.clampCursor()
+func (e *Editor) travelTo(target *undoNode) {
+	cursor, moved := e.buffer.travel(target)
+	if !moved {
+		return
 	}
+	e.cursor = cursor
+	e.clampCursor()
+	e.collapse()
 }
 
-func (e *Editor) YankSelection() {
-	if !e.selection.IsEmpty() {
-		e.clipboard = e.buffer.GetSelectedText(e.selection)
+/*
+parseTravelArg reads the argument to :earlier/:later. An empty argument means one
+step; a trailing unit turns the count into a duration.
+*/
+func parseTravelArg(arg string) (int, time.Duration, bool) {
+	arg = strings.TrimSpace(arg)
+	if arg == "" {
+		return 1, 0, true
+	}
+
+	units := map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
 	}
+	unit, hasUnit := units[arg[len(arg)-1]]
+	if hasUnit {
+		arg = arg[:len(arg)-1]
+	}
+
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 0 {
+		return 0, 0, false
+	}
+	if hasUnit {
+		return 0, time.Duration(n) * unit, true
+	}
+	return n, 0, true
 }
 
-func (e *Editor) Paste() {
-	if e.clipboard == "" {
+/*
+OpenUndoTree enters the undo tree overlay with the current state highlighted.
+Moving the highlight travels the buffer to that state immediately, so the tree
+doubles as a live preview of every version of the text.
+*/
+func (e *Editor) OpenUndoTree() {
+	if e.readOnly() {
 		return
 	}
-
-	lines := strings.Split(e.clipboard, "\n")
-	if len(lines) == 1 {
-		for _, ch := range e.clipboard {
-			e.cursor = e.buffer.InsertChar(e.cursor, ch)
-		}
-	} else {
-		for i, line := range lines {
-			for _, ch := range line {
-				e.cursor = e.buffer.InsertChar(e.cursor, ch)
-			}
-			if i < len(lines)-1 {
-				e.cursor = e.buffer.InsertNewline(e.cursor)
-			}
+	e.SetMode(ModeUndoTree)
+	e.undoTreeIndex = 0
+	for i, entry := range e.UndoTree() {
+		if entry.Current {
+			e.undoTreeIndex = i
 		}
 	}
-	e.selection = NewSelection(e.cursor)
 }
 
-func (e *Editor) InsertChar(ch rune) {
-	e.cursor = e.buffer.InsertChar(e.cursor, ch)
-	e.selection = NewSelection(e.cursor)
+func (e *Editor) MoveUndoTreeSelection(delta int) {
+	entries := e.UndoTree()
+	e.undoTreeIndex += delta
+	if e.undoTreeIndex < 0 {
+		e.undoTreeIndex = 0
+	}
+	if e.undoTreeIndex >= len(entries) {
+		e.undoTreeIndex = len(entries) - 1
+	}
+	e.travelTo(e.buffer.history.nodeBySeq(entries[e.undoTreeIndex].Seq))
 }
 
-func (e *Editor) InsertNewline() {
-	e.cursor = e.buffer.InsertNewline(e.cursor)
-	e.selection = NewSelection(e.cursor)
+func (e *Editor) UndoTree() []UndoTreeEntry {
+	return e.buffer.history.entries()
 }
 
-func (e *Editor) Backspace() {
-	e.cursor = e.buffer.DeleteChar(e.cursor)
-	e.selection = NewSelection(e.cursor)
-	e.clampCursor()
+func (e *Editor) UndoTreeSelection() int {
+	return e.undoTreeIndex
+}
+
+func (e *Editor) beginChange() {
+	e.buffer.history.begin(e.cursor, e.selection)
+}
+
+func (e *Editor) endChange() {
+	e.buffer.history.commit(e.cursor)
+}
+
+/*
+SetOption applies a ":set name=value" assignment. The file format and encoding
+accept vim's long and short names; largefile sets the size, such as 64M, from
+which files are opened in large-file mode, taking effect for the next file loaded.
+*/
+func (e *Editor) SetOption(arg string) error {
+	name, value, _ := strings.Cut(strings.TrimSpace(arg), "=")
+	switch name {
+	case "fileformat", "ff":
+		format, err := ParseFileFormat(value)
+		if err != nil {
+			return err
+		}
+		if !e.editable() {
+			return nil
+		}
+		e.buffer.SetFileFormat(format)
+		return nil
+	case "fileencoding", "fenc":
+		enc, err := ParseEncoding(value)
+		if err != nil {
+			return err
+		}
+		if e.buffer.IsLarge() && enc != EncodingUTF8 {
+			return errors.New("E21: Large files can only be saved as UTF-8")
+		}
+		if !e.editable() {
+			return nil
+		}
+		e.buffer.SetEncoding(enc)
+		return nil
+	case "readonly", "ro":
+		e.buffer.readOnly = true
+		return nil
+	case "noreadonly", "noro":
+		e.buffer.readOnly = false
+		return nil
+	case "largefile":
+		size, err := parseSize(value)
+		if err != nil {
+			return err
+		}
+		e.buffer.largeFileThreshold = size
+		return nil
+	}
+	return fmt.Errorf("E518: Unknown option: %s", name)
 }
 
 func (e *Editor) AppendCommand(ch rune) {
 	e.command += string(ch)
+	e.incsearch()
 }
 
 func (e *Editor) BackspaceCommand() {
 	if len(e.command) > 0 {
-		e.command = e.command[:len(e.command)-1]
+		_, size := utf8.DecodeLastRuneInString(e.command)
+		e.command = e.command[:len(e.command)-size]
 	}
+	e.incsearch()
 }
 
 func (e *Editor) ClearCommand() {
 	e.command = ""
 }
 
+/*
+maxCount bounds the numeric prefix so a long run of digits cannot overflow it.
+*/
+const maxCount = 99999999
+
+/*
+AppendCount adds a typed digit to the pending numeric prefix.
+*/
+func (e *Editor) AppendCount(digit int) {
+	e.count = min(e.count*10+digit, maxCount)
+}
+
+func (e *Editor) GetCount() int {
+	return e.count
+}
+
+/*
+TakeCount consumes the pending numeric prefix, returning 1 if none was typed, so
+every key can treat its count as a repeat count.
+*/
+func (e *Editor) TakeCount() int {
+	count := max(e.count, 1)
+	e.count = 0
+	return count
+}
+
+func (e *Editor) SetPending(prefix string) {
+	e.pending = prefix
+}
+
+func (e *Editor) GetPending() string {
+	return e.pending
+}
+
+/*
+TakePending consumes the prefix key waiting for the rest of its command.
+*/
+func (e *Editor) TakePending() string {
+	pending := e.pending
+	e.pending = ""
+	return pending
+}
+
 /*
 ExecuteCommand processes command-line input. Returns true if the command
-requests editor termination. Implements basic vim-style commands for file
-operations and quitting.
+requests editor termination. The line is parsed as an ex command with an
+optional range and run from the command registry; every outcome, including
+failures, lands in the message area. At a selection prompt the input is a regex
+for that command.
 */
 func (e *Editor) ExecuteCommand() bool {
-	cmd := strings.TrimSpace(e.command)
-	e.command = ""
-
-	switch cmd {
-	case "w":
-		e.SaveFile()
+	if e.prompt != PromptCommand {
+		e.runPrompt()
 		return false
-	case "q":
-		if !e.buffer.IsDirty() {
-			return true
-		}
-	case "q!":
-		return true
-	case "wq":
-		e.SaveFile()
-		return true
 	}
+	cmd := strings.TrimSpace(e.command)
+	e.command = ""
 
-	return false
+	quit, err := e.runEx(cmd)
+	e.reportError(err)
+	return quit
 }
\ No newline at end of file
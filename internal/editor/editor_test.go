@@ -0,0 +1,86 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+		msg  string
+	}{
+		{name: "insert", keys: "ix<Esc>"},
+		{name: "append", keys: "ax<Esc>"},
+		{name: "delete", keys: "xd"},
+		{name: "change", keys: "xcx<Esc>"},
+		{name: "paste", keys: "xyp"},
+		{name: "undo", keys: "u"},
+		{name: "redo", keys: "u<C-r>"},
+		{name: "record and replay a macro", keys: "qaix<Esc>q@a"},
+		{name: ":s", keys: ":s/a/b/<CR>"},
+		{name: ":s with confirmation", keys: ":s/a/b/c<CR>y"},
+		{name: ":g", keys: ":g/a/d<CR>"},
+		{name: ":m", keys: ":m$<CR>"},
+		{name: ":t", keys: ":t.<CR>"},
+		{name: ":d", keys: ":d<CR>"},
+		{name: ":normal", keys: ":normal ix<CR>"},
+		{name: ":earlier", keys: ":earlier 1<CR>"},
+		{name: ":set ff", keys: ":set ff=dos<CR>"},
+		{name: ":set fenc", keys: ":set fenc=utf-16le<CR>"},
+		{name: ":merge", keys: ":merge<CR>"},
+		{name: ":recover", keys: ":recover<CR>", msg: "E305"},
+		{name: "hex input", keys: ":hex<CR>41"},
+		{name: "hex insert", keys: ":hex<CR>i41"},
+		{name: "hex delete", keys: ":hex<CR>x"},
+		{name: ":w", keys: ":w<CR>", msg: "E45"},
+		{name: ":wq", keys: ":wq<CR>", msg: "E45"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "abc\ndef\n")
+			e := openTestFile(t, filename)
+			// An undoable change, saved, so undo and redo have something to do
+			feed(t, e, "iX<Esc>:w<CR>u:w<CR>:set ro<CR>")
+			before := e.buffer.allLines()
+
+			typeKeys(e, tt.keys)
+			if msg := e.GetMessage(); tt.msg == "" && !strings.HasPrefix(msg.Text, ErrReadOnly.Error()) {
+				t.Errorf("message %q, want %q", msg.Text, ErrReadOnly)
+			}
+			if msg := e.GetMessage().Text; tt.msg != "" && !strings.HasPrefix(msg, tt.msg) {
+				t.Errorf("message %q, want %s", msg, tt.msg)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, before) {
+				t.Errorf("lines %q, want %q", got, before)
+			}
+			if e.buffer.IsDirty() || (e.hex != nil && e.hex.modified) {
+				t.Error("read-only buffer modified")
+			}
+			assertFile(t, filename, "abc\ndef\n")
+		})
+	}
+}
+
+func TestReadOnlyOverride(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+		want string
+	}{
+		{name: ":w! writes", keys: ":set noro<CR>ix<Esc>:set ro<CR>:w!<CR>", want: "xabc\n"},
+		{name: ":set noro allows edits", keys: ":set noro<CR>ix<Esc>:w<CR>", want: "xabc\n"},
+		{name: ":view", keys: ":view<CR>ix<Esc>:w<CR>", want: "abc\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "abc\n")
+			e := openTestFile(t, filename)
+			feed(t, e, ":set ro<CR>")
+			typeKeys(e, tt.keys)
+			assertFile(t, filename, tt.want)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package editor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+/*
+Encoding is the character encoding a file is stored in. Lines are always held
+as UTF-8; other encodings are decoded on load and encoded again on save.
+*/
+type Encoding int
+
+const (
+	EncodingUTF8 Encoding = iota
+	EncodingLatin1
+	EncodingUTF16LE
+	EncodingUTF16BE
+)
+
+func (enc Encoding) String() string {
+	switch enc {
+	case EncodingLatin1:
+		return "latin1"
+	case EncodingUTF16LE:
+		return "utf-16le"
+	case EncodingUTF16BE:
+		return "utf-16be"
+	default:
+		return "utf-8"
+	}
+}
+
+/*
+ParseEncoding accepts vim's names for the supported encodings. A bare utf-16 is
+little-endian, the byte order Windows tools write.
+*/
+func ParseEncoding(name string) (Encoding, error) {
+	switch name {
+	case "utf-8", "utf8":
+		return EncodingUTF8, nil
+	case "latin1", "iso-8859-1":
+		return EncodingLatin1, nil
+	case "utf-16", "utf-16le", "utf16", "utf16le":
+		return EncodingUTF16LE, nil
+	case "utf-16be", "utf16be":
+		return EncodingUTF16BE, nil
+	}
+	return EncodingUTF8, fmt.Errorf("E474: Invalid argument: encoding=%s", name)
+}
+
+var (
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
+type byteOrder interface {
+	binary.ByteOrder
+	binary.AppendByteOrder
+}
+
+/*
+utf16Order returns the byte order of a UTF-16 encoding and its byte order mark.
+*/
+func utf16Order(enc Encoding) (byteOrder, []byte) {
+	if enc == EncodingUTF16BE {
+		return binary.BigEndian, utf16BEBOM
+	}
+	return binary.LittleEndian, utf16LEBOM
+}
+
+/*
+binarySniffSize is how much of a file is searched for NUL bytes, the same
+heuristic git uses to tell binary files from text.
+*/
+const binarySniffSize = 8000
+
+/*
+sniffEncoding picks the encoding of a file from its leading bytes. Only a UTF-16
+byte order mark is conclusive; everything else is taken as UTF-8 and checked by
+undecodable.
+*/
+func sniffEncoding(head []byte) Encoding {
+	switch {
+	case bytes.HasPrefix(head, utf16LEBOM):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(head, utf16BEBOM):
+		return EncodingUTF16BE
+	}
+	return EncodingUTF8
+}
+
+/*
+undecodable explains why content read as UTF-8 is not safe to edit as text:
+it is binary, or it is not valid UTF-8 and editing would mix encodings. Returns
+the empty string for text. content may be a prefix of the file, in which case a
+multi-byte sequence cut off at its end is not held against it.
+*/
+func undecodable(content []byte, prefix bool) string {
+	if bytes.IndexByte(content[:min(len(content), binarySniffSize)], 0) >= 0 {
+		return "binary"
+	}
+	if prefix {
+		content = trimPartialRune(content)
+	}
+	if !utf8.Valid(content) {
+		return "invalid UTF-8"
+	}
+	return ""
+}
+
+func trimPartialRune(b []byte) []byte {
+	for k := 1; k <= utf8.UTFMax && k <= len(b); k++ {
+		if utf8.RuneStart(b[len(b)-k]) {
+			if !utf8.FullRune(b[len(b)-k:]) {
+				return b[:len(b)-k]
+			}
+			break
+		}
+	}
+	return b
+}
+
+/*
+decodeContent converts file bytes in enc to UTF-8 and splits them into lines.
+A UTF-16 byte order mark is consumed here and remembered in the layout like a
+UTF-8 one, so saving writes it back.
+*/
+func decodeContent(content []byte, enc Encoding) ([]string, fileLayout, error) {
+	var text []byte
+	bom := false
+	switch enc {
+	case EncodingLatin1:
+		text = make([]byte, 0, len(content))
+		for _, c := range content {
+			text = utf8.AppendRune(text, rune(c))
+		}
+	case EncodingUTF16LE, EncodingUTF16BE:
+		order, mark := utf16Order(enc)
+		if bytes.HasPrefix(content, mark) {
+			bom = true
+			content = content[len(mark):]
+		}
+		if len(content)%2 != 0 {
+			return nil, fileLayout{}, fmt.Errorf("E902: Illegal byte sequence: odd length for %s", enc)
+		}
+		units := make([]uint16, len(content)/2)
+		for i := range units {
+			units[i] = order.Uint16(content[2*i:])
+		}
+		text = []byte(string(utf16.Decode(units)))
+	default:
+		lines, layout := splitContent(content)
+		return lines, layout, nil
+	}
+
+	lines, layout := splitContent(text)
+	layout.encoding = enc
+	layout.bom = bom
+	return lines, layout, nil
+}
+
+/*
+encodeContent converts UTF-8 file content back to the layout's encoding. Text
+that Latin-1 cannot represent fails rather than being replaced, since that would
+lose it on disk.
+*/
+func encodeContent(text []byte, layout fileLayout) ([]byte, error) {
+	switch layout.encoding {
+	case EncodingLatin1:
+		out := make([]byte, 0, len(text))
+		line := 1
+		for _, r := range string(text) {
+			if r > 0xFF {
+				return nil, fmt.Errorf("E513: Write error, conversion failed in line %d (:set fenc=utf-8 to save as UTF-8)", line)
+			}
+			if r == '\n' {
+				line++
+			}
+			out = append(out, byte(r))
+		}
+		return out, nil
+	case EncodingUTF16LE, EncodingUTF16BE:
+		order, mark := utf16Order(layout.encoding)
+		if !utf8.Valid(text) {
+			return nil, errors.New("E513: Write error, conversion failed: text is not valid UTF-8")
+		}
+		units := utf16.Encode([]rune(string(text)))
+		out := make([]byte, 0, len(mark)+2*len(units))
+		if layout.bom {
+			out = append(out, mark...)
+		}
+		for _, u := range units {
+			out = order.AppendUint16(out, u)
+		}
+		return out, nil
+	}
+	return text, nil
+}
@@ -0,0 +1,149 @@
+package editor
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestEncodingRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		enc     Encoding // encoding opened with; UTF-8 sniffs it
+		want    Encoding
+		lines   []string
+		edited  string // file after ié<Esc> and :w
+	}{
+		{
+			name:    "UTF-8",
+			content: "café\n",
+			want:    EncodingUTF8,
+			lines:   []string{"café"},
+			edited:  "écafé\n",
+		},
+		{
+			name:    "UTF-16LE with byte order mark",
+			content: "\xff\xfec\x00a\x00\n\x00",
+			want:    EncodingUTF16LE,
+			lines:   []string{"ca"},
+			edited:  "\xff\xfe\xe9\x00c\x00a\x00\n\x00",
+		},
+		{
+			name:    "UTF-16BE with CRLF",
+			content: "\xfe\xff\x00c\x00a\x00\r\x00\n",
+			want:    EncodingUTF16BE,
+			lines:   []string{"ca"},
+			edited:  "\xfe\xff\x00\xe9\x00c\x00a\x00\r\x00\n",
+		},
+		{
+			name:    "UTF-16LE surrogate pair",
+			content: "\xff\xfe\x3d\xd8\x00\xde\n\x00",
+			want:    EncodingUTF16LE,
+			lines:   []string{"😀"},
+			edited:  "\xff\xfe\xe9\x00\x3d\xd8\x00\xde\n\x00",
+		},
+		{
+			name:    "Latin-1",
+			content: "caf\xe9\n",
+			enc:     EncodingLatin1,
+			want:    EncodingLatin1,
+			lines:   []string{"café"},
+			edited:  "\xe9caf\xe9\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, tt.content)
+			e := New()
+			load := e.LoadFile
+			if tt.enc != EncodingUTF8 {
+				load = func(filename string) error { return e.LoadFileEncoding(filename, tt.enc) }
+			}
+			if err := load(filename); err != nil {
+				t.Fatalf("loading: %v", err)
+			}
+			if got := e.buffer.Encoding(); got != tt.want {
+				t.Errorf("encoding %v, want %v", got, tt.want)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.lines) {
+				t.Errorf("lines %q, want %q", got, tt.lines)
+			}
+
+			if err := e.SaveFile(false); err != nil {
+				t.Fatalf("SaveFile: %v", err)
+			}
+			assertFile(t, filename, tt.content)
+
+			feed(t, e, "ié<Esc>:w<CR>")
+			assertFile(t, filename, tt.edited)
+		})
+	}
+}
+
+func TestEncodingUnrepresentable(t *testing.T) {
+	filename := tempFile(t, "caf\xe9\n")
+	e := New()
+	if err := e.LoadFileEncoding(filename, EncodingLatin1); err != nil {
+		t.Fatalf("LoadFileEncoding: %v", err)
+	}
+	feed(t, e, "i€<Esc>")
+	if err := e.SaveFile(false); err == nil || !strings.HasPrefix(err.Error(), "E513") {
+		t.Fatalf("SaveFile() = %v, want E513", err)
+	}
+	assertFile(t, filename, "caf\xe9\n")
+
+	feed(t, e, ":set fenc=utf-8<CR>:w<CR>")
+	assertFile(t, filename, "€café\n")
+}
+
+func TestUndecodable(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		reason  string
+	}{
+		{"text", "plain\n", ""},
+		{"binary", "ELF\x00\x01\x02", "binary"},
+		{"invalid UTF-8", "caf\xe9\n", "invalid UTF-8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, tt.content)
+			e := openTestFile(t, filename)
+			if got := e.buffer.Undecodable(); got != tt.reason {
+				t.Fatalf("Undecodable() = %q, want %q", got, tt.reason)
+			}
+			if tt.reason == "" {
+				return
+			}
+
+			if _, err := e.FeedKeys("ix<Esc>"); err == nil {
+				t.Error("typing into a file that is not text did not fail")
+			}
+			if e.buffer.IsDirty() {
+				t.Error("file that is not text was edited")
+			}
+			if err := e.SaveFile(false); err == nil {
+				t.Error("file that is not text saved without !")
+			}
+			if err := e.SaveFile(true); err != nil {
+				t.Fatalf("SaveFile(true): %v", err)
+			}
+			assertFile(t, filename, tt.content)
+		})
+	}
+}
+
+func assertFile(t *testing.T, filename, want string) {
+	t.Helper()
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, []byte(want)) {
+		t.Errorf("file holds %q, want %q", got, want)
+	}
+}
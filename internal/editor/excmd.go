@@ -0,0 +1,444 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+exCall is one parsed command line: the lines it applies to, 0-based and
+inclusive, whether a ! followed the name, and the rest of the line. Without a
+typed range both lines are the cursor's. Commands that end the editor set quit.
+*/
+type exCall struct {
+	first, last int
+	ranged      bool
+	bang        bool
+	arg         string
+	quit        bool
+}
+
+/*
+exCommand is an entry in the command registry. A typed name is recognized when
+it is at least the first abbrev characters of name, as vim's s[ubstitute] is.
+The flags say whether the command accepts a range and a !, and whether it is
+available in hex mode.
+*/
+type exCommand struct {
+	name   string
+	abbrev int
+	ranged bool
+	bang   bool
+	hex    bool
+	run    func(e *Editor, c *exCall) error
+}
+
+var exCommands []exCommand
+
+/*
+registerCommand adds a command to the registry. When an abbreviation fits
+several commands the one registered first wins.
+*/
+func registerCommand(c exCommand) {
+	exCommands = append(exCommands, c)
+}
+
+func lookupCommand(name string) (*exCommand, bool) {
+	for i := range exCommands {
+		c := &exCommands[i]
+		if len(name) >= c.abbrev && strings.HasPrefix(c.name, name) {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+func init() {
+	registerCommand(exCommand{name: "write", abbrev: 1, bang: true, hex: true, run: func(e *Editor, c *exCall) error {
+		e.WriteFile(c.arg, c.bang)
+		return nil
+	}})
+	registerCommand(exCommand{name: "quit", abbrev: 1, bang: true, hex: true, run: func(e *Editor, c *exCall) error {
+		if !c.bang && e.buffer.IsDirty() {
+			return ErrNoWrite
+		}
+		c.quit = true
+		return nil
+	}})
+	registerCommand(exCommand{name: "wq", abbrev: 2, bang: true, hex: true, run: func(e *Editor, c *exCall) error {
+		c.quit = e.WriteFile(c.arg, c.bang) == nil
+		return nil
+	}})
+	registerCommand(exCommand{name: "hex", abbrev: 3, hex: true, run: func(e *Editor, c *exCall) error {
+		if e.hex != nil {
+			return e.CloseHex()
+		}
+		return e.OpenHex()
+	}})
+	registerCommand(exCommand{name: "edit", abbrev: 1, bang: true, run: func(e *Editor, c *exCall) error {
+		return e.Edit(c.arg, c.bang)
+	}})
+	registerCommand(exCommand{name: "view", abbrev: 3, run: func(e *Editor, c *exCall) error {
+		return e.View(c.arg)
+	}})
+	registerCommand(exCommand{name: "earlier", abbrev: 2, run: func(e *Editor, c *exCall) error {
+		return e.Earlier(c.arg)
+	}})
+	registerCommand(exCommand{name: "later", abbrev: 3, run: func(e *Editor, c *exCall) error {
+		return e.Later(c.arg)
+	}})
+	registerCommand(exCommand{name: "undotree", abbrev: 8, run: func(e *Editor, c *exCall) error {
+		e.OpenUndoTree()
+		return nil
+	}})
+	registerCommand(exCommand{name: "nohlsearch", abbrev: 3, run: func(e *Editor, c *exCall) error {
+		e.NoHighlight()
+		return nil
+	}})
+	registerCommand(exCommand{name: "substitute", abbrev: 1, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.Substitute(c.first, c.last, c.arg)
+	}})
+	registerCommand(exCommand{name: "set", abbrev: 2, run: func(e *Editor, c *exCall) error {
+		return e.SetOption(c.arg)
+	}})
+	registerCommand(exCommand{name: "recover", abbrev: 3, run: func(e *Editor, c *exCall) error {
+		return e.Recover()
+	}})
+	registerCommand(exCommand{name: "swapdiff", abbrev: 8, run: func(e *Editor, c *exCall) error {
+		return e.SwapDiff()
+	}})
+	registerCommand(exCommand{name: "swapdelete", abbrev: 10, run: func(e *Editor, c *exCall) error {
+		return e.DeleteSwap()
+	}})
+	registerCommand(exCommand{name: "keep", abbrev: 4, run: func(e *Editor, c *exCall) error {
+		return e.KeepBuffer()
+	}})
+	registerCommand(exCommand{name: "merge", abbrev: 5, run: func(e *Editor, c *exCall) error {
+		return e.Merge()
+	}})
+	registerCommand(exCommand{name: "mark", abbrev: 2, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.SetMark(c.arg, c.last)
+	}})
+	registerCommand(exCommand{name: "k", abbrev: 1, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.SetMark(c.arg, c.last)
+	}})
+	registerCommand(exCommand{name: "delete", abbrev: 1, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.DeleteLines(c.first, c.last, c.arg)
+	}})
+}
+
+/*
+runEx parses and runs one command line, reporting whether it ends the editor.
+A range on its own moves to the last line it names, as :42 does.
+*/
+func (e *Editor) runEx(line string) (bool, error) {
+	c, cmd, err := e.parseEx(line)
+	if err != nil {
+		return false, err
+	}
+	if cmd == nil {
+		if c.ranged && e.hex == nil {
+			e.MoveToLine(c.last + 1)
+		}
+		return false, nil
+	}
+	if e.hex != nil && !cmd.hex {
+		return false, ErrHexCommand
+	}
+	if c.ranged && !cmd.ranged {
+		return false, errors.New("E481: No range allowed")
+	}
+	if c.bang && !cmd.bang {
+		return false, errors.New("E477: No ! allowed")
+	}
+	err = cmd.run(e, c)
+	return c.quit, err
+}
+
+/*
+parseEx splits a command line into its range, command name, ! and argument.
+The command is nil when the line holds no name.
+*/
+func (e *Editor) parseEx(line string) (*exCall, *exCommand, error) {
+	s := strings.TrimLeft(line, " :")
+	c := &exCall{first: e.cursor.Line, last: e.cursor.Line}
+	s, err := e.parseRange(s, c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s = strings.TrimLeft(s, " ")
+	name := s[:len(s)-len(strings.TrimLeftFunc(s, unicode.IsLetter))]
+	if name == "" {
+		if s != "" {
+			return nil, nil, errors.New("E492: Not an editor command: " + strings.TrimSpace(line))
+		}
+		return c, nil, nil
+	}
+	cmd, ok := lookupCommand(name)
+	if !ok {
+		return nil, nil, errors.New("E492: Not an editor command: " + strings.TrimSpace(line))
+	}
+	s = s[len(name):]
+	s, c.bang = strings.CutPrefix(s, "!")
+	c.arg = strings.TrimSpace(s)
+	return c, cmd, nil
+}
+
+/*
+parseRange reads the addresses before a command name into c. Addresses are
+separated by , or by ;, which makes the next address relative to the one before
+it; a missing address is the cursor line, so ",5" is ".,5". A backwards range is
+swapped and % is the whole buffer.
+*/
+func (e *Editor) parseRange(s string, c *exCall) (string, error) {
+	if rest, ok := strings.CutPrefix(s, "%"); ok {
+		c.first, c.last, c.ranged = 0, e.buffer.LineCount()-1, true
+		return rest, nil
+	}
+
+	cur := e.cursor.Line
+	var lines []int
+	for {
+		line, rest, ok, err := e.parseAddress(s, cur)
+		if err != nil {
+			return "", err
+		}
+		s = rest
+		sep := byte(0)
+		if s != "" && (s[0] == ',' || s[0] == ';') {
+			sep = s[0]
+			s = s[1:]
+		}
+		if !ok {
+			if sep == 0 && len(lines) == 0 {
+				return s, nil
+			}
+			line = cur
+		}
+		if line < 0 || line >= e.buffer.LineCount() {
+			return "", errors.New("E16: Invalid range")
+		}
+		lines = append(lines, line)
+		if sep == ';' {
+			cur = line
+		}
+		if sep == 0 {
+			break
+		}
+	}
+
+	c.first, c.last, c.ranged = lines[max(len(lines)-2, 0)], lines[len(lines)-1], true
+	if c.first > c.last {
+		c.first, c.last = c.last, c.first
+	}
+	return s, nil
+}
+
+/*
+parseAddress reads one line address: . for the cursor line, $ for the last, a
+line number, 'x for a mark, or /pattern/ and ?pattern? for the next line after
+or before the cursor line that matches, followed by any +N and -N offsets. An
+offset alone counts from the cursor line. Reports false when s starts with no
+address.
+*/
+func (e *Editor) parseAddress(s string, cur int) (line int, rest string, ok bool, err error) {
+	s = strings.TrimLeft(s, " ")
+	line = cur
+	switch {
+	case s == "":
+	case s[0] == '.':
+		s, ok = s[1:], true
+	case s[0] == '$':
+		line, s, ok = e.buffer.LineCount()-1, s[1:], true
+	case s[0] >= '0' && s[0] <= '9':
+		var n int
+		n, s = leadingNumber(s)
+		line, ok = max(n-1, 0), true
+	case s[0] == '\'':
+		r, size := utf8.DecodeRuneInString(s[1:])
+		pos, set := e.buffer.marks[r]
+		if size == 0 || !set {
+			return 0, "", false, errors.New("E20: Mark not set")
+		}
+		line, s, ok = pos.Line, s[1+size:], true
+	case s[0] == '/' || s[0] == '?':
+		forward := s[0] == '/'
+		var pattern string
+		pattern, s = cutDelimited(s[1:], rune(s[0]))
+		line, err = e.searchLine(pattern, cur, forward)
+		if err != nil {
+			return 0, "", false, err
+		}
+		ok = true
+	}
+
+	for s != "" && (s[0] == '+' || s[0] == '-') {
+		sign := 1
+		if s[0] == '-' {
+			sign = -1
+		}
+		n := 1
+		if len(s) > 1 && s[1] >= '0' && s[1] <= '9' {
+			n, s = leadingNumber(s[1:])
+		} else {
+			s = s[1:]
+		}
+		line, ok = line+sign*n, true
+	}
+	return line, s, ok, nil
+}
+
+func leadingNumber(s string) (int, string) {
+	digits := s[:len(s)-len(strings.TrimLeft(s, "0123456789"))]
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		n = maxCount
+	}
+	return n, s[len(digits):]
+}
+
+/*
+cutDelimited returns the text of s up to the first delimiter not escaped with a
+backslash, with escaped delimiters made literal, and what follows it.
+*/
+func cutDelimited(s string, delim rune) (string, string) {
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		switch {
+		case r == delim:
+			return b.String(), s[i+size:]
+		case r == '\\' && strings.HasPrefix(s[i+1:], string(delim)):
+			b.WriteRune(delim)
+			i += 1 + len(string(delim))
+			continue
+		}
+		b.WriteString(s[i : i+size])
+		i += size
+	}
+	return b.String(), ""
+}
+
+/*
+searchLine finds the next line after from, or before it searching backwards,
+that matches pattern, wrapping around the buffer. The pattern becomes the last
+search; an empty one reuses it.
+*/
+func (e *Editor) searchLine(pattern string, from int, forward bool) (int, error) {
+	if pattern == "" {
+		if e.lastSearch == nil {
+			return 0, errors.New("E35: No previous regular expression")
+		}
+		pattern = e.lastSearch.pattern
+	}
+	s, err := compileSearch(pattern, forward)
+	if err != nil {
+		return 0, errors.New("E383: Invalid search string: " + pattern)
+	}
+	e.remember(pattern)
+	e.lastSearch = s
+
+	n := e.buffer.LineCount()
+	step := 1
+	if !forward {
+		step = -1
+	}
+	for i := 1; i <= n; i++ {
+		line := ((from+i*step)%n + n) % n
+		if len(s.matches(e.buffer.GetLine(line))) > 0 {
+			return line, nil
+		}
+	}
+	return 0, errors.New("E486: Pattern not found: " + pattern)
+}
+
+/*
+SetMark sets mark name, a letter, at the start of a line, for use as an address
+like 'a.
+*/
+func (e *Editor) SetMark(name string, line int) error {
+	r, size := utf8.DecodeRuneInString(name)
+	if size == 0 {
+		return errors.New("E471: Argument required")
+	}
+	if len(name) > size || !unicode.IsLetter(r) || r > unicode.MaxASCII {
+		return errors.New("E191: Argument must be a letter or forward/backward quote")
+	}
+	e.buffer.setMark(r, Position{Line: line})
+	return nil
+}
+
+/*
+DeleteLines deletes lines first to last as one change, keeping them in the
+clipboard. A count argument deletes that many lines from last on instead, as
+:d 3 does.
+*/
+func (e *Editor) DeleteLines(first, last int, arg string) error {
+	if arg != "" {
+		n, rest := leadingNumber(arg)
+		if rest != "" || n == 0 {
+			return errors.New("E488: Trailing characters: " + arg)
+		}
+		first, last = last, min(last+n-1, e.buffer.LineCount()-1)
+	}
+	if !e.editable() {
+		return nil // Already reported
+	}
+
+	e.collapse()
+	e.beginChange()
+	text := e.buffer.deleteLines(first, last)
+	e.clipboard = []string{text}
+	line := min(first, e.buffer.LineCount()-1)
+	e.cursor = Position{Line: line, Col: firstNonBlank(e.buffer.GetLine(line))}
+	e.clampCursor()
+	e.selection = NewSelection(e.cursor)
+	e.endChange()
+
+	n := last - first + 1
+	if e.global != nil {
+		e.global.deleted += n
+		return nil
+	}
+	if n > 2 {
+		e.SetMessage(MessageInfo, fmt.Sprintf("%d fewer lines", n))
+	}
+	return nil
+}
+
+func (b *Buffer) setMark(name rune, pos Position) {
+	if b.marks == nil {
+		b.marks = make(map[rune]Position)
+	}
+	b.marks[name] = pos
+}
+
+/*
+shiftMarks keeps the marks on their text through an edit. A mark inside
+deleted text moves to where the text was.
+*/
+func (b *Buffer) shiftMarks(ed edit) {
+	for name, pos := range b.marks {
+		b.marks[name] = shiftPosition(pos, ed)
+	}
+	b.shiftGlobalMarks(ed)
+}
+
+/*
+markSelection sets '< and '> to the lines of a visual selection. A selection
+ending at the start of a line, as one made by moving down does, ends on the line
+before it.
+*/
+func (b *Buffer) markSelection(sel Selection) {
+	start, end := sel.Start(), sel.End()
+	if end.Col == 0 && end.Line > start.Line {
+		end = Position{Line: end.Line - 1}
+	}
+	b.setMark('<', Position{Line: start.Line})
+	b.setMark('>', Position{Line: end.Line})
+}
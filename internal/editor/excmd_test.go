@@ -0,0 +1,167 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseEx(t *testing.T) {
+	tests := []struct {
+		line        string
+		first, last int // 1-based, as typed
+		ranged      bool
+		name        string
+		bang        bool
+		arg         string
+		err         string
+	}{
+		{line: "w", first: 3, last: 3, name: "write"},
+		{line: ":w! out.txt", first: 3, last: 3, name: "write", bang: true, arg: "out.txt"},
+		{line: "5", first: 5, last: 5, ranged: true},
+		{line: "%d", first: 1, last: 10, ranged: true, name: "d"},
+		{line: ".,$d", first: 3, last: 10, ranged: true, name: "d"},
+		{line: "2,4d", first: 2, last: 4, ranged: true, name: "d"},
+		{line: "4,2d", first: 2, last: 4, ranged: true, name: "d"},
+		{line: ",5d", first: 3, last: 5, ranged: true, name: "d"},
+		{line: "5,d", first: 3, last: 5, ranged: true, name: "d"},
+		{line: "+d", first: 4, last: 4, ranged: true, name: "d"},
+		{line: "-2,+2d", first: 1, last: 5, ranged: true, name: "d"},
+		{line: ".+1,$-1d", first: 4, last: 9, ranged: true, name: "d"},
+		{line: "2;+2d", first: 2, last: 4, ranged: true, name: "d"},
+		{line: "2,+2d", first: 2, last: 5, ranged: true, name: "d"},
+		{line: "'a,'bd", first: 7, last: 9, ranged: true, name: "d"},
+		{line: "/gamma/d", first: 6, last: 6, ranged: true, name: "d"},
+		{line: "?alpha?d", first: 1, last: 1, ranged: true, name: "d"},
+		{line: `/beta/,/gam\/ma/d`, first: 4, last: 8, ranged: true, name: "d"},
+		{line: "/beta/;/gamma/d", first: 4, last: 6, ranged: true, name: "d"},
+		{line: "/delta/+1", first: 10, last: 10, ranged: true},
+		{line: "0", first: 1, last: 1, ranged: true},
+		{line: "11d", err: "E16"},
+		{line: "$+1", err: "E16"},
+		{line: "'zd", err: "E20"},
+		{line: "/nowhere/d", err: "E486"},
+		{line: "frobnicate", err: "E492"},
+		{line: "3!", err: "E492"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			e := newTestEditor("alpha", "beta", "gamma", "beta", "alpha", "gamma", "one", "gam/ma", "delta", "two")
+			e.cursor.Line = 2
+			e.buffer.marks = map[rune]Position{'a': {Line: 6}, 'b': {Line: 8}}
+
+			c, cmd, err := e.parseEx(tt.line)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("parseEx(%q) error = %v, want %s", tt.line, err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseEx(%q): %v", tt.line, err)
+			}
+			if c.first != tt.first-1 || c.last != tt.last-1 || c.ranged != tt.ranged {
+				t.Errorf("range %d,%d ranged=%v, want %d,%d ranged=%v", c.first+1, c.last+1, c.ranged, tt.first, tt.last, tt.ranged)
+			}
+			switch {
+			case cmd == nil && tt.name != "":
+				t.Errorf("no command, want %q", tt.name)
+			case cmd != nil && (tt.name == "" || !strings.HasPrefix(cmd.name, tt.name)):
+				t.Errorf("command %q, want %q", cmd.name, tt.name)
+			}
+			if c.bang != tt.bang || c.arg != tt.arg {
+				t.Errorf("bang=%v arg=%q, want bang=%v arg=%q", c.bang, c.arg, tt.bang, tt.arg)
+			}
+		})
+	}
+}
+
+func TestWriteArgument(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      string
+		existing bool
+		quit     bool
+		copied   bool
+		err      string
+	}{
+		{name: "copy to a new file", cmd: "w %s", copied: true},
+		{name: "existing file needs !", cmd: "w %s", existing: true, err: "E13"},
+		{name: "forced over an existing file", cmd: "w! %s", existing: true, copied: true},
+		{name: "copy and quit", cmd: "wq %s", copied: true, quit: true},
+		{name: "failed copy does not quit", cmd: "wq %s", existing: true, err: "E13"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "abc\n")
+			other := filepath.Join(filepath.Dir(filename), "other.txt")
+			if tt.existing {
+				mustWrite(t, other, "old\n", 0644)
+			}
+			e := openTestFile(t, filename)
+			feed(t, e, "ix<Esc>")
+
+			quit, _ := e.runEx(strings.Replace(tt.cmd, "%s", other, 1))
+			if quit != tt.quit {
+				t.Errorf("quit = %v, want %v", quit, tt.quit)
+			}
+			if msg := e.GetMessage().Text; tt.err != "" && !strings.HasPrefix(msg, tt.err) {
+				t.Errorf("message %q, want %s", msg, tt.err)
+			}
+			want := "old\n"
+			if tt.copied {
+				want = "xabc\n"
+			}
+			assertFile(t, other, want)
+
+			// The buffer's own file and state are untouched
+			assertFile(t, filename, "abc\n")
+			if !e.buffer.IsDirty() || e.buffer.GetFilename() != filename {
+				t.Errorf("buffer dirty=%v file=%q after writing a copy", e.buffer.IsDirty(), e.buffer.GetFilename())
+			}
+		})
+	}
+}
+
+func TestWriteArgumentNames(t *testing.T) {
+	t.Run("own file saves", func(t *testing.T) {
+		filename := tempFile(t, "abc\n")
+		e := openTestFile(t, filename)
+		feed(t, e, "ix<Esc>")
+		rel, err := filepath.Rel(mustGetwd(t), filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := e.runEx("w " + rel); err != nil {
+			t.Fatal(err)
+		}
+		assertFile(t, filename, "xabc\n")
+		if e.buffer.IsDirty() {
+			t.Error("buffer dirty after saving under its own name")
+		}
+	})
+
+	t.Run("unnamed buffer takes the name", func(t *testing.T) {
+		filename := filepath.Join(filepath.Dir(tempFile(t, "")), "new.txt")
+		e := newTestEditor("")
+		feed(t, e, "ihello<Esc>")
+		if _, err := e.runEx("w " + filename); err != nil {
+			t.Fatal(err)
+		}
+		assertFile(t, filename, "hello\n")
+		if e.buffer.GetFilename() != filename || e.buffer.IsDirty() {
+			t.Errorf("buffer dirty=%v file=%q, want it saved as %q", e.buffer.IsDirty(), e.buffer.GetFilename(), filename)
+		}
+		e.Close()
+	})
+}
+
+func mustGetwd(t *testing.T) string {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wd
+}
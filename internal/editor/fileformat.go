@@ -0,0 +1,84 @@
+package editor
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+/*
+FileFormat is the line ending a file is written with. Lines are always held
+without their terminators; the format decides what joins them on save.
+*/
+type FileFormat int
+
+const (
+	FormatUnix FileFormat = iota
+	FormatDOS
+)
+
+func (f FileFormat) String() string {
+	switch f {
+	case FormatDOS:
+		return "dos"
+	default:
+		return "unix"
+	}
+}
+
+func (f FileFormat) lineEnding() string {
+	if f == FormatDOS {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func ParseFileFormat(name string) (FileFormat, error) {
+	switch name {
+	case "unix":
+		return FormatUnix, nil
+	case "dos":
+		return FormatDOS, nil
+	}
+	return FormatUnix, fmt.Errorf("E474: Invalid argument: fileformat=%s", name)
+}
+
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
+
+/*
+fileLayout records how a file's bytes were framed so saving reproduces them
+exactly: the encoding, the line ending, whether a byte order mark led the file,
+and whether the last line was terminated.
+*/
+type fileLayout struct {
+	encoding     Encoding
+	format       FileFormat
+	bom          bool
+	finalNewline bool
+}
+
+/*
+splitContent detects the file's layout and splits it into lines. A file is only
+treated as DOS when every line break is CRLF; with mixed endings the stray
+carriage returns stay in the text so they survive a save untouched.
+*/
+func splitContent(content []byte) ([]string, fileLayout) {
+	var layout fileLayout
+	if bytes.HasPrefix(content, utf8BOM) {
+		layout.bom = true
+		content = content[len(utf8BOM):]
+	}
+
+	text := string(content)
+	breaks := strings.Count(text, "\n")
+	if breaks > 0 && strings.Count(text, "\r\n") == breaks {
+		layout.format = FormatDOS
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+	}
+
+	if strings.HasSuffix(text, "\n") {
+		layout.finalNewline = true
+		text = text[:len(text)-1]
+	}
+	return strings.Split(text, "\n"), layout
+}
@@ -0,0 +1,139 @@
+package editor
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/*
+writeFileAtomic replaces a file's content so that a crash leaves either the old
+or the new version on disk, never a truncated mix. Data goes to a temporary file
+in the same directory (so the final rename stays on one filesystem), is synced,
+takes on the original's permission bits and, where the platform allows, its
+owner, and is then renamed over the original. Symlinks are followed so the link
+keeps pointing at the updated target instead of being replaced by a plain file.
+New files are created with perm.
+*/
+func writeFileAtomic(filename string, data []byte, perm fs.FileMode) error {
+	return writeFileFunc(filename, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+/*
+writeFileFunc is writeFileAtomic for content produced by write, so large buffers
+can be streamed to disk instead of assembled in memory first.
+*/
+func writeFileFunc(filename string, perm fs.FileMode, write func(w io.Writer) error) error {
+	target, err := resolveSymlinks(filename)
+	if err != nil {
+		return err
+	}
+
+	mode := perm
+	info, err := os.Stat(target)
+	if err == nil {
+		mode = info.Mode() & (fs.ModePerm | fs.ModeSetuid | fs.ModeSetgid | fs.ModeSticky)
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	dir := filepath.Dir(target)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(target)+".tw-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	committed := false
+	defer func() {
+		if !committed {
+			os.Remove(tmpName)
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, mode); err != nil {
+		return err
+	}
+	if info != nil {
+		preserveOwner(tmpName, info)
+	}
+
+	if err := os.Rename(tmpName, target); err != nil {
+		return err
+	}
+	committed = true
+
+	syncDir(dir)
+	return nil
+}
+
+/*
+writable reports whether the file can be opened for writing. A file that does
+not exist yet counts as writable; whether it can be created shows on saving.
+*/
+func writable(filename string) bool {
+	f, err := os.OpenFile(filename, os.O_WRONLY, 0)
+	if err != nil {
+		return errors.Is(err, fs.ErrNotExist)
+	}
+	f.Close()
+	return true
+}
+
+/*
+resolveSymlinks returns the real file a path refers to. A dangling link resolves
+to the path it points at, so saving creates the missing target.
+*/
+func resolveSymlinks(filename string) (string, error) {
+	for range 255 {
+		info, err := os.Lstat(filename)
+		if errors.Is(err, fs.ErrNotExist) {
+			return filename, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return filename, nil
+		}
+
+		link, err := os.Readlink(filename)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(link) {
+			link = filepath.Join(filepath.Dir(filename), link)
+		}
+		filename = link
+	}
+	return "", &fs.PathError{Op: "resolve", Path: filename, Err: errors.New("too many levels of symbolic links")}
+}
+
+/*
+syncDir flushes the directory entry created by the rename. Not every platform
+can open directories for syncing, so failures are ignored; the data itself was
+already synced.
+*/
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
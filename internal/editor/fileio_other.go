@@ -0,0 +1,9 @@
+//go:build !unix
+
+package editor
+
+import (
+	"os"
+)
+
+func preserveOwner(path string, info os.FileInfo) {}
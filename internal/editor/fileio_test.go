@@ -0,0 +1,159 @@
+package editor
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup prepares dir and returns the name to write and the file that
+		// should end up holding the data
+		setup func(t *testing.T, dir string) (write, target string)
+		perm  fs.FileMode
+		mode  fs.FileMode
+	}{
+		{
+			name: "new file takes perm",
+			setup: func(t *testing.T, dir string) (string, string) {
+				path := filepath.Join(dir, "new.txt")
+				return path, path
+			},
+			perm: 0640,
+			mode: 0640,
+		},
+		{
+			name: "existing file keeps its mode",
+			setup: func(t *testing.T, dir string) (string, string) {
+				path := filepath.Join(dir, "script.sh")
+				mustWrite(t, path, "old", 0755)
+				return path, path
+			},
+			perm: 0644,
+			mode: 0755,
+		},
+		{
+			name: "private file stays private",
+			setup: func(t *testing.T, dir string) (string, string) {
+				path := filepath.Join(dir, "secret.txt")
+				mustWrite(t, path, "old", 0600)
+				return path, path
+			},
+			perm: 0644,
+			mode: 0600,
+		},
+		{
+			name: "symlink is followed to its target",
+			setup: func(t *testing.T, dir string) (string, string) {
+				target := filepath.Join(dir, "target.txt")
+				mustWrite(t, target, "old", 0600)
+				link := filepath.Join(dir, "link.txt")
+				mustSymlink(t, "target.txt", link)
+				return link, target
+			},
+			perm: 0644,
+			mode: 0600,
+		},
+		{
+			name: "chain of symlinks is followed",
+			setup: func(t *testing.T, dir string) (string, string) {
+				target := filepath.Join(dir, "target.txt")
+				mustWrite(t, target, "old", 0644)
+				mustSymlink(t, target, filepath.Join(dir, "first"))
+				mustSymlink(t, "first", filepath.Join(dir, "second"))
+				return filepath.Join(dir, "second"), target
+			},
+			perm: 0600,
+			mode: 0644,
+		},
+		{
+			name: "dangling symlink creates its target",
+			setup: func(t *testing.T, dir string) (string, string) {
+				link := filepath.Join(dir, "link.txt")
+				mustSymlink(t, "missing.txt", link)
+				return link, filepath.Join(dir, "missing.txt")
+			},
+			perm: 0644,
+			mode: 0644,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			write, target := tt.setup(t, dir)
+			if err := writeFileAtomic(write, []byte("new"), tt.perm); err != nil {
+				t.Fatalf("writeFileAtomic: %v", err)
+			}
+
+			data, err := os.ReadFile(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != "new" {
+				t.Errorf("target holds %q, want %q", data, "new")
+			}
+			info, err := os.Stat(target)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.mode {
+				t.Errorf("mode %v, want %v", info.Mode().Perm(), tt.mode)
+			}
+			if write != target {
+				if info, err := os.Lstat(write); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+					t.Errorf("%s is no longer a symlink", filepath.Base(write))
+				}
+			}
+			assertNoTempFiles(t, dir)
+		})
+	}
+}
+
+func TestWriteFileFuncFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.txt")
+	mustWrite(t, path, "original", 0644)
+
+	failure := errors.New("disk full")
+	err := writeFileFunc(path, 0644, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return failure
+	})
+	if !errors.Is(err, failure) {
+		t.Fatalf("error = %v, want %v", err, failure)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "original" {
+		t.Errorf("file holds %q after a failed write", data)
+	}
+	assertNoTempFiles(t, dir)
+}
+
+func mustWrite(t *testing.T, path, content string, perm fs.FileMode) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustSymlink(t *testing.T, oldname, newname string) {
+	t.Helper()
+	if err := os.Symlink(oldname, newname); err != nil {
+		t.Skipf("symlinks unavailable: %v", err)
+	}
+}
+
+func assertNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	temps, _ := filepath.Glob(filepath.Join(dir, ".*.tw-*"))
+	if len(temps) > 0 {
+		t.Errorf("temporary files left behind: %v", temps)
+	}
+}
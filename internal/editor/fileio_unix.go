@@ -0,0 +1,23 @@
+//go:build unix
+
+package editor
+
+import (
+	"os"
+	"syscall"
+)
+
+/*
+preserveOwner gives the replacement file the original's owner and group. Only
+privileged users can change the owner, so a failure is expected and ignored;
+the group alone is retried since members of a group may keep it.
+*/
+func preserveOwner(path string, info os.FileInfo) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	if err := os.Chown(path, int(stat.Uid), int(stat.Gid)); err != nil {
+		os.Chown(path, -1, int(stat.Gid))
+	}
+}
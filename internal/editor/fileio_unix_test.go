@@ -0,0 +1,44 @@
+//go:build unix
+
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestWriteFileAtomicOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing a file's owner needs root")
+	}
+	tests := []struct {
+		name     string
+		uid, gid int
+	}{
+		{"other user", 1234, 1234},
+		{"other group only", 0, 4321},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "file.txt")
+			mustWrite(t, path, "old", 0644)
+			if err := os.Chown(path, tt.uid, tt.gid); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := writeFileAtomic(path, []byte("new"), 0644); err != nil {
+				t.Fatalf("writeFileAtomic: %v", err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stat := info.Sys().(*syscall.Stat_t)
+			if int(stat.Uid) != tt.uid || int(stat.Gid) != tt.gid {
+				t.Errorf("owner %d:%d, want %d:%d", stat.Uid, stat.Gid, tt.uid, tt.gid)
+			}
+		})
+	}
+}
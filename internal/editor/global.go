@@ -0,0 +1,226 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+globalRun is a :g in progress. Commands run for each line add up what they did
+here, so :g reports once for all lines instead of each command for its own.
+*/
+type globalRun struct {
+	replaced int
+	lines    int
+	deleted  int
+}
+
+func init() {
+	registerCommand(exCommand{name: "global", abbrev: 1, ranged: true, bang: true, run: func(e *Editor, c *exCall) error {
+		return e.Global(c, !c.bang)
+	}})
+	registerCommand(exCommand{name: "vglobal", abbrev: 1, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.Global(c, false)
+	}})
+	registerCommand(exCommand{name: "move", abbrev: 1, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.MoveLines(c.first, c.last, c.arg)
+	}})
+	registerCommand(exCommand{name: "copy", abbrev: 2, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.CopyLines(c.first, c.last, c.arg)
+	}})
+	registerCommand(exCommand{name: "t", abbrev: 1, ranged: true, run: func(e *Editor, c *exCall) error {
+		return e.CopyLines(c.first, c.last, c.arg)
+	}})
+}
+
+/*
+Global runs :g/pattern/command, or :v and :g! with match false, which run the
+command on the lines that do not match. Lines in the range, the whole buffer by
+default, are marked first and the command then runs with the cursor on each
+marked line still present, so commands that delete or move lines do not upset
+the rest. The pattern follows search rules and all changes undo as one step.
+*/
+func (e *Editor) Global(c *exCall, match bool) error {
+	if e.global != nil {
+		return errors.New("E147: Cannot do :global recursive")
+	}
+	delim, size := utf8.DecodeRuneInString(c.arg)
+	if size == 0 {
+		return errors.New("E471: Argument required")
+	}
+	pattern, command := cutDelimited(c.arg[size:], delim)
+	if strings.TrimSpace(command) == "" {
+		return errors.New("E471: Argument required")
+	}
+	if pattern == "" {
+		if e.lastSearch == nil {
+			return errors.New("E35: No previous regular expression")
+		}
+		pattern = e.lastSearch.pattern
+	}
+	s, err := compileSearch(pattern, true)
+	if err != nil {
+		return errors.New("E383: Invalid search string: " + pattern)
+	}
+	e.remember(pattern)
+	e.lastSearch = s
+
+	first, last := c.first, c.last
+	if !c.ranged {
+		first, last = 0, e.buffer.LineCount()-1
+	}
+	var marked []Position
+	for line := first; line <= last; line++ {
+		if (len(s.matches(e.buffer.GetLine(line))) > 0) == match {
+			marked = append(marked, Position{Line: line})
+		}
+	}
+	if len(marked) == 0 {
+		if match {
+			return errors.New("E486: Pattern not found: " + pattern)
+		}
+		e.SetMessage(MessageInfo, "Pattern found in every line: "+pattern)
+		return nil
+	}
+
+	e.global = &globalRun{}
+	e.buffer.globalMarks = marked
+	e.collapse()
+	e.beginChange()
+	for len(e.buffer.globalMarks) > 0 {
+		line := e.buffer.globalMarks[0].Line
+		e.buffer.globalMarks = e.buffer.globalMarks[1:]
+		e.cursor = Position{Line: line}
+		e.selection = NewSelection(e.cursor)
+		if _, err = e.runEx(command); err != nil {
+			break
+		}
+	}
+	run := e.global
+	e.global = nil
+	e.buffer.globalMarks = nil
+	e.endChange()
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case run.replaced > 0:
+		e.SetMessage(MessageInfo, fmt.Sprintf("%s on %s", plural(run.replaced, "substitution"), plural(run.lines, "line")))
+	case run.deleted > 2:
+		e.SetMessage(MessageInfo, fmt.Sprintf("%d fewer lines", run.deleted))
+	}
+	return nil
+}
+
+/*
+shiftGlobalMarks keeps the lines :g has yet to visit on their text through an
+edit, and forgets those that were deleted or joined to the line above. The marks
+stay at the start of their lines.
+*/
+func (b *Buffer) shiftGlobalMarks(ed edit) {
+	if len(b.globalMarks) == 0 {
+		return
+	}
+	end := textEnd(ed.Pos, ed.Deleted)
+	marks := b.globalMarks[:0]
+	for _, pos := range b.globalMarks {
+		if ed.Deleted != "" && !after(ed.Pos, pos) && !after(pos, end) {
+			kept := (pos == ed.Pos && end.Line == pos.Line) || (pos == end && ed.Pos.Col == 0)
+			if !kept {
+				continue
+			}
+		}
+		marks = append(marks, Position{Line: shiftPosition(pos, ed).Line})
+	}
+	b.globalMarks = marks
+}
+
+/*
+lineDestination reads the address that :m and :t put lines below. Address 0
+puts them above the first line.
+*/
+func (e *Editor) lineDestination(arg string) (int, error) {
+	if strings.TrimSpace(arg) == "0" {
+		return -1, nil
+	}
+	dest, rest, ok, err := e.parseAddress(arg, e.cursor.Line)
+	switch {
+	case err != nil:
+		return 0, err
+	case !ok:
+		return 0, errors.New("E14: Invalid address")
+	case strings.TrimSpace(rest) != "":
+		return 0, errors.New("E488: Trailing characters: " + rest)
+	case dest < 0 || dest >= e.buffer.LineCount():
+		return 0, errors.New("E16: Invalid range")
+	}
+	return dest, nil
+}
+
+/*
+MoveLines moves lines first to last below the line addressed by arg, like :m.
+*/
+func (e *Editor) MoveLines(first, last int, arg string) error {
+	dest, err := e.lineDestination(arg)
+	if err != nil {
+		return err
+	}
+	if dest >= first && dest < last {
+		return errors.New("E134: Cannot move a range of lines into itself")
+	}
+	if !e.editable() {
+		return nil // Already reported
+	}
+
+	if dest == last {
+		// Below themselves is where the lines already are
+		dest = first - 1
+	}
+
+	e.collapse()
+	e.beginChange()
+	if dest != first-1 {
+		text := e.buffer.deleteLines(first, last)
+		if dest > last {
+			dest -= last - first + 1
+		}
+		e.buffer.insertLines(dest, text)
+	}
+	e.cursor = Position{Line: dest + last - first + 1}
+	e.cursor.Col = firstNonBlank(e.buffer.GetLine(e.cursor.Line))
+	e.clampCursor()
+	e.selection = NewSelection(e.cursor)
+	e.endChange()
+	return nil
+}
+
+/*
+CopyLines copies lines first to last below the line addressed by arg, like :t
+and :copy.
+*/
+func (e *Editor) CopyLines(first, last int, arg string) error {
+	dest, err := e.lineDestination(arg)
+	if err != nil {
+		return err
+	}
+	if !e.editable() {
+		return nil // Already reported
+	}
+
+	var text strings.Builder
+	for line := first; line <= last; line++ {
+		text.WriteString(e.buffer.GetLine(line) + "\n")
+	}
+	e.collapse()
+	e.beginChange()
+	e.buffer.insertLines(dest, text.String())
+	e.cursor = Position{Line: dest + last - first + 1}
+	e.cursor.Col = firstNonBlank(e.buffer.GetLine(e.cursor.Line))
+	e.clampCursor()
+	e.selection = NewSelection(e.cursor)
+	e.endChange()
+	return nil
+}
@@ -0,0 +1,202 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGlobal(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		cmd   string
+		want  []string
+		err   string
+	}{
+		{
+			name:  "delete matching lines",
+			lines: []string{"a1", "b1", "a2", "b2"},
+			cmd:   "g/a/d",
+			want:  []string{"b1", "b2"},
+		},
+		{
+			name:  "delete other lines with v",
+			lines: []string{"a1", "b1", "a2", "b2"},
+			cmd:   "v/a/d",
+			want:  []string{"a1", "a2"},
+		},
+		{
+			name:  "g! is v",
+			lines: []string{"a1", "b1", "a2"},
+			cmd:   "g!/a/d",
+			want:  []string{"a1", "a2"},
+		},
+		{
+			name:  "adjacent matches all deleted",
+			lines: []string{"a", "a", "a", "b"},
+			cmd:   "g/a/d",
+			want:  []string{"b"},
+		},
+		{
+			name:  "reverse the buffer",
+			lines: []string{"1", "2", "3", "4"},
+			cmd:   "g/^/m0",
+			want:  []string{"4", "3", "2", "1"},
+		},
+		{
+			name:  "move matches to the end",
+			lines: []string{"a1", "b1", "a2", "b2"},
+			cmd:   "g/a/m$",
+			want:  []string{"b1", "b2", "a1", "a2"},
+		},
+		{
+			name:  "copy matches to the end",
+			lines: []string{"a1", "b1", "a2"},
+			cmd:   "g/a/t$",
+			want:  []string{"a1", "b1", "a2", "a1", "a2"},
+		},
+		{
+			name:  "copied lines are not visited again",
+			lines: []string{"a", "b"},
+			cmd:   "g/a/t.",
+			want:  []string{"a", "a", "b"},
+		},
+		{
+			name:  "duplicate every line",
+			lines: []string{"1", "2"},
+			cmd:   "g/^/copy .",
+			want:  []string{"1", "1", "2", "2"},
+		},
+		{
+			name:  "move below a relative line",
+			lines: []string{"head", "x1", "body", "x2"},
+			cmd:   "v/x/m+1",
+			want:  []string{"x1", "head", "x2", "body"},
+		},
+		{
+			name:  "substitute on matching lines",
+			lines: []string{"a b", "c b", "a b"},
+			cmd:   "g/a/s/b/B/",
+			want:  []string{"a B", "c b", "a B"},
+		},
+		{
+			name:  "only within the range",
+			lines: []string{"a", "a", "a", "a"},
+			cmd:   "2,3g/a/s/a/b/",
+			want:  []string{"a", "b", "b", "a"},
+		},
+		{
+			name:  "other delimiter",
+			lines: []string{"a/b", "c"},
+			cmd:   "g#/#d",
+			want:  []string{"c"},
+		},
+		{
+			name:  "no match",
+			lines: []string{"a"},
+			cmd:   "g/z/d",
+			want:  []string{"a"},
+			err:   "E486",
+		},
+		{
+			name:  "no command",
+			lines: []string{"a"},
+			cmd:   "g/a/",
+			want:  []string{"a"},
+			err:   "E471",
+		},
+		{
+			name:  "recursive",
+			lines: []string{"a"},
+			cmd:   "g/a/g/a/d",
+			want:  []string{"a"},
+			err:   "E147",
+		},
+		{
+			name:  "moving past the last line",
+			lines: []string{"x", "a"},
+			cmd:   "v/x/m+1",
+			want:  []string{"x", "a"},
+			err:   "E16",
+		},
+		{
+			name:  "moving a range into itself stops at the first failure",
+			lines: []string{"a", "b"},
+			cmd:   "g/a/1,2m1",
+			want:  []string{"a", "b"},
+			err:   "E134",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			_, err := e.runEx(tt.cmd)
+			if tt.err == "" && err != nil {
+				t.Fatalf("runEx(%q): %v", tt.cmd, err)
+			}
+			if tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+				t.Fatalf("runEx(%q) = %v, want %s", tt.cmd, err, tt.err)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+
+			// Everything :g did undoes as one step
+			feed(t, e, "u")
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.lines) {
+				t.Errorf("after undo lines %q, want %q", got, tt.lines)
+			}
+		})
+	}
+}
+
+func TestMoveCopyLines(t *testing.T) {
+	tests := []struct {
+		cmd    string
+		want   []string
+		cursor int
+		err    string
+	}{
+		{cmd: "m0", want: []string{"3", "1", "2", "4", "5"}, cursor: 0},
+		{cmd: "m$", want: []string{"1", "2", "4", "5", "3"}, cursor: 4},
+		{cmd: "m3", want: []string{"1", "2", "3", "4", "5"}, cursor: 2},
+		{cmd: "m2", want: []string{"1", "2", "3", "4", "5"}, cursor: 2},
+		{cmd: "m-2", want: []string{"1", "3", "2", "4", "5"}, cursor: 1},
+		{cmd: "2,3m4", want: []string{"1", "4", "2", "3", "5"}, cursor: 3},
+		{cmd: "2,4m3", err: "E134"},
+		{cmd: "m", err: "E14"},
+		{cmd: "m9", err: "E16"},
+		{cmd: "m1 x", err: "E488"},
+		{cmd: "t0", want: []string{"3", "1", "2", "3", "4", "5"}, cursor: 0},
+		{cmd: "t.", want: []string{"1", "2", "3", "3", "4", "5"}, cursor: 3},
+		{cmd: "1,2t$", want: []string{"1", "2", "3", "4", "5", "1", "2"}, cursor: 6},
+		{cmd: "2,3co3", want: []string{"1", "2", "3", "2", "3", "4", "5"}, cursor: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cmd, func(t *testing.T) {
+			lines := []string{"1", "2", "3", "4", "5"}
+			e := newTestEditor(lines...)
+			e.cursor.Line = 2
+			_, err := e.runEx(tt.cmd)
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("runEx(%q) = %v, want %s", tt.cmd, err, tt.err)
+				}
+				if got := e.buffer.allLines(); !slices.Equal(got, lines) {
+					t.Errorf("failed command changed lines to %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("runEx(%q): %v", tt.cmd, err)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+			if e.cursor.Line != tt.cursor {
+				t.Errorf("cursor on line %d, want %d", e.cursor.Line+1, tt.cursor+1)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package editor
+
+import (
+	"github.com/rivo/uniseg"
+)
+
+/*
+nextGrapheme returns the boundary following the cluster that starts at col.
+Columns are byte offsets but only ever rest on grapheme cluster boundaries, so
+stepping between boundaries treats an accented letter, a CJK character or a
+multi-rune emoji as the single unit the user sees.
+*/
+func nextGrapheme(line string, col int) int {
+	if col >= len(line) {
+		return len(line)
+	}
+	cluster, _, _, _ := uniseg.FirstGraphemeClusterInString(line[col:], -1)
+	return col + len(cluster)
+}
+
+/*
+prevGrapheme returns the start of the cluster that ends at or contains col.
+Clusters must be segmented from the start of the line, since the rules depend on
+what precedes a rune.
+*/
+func prevGrapheme(line string, col int) int {
+	if col > len(line) {
+		col = len(line)
+	}
+	prev, pos, state := 0, 0, -1
+	for pos < col {
+		var cluster string
+		cluster, _, _, state = uniseg.FirstGraphemeClusterInString(line[pos:], state)
+		prev = pos
+		pos += len(cluster)
+	}
+	return prev
+}
+
+/*
+graphemeStart snaps col back to the boundary at or before it, repairing columns
+that landed inside a cluster after byte-oriented scanning.
+*/
+func graphemeStart(line string, col int) int {
+	if col >= len(line) {
+		return len(line)
+	}
+	pos, state := 0, -1
+	for pos < len(line) {
+		var cluster string
+		cluster, _, _, state = uniseg.FirstGraphemeClusterInString(line[pos:], state)
+		if pos+len(cluster) > col {
+			return pos
+		}
+		pos += len(cluster)
+	}
+	return pos
+}
+
+/*
+DisplayColumn converts a byte column into the terminal cell it is drawn at,
+counting double-width characters as two cells and combining marks as none.
+*/
+func DisplayColumn(line string, col int) int {
+	if col > len(line) {
+		return uniseg.StringWidth(line) + col - len(line)
+	}
+	return uniseg.StringWidth(line[:col])
+}
+
+/*
+GraphemeColumn counts the user-perceived characters before a byte column.
+*/
+func GraphemeColumn(line string, col int) int {
+	if col > len(line) {
+		col = len(line)
+	}
+	return uniseg.GraphemeClusterCount(line[:col])
+}
+
+/*
+columnForDisplay finds the boundary drawn at or just before the given cell, used
+to keep the cursor visually aligned when moving between lines whose characters
+differ in width or encoding length.
+*/
+func columnForDisplay(line string, cell int) int {
+	pos, width, state := 0, 0, -1
+	for pos < len(line) {
+		cluster, _, w, newState := uniseg.FirstGraphemeClusterInString(line[pos:], state)
+		if width+w > cell {
+			return pos
+		}
+		state = newState
+		width += w
+		pos += len(cluster)
+	}
+	return pos
+}
@@ -0,0 +1,338 @@
+package editor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+/*
+HexRowBytes is how many bytes hex mode shows per row, which is also how far
+vertical movement steps.
+*/
+const HexRowBytes = 16
+
+var ErrHexCommand = errors.New("E21: Only :w and :q are available in hex mode; press Esc to return to text")
+
+/*
+hexState is a hex editing session. It works on the file's bytes exactly as they
+are saved, independent of lines, encoding and line endings, and only turns them
+back into text when the session ends. low selects the nibble under the cursor in
+the hex column; ascii moves editing to the character column instead.
+*/
+type hexState struct {
+	data     []byte
+	cursor   int
+	low      bool
+	ascii    bool
+	insert   bool
+	modified bool
+}
+
+/*
+HexView is a snapshot of the hex session for rendering.
+*/
+type HexView struct {
+	Data   []byte
+	Cursor int
+	Low    bool
+	ASCII  bool
+	Insert bool
+}
+
+/*
+OpenHex starts a hex session over the file's bytes, with the cursor on the byte
+under the text cursor when the text is UTF-8 and so maps onto bytes directly.
+*/
+func (e *Editor) OpenHex() error {
+	b := e.buffer
+	if b.IsLarge() {
+		return errors.New("E21: Hex mode is not available for large files")
+	}
+	data, err := b.fileBytes()
+	if err != nil {
+		return err
+	}
+
+	e.SetMode(ModeNormal)
+	e.collapse()
+	e.hex = &hexState{data: data}
+	if b.layout.encoding == EncodingUTF8 {
+		e.hex.cursor = min(b.byteOffset(e.cursor), max(len(data)-1, 0))
+	}
+	e.mode = ModeHex
+	return nil
+}
+
+/*
+CloseHex ends the hex session. Changed bytes replace the text as one undoable
+step, decoded the way the file would be on load. Bytes the buffer's encoding
+cannot decode keep the session open, so they can be fixed or written as they are.
+*/
+func (e *Editor) CloseHex() error {
+	if e.hex == nil {
+		return nil
+	}
+	if err := e.syncHex(e.buffer.layout.encoding); err != nil {
+		return fmt.Errorf("%w; fix the bytes, or :w to write them and edit the file as UTF-8", err)
+	}
+	if e.buffer.layout.encoding == EncodingUTF8 {
+		e.cursor = e.buffer.positionAt(e.hex.cursor)
+	}
+	e.hex = nil
+	e.mode = ModeNormal
+	e.clampCursor()
+	e.collapse()
+	return nil
+}
+
+/*
+syncHex replaces the text with the session's changed bytes decoded in enc.
+*/
+func (e *Editor) syncHex(enc Encoding) error {
+	if !e.hex.modified {
+		return nil
+	}
+	e.beginChange()
+	err := e.buffer.setBytes(e.hex.data, enc)
+	e.endChange()
+	if err != nil {
+		return err
+	}
+	e.hex.modified = false
+	return nil
+}
+
+func (e *Editor) HexActive() bool {
+	return e.hex != nil
+}
+
+func (e *Editor) HexView() HexView {
+	if e.hex == nil {
+		return HexView{}
+	}
+	return HexView{
+		Data:   e.hex.data,
+		Cursor: e.hex.cursor,
+		Low:    e.hex.low,
+		ASCII:  e.hex.ascii,
+		Insert: e.hex.insert,
+	}
+}
+
+/*
+limit is the last offset the cursor may take. In insert mode that is one
+past the end, so bytes can be appended.
+*/
+func (h *hexState) limit() int {
+	if h.insert {
+		return len(h.data)
+	}
+	return max(len(h.data)-1, 0)
+}
+
+/*
+MoveHex moves the cursor by whole bytes, onto the high nibble.
+*/
+func (e *Editor) MoveHex(delta int) {
+	if e.hex != nil {
+		e.MoveHexTo(e.hex.cursor + delta)
+	}
+}
+
+func (e *Editor) MoveHexTo(offset int) {
+	if e.hex == nil {
+		return
+	}
+	e.hex.cursor = min(max(offset, 0), e.hex.limit())
+	e.hex.low = false
+}
+
+/*
+MoveHexNibble moves by half bytes in the hex column and by bytes in the
+character column.
+*/
+func (e *Editor) MoveHexNibble(delta int) {
+	h := e.hex
+	if h == nil {
+		return
+	}
+	if h.ascii {
+		e.MoveHex(delta)
+		return
+	}
+	pos := 2*h.cursor + delta
+	if h.low {
+		pos++
+	}
+	pos = min(max(pos, 0), 2*h.limit()+1)
+	h.cursor, h.low = pos/2, pos%2 == 1
+}
+
+func (e *Editor) ToggleHexColumn() {
+	if e.hex != nil {
+		e.hex.ascii = !e.hex.ascii
+		e.hex.low = false
+	}
+}
+
+func (e *Editor) ToggleHexInsert() {
+	if e.hex != nil {
+		e.hex.insert = !e.hex.insert
+		e.MoveHexTo(e.hex.cursor)
+	}
+}
+
+/*
+HexInput types r at the cursor. In the hex column r must be a hex digit and
+sets the nibble under the cursor; in insert mode typing a high nibble inserts a
+new byte first. In the character column r's UTF-8 bytes are written. Returns
+false if r cannot be typed in the current column.
+*/
+func (e *Editor) HexInput(r rune) bool {
+	h := e.hex
+	if h == nil || e.readOnly() {
+		return false
+	}
+	if h.ascii {
+		var buf [utf8.UTFMax]byte
+		for _, c := range buf[:utf8.EncodeRune(buf[:], r)] {
+			e.putHexByte(c)
+			h.cursor++
+		}
+		h.cursor = min(h.cursor, h.limit())
+		return true
+	}
+
+	digit, ok := hexDigit(r)
+	if !ok {
+		return false
+	}
+	if !h.low && (h.insert || len(h.data) == 0) {
+		h.data = append(h.data[:h.cursor], append([]byte{0}, h.data[h.cursor:]...)...)
+	}
+	if h.low {
+		h.data[h.cursor] = h.data[h.cursor]&0xF0 | digit
+	} else {
+		h.data[h.cursor] = h.data[h.cursor]&0x0F | digit<<4
+	}
+	e.markHexModified()
+
+	if h.low {
+		h.cursor, h.low = min(h.cursor+1, h.limit()), false
+	} else {
+		h.low = true
+	}
+	return true
+}
+
+/*
+putHexByte overwrites the byte at the cursor, or inserts before it in insert
+mode or at the end of the data.
+*/
+func (e *Editor) putHexByte(c byte) {
+	h := e.hex
+	if h.insert || h.cursor >= len(h.data) {
+		h.data = append(h.data[:h.cursor], append([]byte{c}, h.data[h.cursor:]...)...)
+	} else {
+		h.data[h.cursor] = c
+	}
+	e.markHexModified()
+}
+
+/*
+DeleteHexByte removes the byte under the cursor.
+*/
+func (e *Editor) DeleteHexByte() {
+	h := e.hex
+	if h == nil || h.cursor >= len(h.data) || e.readOnly() {
+		return
+	}
+	h.data = append(h.data[:h.cursor], h.data[h.cursor+1:]...)
+	h.cursor = min(h.cursor, h.limit())
+	h.low = false
+	e.markHexModified()
+}
+
+func (e *Editor) markHexModified() {
+	e.hex.modified = true
+	e.buffer.dirty = true
+}
+
+func hexDigit(r rune) (byte, bool) {
+	switch {
+	case r >= '0' && r <= '9':
+		return byte(r - '0'), true
+	case r >= 'a' && r <= 'f':
+		return byte(r-'a') + 10, true
+	case r >= 'A' && r <= 'F':
+		return byte(r-'A') + 10, true
+	}
+	return 0, false
+}
+
+/*
+fileBytes returns the buffer exactly as SaveFile would write it.
+*/
+func (b *Buffer) fileBytes() ([]byte, error) {
+	var text bytes.Buffer
+	b.writeContent(&text)
+	return encodeContent(text.Bytes(), b.layout)
+}
+
+/*
+setBytes replaces the buffer's text and layout with data decoded in enc as if it
+had just been loaded. Bytes enc cannot decode leave the buffer as it is; UTF-8
+decodes anything, keeping invalid bytes as they are.
+*/
+func (b *Buffer) setBytes(data []byte, enc Encoding) error {
+	lines, layout, err := decodeContent(data, enc)
+	if err != nil {
+		return err
+	}
+	b.replaceLines(lines)
+	b.layout = layout
+	b.undecodable = ""
+	if layout.encoding == EncodingUTF8 {
+		b.undecodable = undecodable(data, false)
+	}
+	return nil
+}
+
+/*
+byteOffset maps a position to its offset in the saved UTF-8 file.
+*/
+func (b *Buffer) byteOffset(pos Position) int {
+	offset := 0
+	if b.layout.bom {
+		offset = len(utf8BOM)
+	}
+	ending := len(b.layout.format.lineEnding())
+	for i := 0; i < pos.Line && i < b.LineCount(); i++ {
+		offset += len(b.GetLine(i)) + ending
+	}
+	return offset + pos.Col
+}
+
+/*
+positionAt maps an offset in the saved UTF-8 file back to a position, placing
+offsets inside a line ending or byte order mark at the nearest column.
+*/
+func (b *Buffer) positionAt(offset int) Position {
+	if b.layout.bom {
+		offset -= len(utf8BOM)
+	}
+	ending := len(b.layout.format.lineEnding())
+	for i := 0; i < b.LineCount(); i++ {
+		n := len(b.GetLine(i))
+		if offset <= n || i == b.LineCount()-1 {
+			return Position{Line: i, Col: min(max(offset, 0), n)}
+		}
+		offset -= n + ending
+		if offset < 0 {
+			return Position{Line: i, Col: n}
+		}
+	}
+	return Position{}
+}
@@ -0,0 +1,92 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestHexDecode(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		bytes   string // the session's bytes once edited
+		closed  bool
+		lines   []string
+		enc     Encoding
+	}{
+		{
+			name:    "UTF-8 edit",
+			content: "abc\n",
+			bytes:   "abd\n",
+			closed:  true,
+			lines:   []string{"abd"},
+			enc:     EncodingUTF8,
+		},
+		{
+			name:    "UTF-16 edit",
+			content: "\xff\xfea\x00\n\x00",
+			bytes:   "\xff\xfeb\x00\n\x00",
+			closed:  true,
+			lines:   []string{"b"},
+			enc:     EncodingUTF16LE,
+		},
+		{
+			name:    "UTF-16 left with an odd length",
+			content: "\xff\xfea\x00\n\x00",
+			bytes:   "\xff\xfea\x00\n\x00x",
+			lines:   []string{"a"},
+			enc:     EncodingUTF16LE,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, tt.content)
+			e := openTestFile(t, filename)
+			if err := e.OpenHex(); err != nil {
+				t.Fatalf("OpenHex: %v", err)
+			}
+			e.hex.data = []byte(tt.bytes)
+			e.hex.modified = true
+
+			typeKeys(e, "<Esc>")
+			if got := !e.HexActive(); got != tt.closed {
+				t.Fatalf("hex mode closed = %v, want %v: %s", got, tt.closed, e.GetMessage().Text)
+			}
+			if !tt.closed && !strings.HasPrefix(e.GetMessage().Text, "E902") {
+				t.Errorf("message %q, want the decode error", e.GetMessage().Text)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.lines) {
+				t.Errorf("lines %q, want %q", got, tt.lines)
+			}
+			if got := e.buffer.Encoding(); got != tt.enc {
+				t.Errorf("encoding %v, want %v", got, tt.enc)
+			}
+		})
+	}
+}
+
+func TestHexSaveUndecodable(t *testing.T) {
+	filename := tempFile(t, "\xff\xfea\x00\n\x00")
+	e := openTestFile(t, filename)
+	if err := e.OpenHex(); err != nil {
+		t.Fatalf("OpenHex: %v", err)
+	}
+	e.hex.data = append(e.hex.data, 'x')
+	e.hex.modified = true
+
+	if err := e.SaveFile(false); err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	assertFile(t, filename, "\xff\xfea\x00\n\x00x")
+	if msg := e.GetMessage(); msg.Level != MessageWarning || !strings.Contains(msg.Text, "now edited as utf-8") {
+		t.Errorf("message %q at level %v, want a warning about the switch to UTF-8", msg.Text, msg.Level)
+	}
+	if e.buffer.Encoding() != EncodingUTF8 {
+		t.Errorf("encoding %v, want UTF-8", e.buffer.Encoding())
+	}
+	typeKeys(e, "<Esc>")
+	if e.HexActive() {
+		t.Errorf("hex mode still open: %s", e.GetMessage().Text)
+	}
+}
@@ -0,0 +1,298 @@
+package editor
+
+import (
+	"time"
+)
+
+/*
+edit is a single reversible buffer mutation: Deleted was removed at Pos and
+Inserted was put in its place. Reverting an edit swaps the two, so one record
+serves both undo and redo.
+*/
+type edit struct {
+	Pos      Position
+	Deleted  string
+	Inserted string
+}
+
+/*
+change is one undo step. It groups every edit made between a begin/commit pair
+together with the cursor and selection on either side, so undoing an entire
+insert session restores exactly where editing started.
+*/
+type change struct {
+	edits        []edit
+	cursorBefore Position
+	selBefore    Selection
+	cursorAfter  Position
+}
+
+/*
+undoNode is a state in the undo tree. Each node is reached from its parent by
+applying its change; the root is the state the buffer was loaded in. redoChild
+remembers the branch last travelled so redo follows the most recent path.
+*/
+type undoNode struct {
+	seq       int
+	parent    *undoNode
+	children  []*undoNode
+	change    *change
+	time      time.Time
+	redoChild *undoNode
+}
+
+/*
+history keeps every change as a tree so undoing and then editing starts a new
+branch instead of discarding the old one. Nodes are numbered in creation order,
+which gives time travel a chronological axis independent of tree shape. Groups
+nest through a depth counter so compound operations (paste inside an insert
+session, change = delete plus insert) collapse into the outermost step. Edits are
+ignored while applying history so replaying never records itself. When
+disabled, as it is for large files, no steps are kept and edits are only listed
+in direct until the next save, so the swap file can still journal them.
+revision counts recorded edits and saves, telling the swap file whether the
+buffer changed since it was last written.
+*/
+type history struct {
+	root     *undoNode
+	current  *undoNode
+	nodes    []*undoNode
+	pending  *change
+	depth    int
+	applying bool
+	disabled bool
+	saved    *undoNode
+	direct   []edit
+	revision int
+}
+
+func newHistory() *history {
+	root := &undoNode{time: time.Now()}
+	return &history{
+		root:    root,
+		current: root,
+		nodes:   []*undoNode{root},
+		saved:   root,
+	}
+}
+
+func (h *history) begin(cursor Position, sel Selection) {
+	h.depth++
+	if h.depth == 1 {
+		h.pending = &change{cursorBefore: cursor, selBefore: sel}
+	}
+}
+
+/*
+commit closes the innermost group. When the outermost group closes and it
+recorded edits, it becomes a new node below the current state.
+*/
+func (h *history) commit(cursor Position) {
+	if h.depth == 0 {
+		return
+	}
+	h.depth--
+	if h.depth > 0 {
+		return
+	}
+
+	ch := h.pending
+	h.pending = nil
+	if len(ch.edits) == 0 {
+		return
+	}
+	ch.cursorAfter = cursor
+	h.push(ch)
+}
+
+/*
+record appends an edit to the open group. Edits made outside any group become
+a standalone step so no mutation ever escapes the history.
+*/
+func (h *history) record(ed edit) {
+	if h.applying {
+		return
+	}
+	h.revision++
+	if h.disabled {
+		h.direct = append(h.direct, ed)
+		return
+	}
+	if h.pending == nil {
+		h.push(&change{
+			edits:        []edit{ed},
+			cursorBefore: ed.Pos,
+			selBefore:    NewSelection(ed.Pos),
+			cursorAfter:  ed.Pos,
+		})
+		return
+	}
+	h.pending.edits = append(h.pending.edits, ed)
+}
+
+func (h *history) push(ch *change) {
+	node := &undoNode{
+		seq:    len(h.nodes),
+		parent: h.current,
+		change: ch,
+		time:   time.Now(),
+	}
+	h.current.children = append(h.current.children, node)
+	h.current.redoChild = node
+	h.current = node
+	h.nodes = append(h.nodes, node)
+}
+
+func (h *history) markSaved() {
+	h.saved = h.current
+	h.direct = nil
+	h.revision++
+}
+
+/*
+diverge records that the file on disk no longer matches any state in the tree,
+as after :keep or :merge, so no undo state counts as saved. saved becomes a node
+off the tree whose change turns the current state into the disk's text, which
+keeps the journal leading from the disk to the buffer through every later edit.
+*/
+func (h *history) diverge(toDisk []edit) {
+	h.saved = &undoNode{parent: h.current, change: &change{edits: toDisk}}
+	h.revision++
+}
+
+func (h *history) atSaved() bool {
+	return h.current == h.saved
+}
+
+/*
+path returns the nodes to undo (walking up from from) and to redo (walking down
+to target) to travel between two states through their common ancestor.
+*/
+func (h *history) path(from, target *undoNode) (up, down []*undoNode) {
+	onTargetPath := make(map[*undoNode]bool)
+	for n := target; n != nil; n = n.parent {
+		onTargetPath[n] = true
+	}
+
+	n := from
+	for !onTargetPath[n] {
+		up = append(up, n)
+		n = n.parent
+	}
+	for t := target; t != n; t = t.parent {
+		down = append([]*undoNode{t}, down...)
+	}
+	return up, down
+}
+
+/*
+journal flattens the path from one state to another into the edits that replay
+it: reverted edits of every step undone, then the edits of every step redone.
+*/
+func (h *history) journal(from, target *undoNode) []edit {
+	up, down := h.path(from, target)
+
+	var edits []edit
+	for _, n := range up {
+		for i := len(n.change.edits) - 1; i >= 0; i-- {
+			ed := n.change.edits[i]
+			edits = append(edits, edit{Pos: ed.Pos, Deleted: ed.Inserted, Inserted: ed.Deleted})
+		}
+	}
+	for _, n := range down {
+		edits = append(edits, n.change.edits...)
+	}
+	return edits
+}
+
+/*
+unsaved returns the edits that lead from the saved state to the buffer as it is:
+the journal between the two states, then the edits of a group still open.
+Without undo history they are the edits listed since the save.
+*/
+func (h *history) unsaved() []edit {
+	if h.disabled {
+		return h.direct
+	}
+	edits := h.journal(h.saved, h.current)
+	if h.pending != nil {
+		edits = append(edits, h.pending.edits...)
+	}
+	return edits
+}
+
+/*
+nodeBySeq clamps seq into the valid range and returns that node.
+*/
+func (h *history) nodeBySeq(seq int) *undoNode {
+	if seq < 0 {
+		seq = 0
+	}
+	if seq >= len(h.nodes) {
+		seq = len(h.nodes) - 1
+	}
+	return h.nodes[seq]
+}
+
+/*
+nodeAtTime returns the newest state created at or before t, falling back to the
+root when t predates every change.
+*/
+func (h *history) nodeAtTime(t time.Time) *undoNode {
+	found := h.root
+	for _, n := range h.nodes {
+		if !n.time.After(t) {
+			found = n
+		}
+	}
+	return found
+}
+
+/*
+UndoTreeEntry describes one state of the undo tree for display. Column is the
+branch the state lives on: zero for the original line of history, increasing for
+each fork taken off it.
+*/
+type UndoTreeEntry struct {
+	Seq     int
+	Column  int
+	Time    time.Time
+	Edits   int
+	Current bool
+	Saved   bool
+}
+
+/*
+entries flattens the tree newest-first. A node inherits its parent's column when
+it is the first child, and opens a new column otherwise.
+*/
+func (h *history) entries() []UndoTreeEntry {
+	columns := make(map[*undoNode]int)
+	next := 1
+	for _, n := range h.nodes {
+		if n.parent == nil || n.parent.children[0] == n {
+			columns[n] = columns[n.parent]
+			continue
+		}
+		columns[n] = next
+		next++
+	}
+
+	result := make([]UndoTreeEntry, 0, len(h.nodes))
+	for i := len(h.nodes) - 1; i >= 0; i-- {
+		n := h.nodes[i]
+		edits := 0
+		if n.change != nil {
+			edits = len(n.change.edits)
+		}
+		result = append(result, UndoTreeEntry{
+			Seq:     n.seq,
+			Column:  columns[n],
+			Time:    n.time,
+			Edits:   edits,
+			Current: n == h.current,
+			Saved:   n == h.saved,
+		})
+	}
+	return result
+}
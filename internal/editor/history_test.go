@@ -0,0 +1,168 @@
+package editor
+
+import (
+	"slices"
+	"testing"
+)
+
+/*
+newTestEditor opens an editor on an unnamed buffer holding lines.
+*/
+func newTestEditor(lines ...string) *Editor {
+	e := New()
+	e.buffer.lines = newRope(lines)
+	e.buffer.base = slices.Clone(lines)
+	return e
+}
+
+/*
+feed runs keys as typed, failing the test if they cannot be parsed or run.
+*/
+func feed(t *testing.T, e *Editor, keys string) {
+	t.Helper()
+	if _, err := e.FeedKeys(keys); err != nil {
+		t.Fatalf("FeedKeys(%q): %v", keys, err)
+	}
+}
+
+func TestUndoGrouping(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		keys   string
+		want   []string
+		cursor Position
+	}{
+		{
+			name:  "insert session is one step",
+			lines: []string{"abc"},
+			keys:  "ihello<CR>world<Esc>u",
+			want:  []string{"abc"},
+		},
+		{
+			name:   "each insert session is its own step",
+			lines:  []string{"abc"},
+			keys:   "ione <Esc>atwo <Esc>u",
+			want:   []string{"one abc"},
+			cursor: Position{Line: 0, Col: 4},
+		},
+		{
+			name:  "count undoes several steps",
+			lines: []string{"abc"},
+			keys:  "ia<Esc>ib<Esc>ic<Esc>2u",
+			want:  []string{"aabc"},
+		},
+		{
+			name:   "redo replays the step",
+			lines:  []string{"abc"},
+			keys:   "ihello<CR><Esc>u<C-r>",
+			want:   []string{"hello", "abc"},
+			cursor: Position{Line: 1, Col: 0},
+		},
+		{
+			name:  "deleting a selection is one step",
+			lines: []string{"one", "two", "three"},
+			keys:  "jx2du",
+			want:  []string{"one", "two", "three"},
+		},
+		{
+			name:   "undo restores the cursor where the change began",
+			lines:  []string{"one", "two"},
+			keys:   "jaX<Esc>u",
+			want:   []string{"one", "two"},
+			cursor: Position{Line: 1, Col: 1},
+		},
+		{
+			name:  "undo stops at the oldest change",
+			lines: []string{"abc"},
+			keys:  "ix<Esc>uuu",
+			want:  []string{"abc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			e.FeedKeys(tt.keys)
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+			if tt.cursor != (Position{}) && e.cursor != tt.cursor {
+				t.Errorf("cursor = %v, want %v", e.cursor, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestUndoMarksClean(t *testing.T) {
+	e := newTestEditor("abc")
+	feed(t, e, "ix<Esc>")
+	if !e.buffer.IsDirty() {
+		t.Fatal("buffer clean after an edit")
+	}
+	feed(t, e, "u")
+	if e.buffer.IsDirty() {
+		t.Error("buffer dirty after undoing every change")
+	}
+}
+
+func TestUndoBranches(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+		want []string
+	}{
+		{
+			name: "editing after undo keeps the undone branch",
+			keys: "ia<Esc>uib<Esc>:earlier<CR>",
+			want: []string{"aabc"},
+		},
+		{
+			name: "earlier walks states in creation order across branches",
+			keys: "ia<Esc>uib<Esc>:earlier 2<CR>",
+			want: []string{"abc"},
+		},
+		{
+			name: "later returns to the newest branch",
+			keys: "ia<Esc>uib<Esc>:earlier 2<CR>:later 2<CR>",
+			want: []string{"babc"},
+		},
+		{
+			name: "redo follows the branch made last",
+			keys: "ia<Esc>uib<Esc>u<C-r>",
+			want: []string{"babc"},
+		},
+		{
+			name: "redo follows the branch travelled last",
+			keys: "ia<Esc>uib<Esc>:earlier<CR>u<C-r>",
+			want: []string{"aabc"},
+		},
+		{
+			name: "earlier by time reaches the original text",
+			keys: "ia<Esc>ib<Esc>:earlier 1h<CR>",
+			want: []string{"abc"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor("abc")
+			feed(t, e, tt.keys)
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUndoTreeEntries(t *testing.T) {
+	e := newTestEditor("abc")
+	feed(t, e, "ia<Esc>uib<Esc>uic<Esc>")
+	entries := e.UndoTree()
+	if len(entries) != 4 {
+		t.Fatalf("%d entries, want the root and three branches", len(entries))
+	}
+	for _, entry := range entries {
+		if entry.Current != (entry.Seq == 3) {
+			t.Errorf("entry %d: Current = %v", entry.Seq, entry.Current)
+		}
+	}
+}
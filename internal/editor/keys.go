@@ -0,0 +1,697 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+Keys are named as bubbletea prints them: the typed text for keys that produce
+text, such as "j" or "é", and names like "esc", "enter", "space", "ctrl+r" and
+"alt+k" for the rest. HandleKey runs one of them in the current mode, so the
+terminal front end, :normal and anything else that replays keys share one
+dispatch.
+*/
+
+/*
+maxFeedDepth bounds how deeply replayed keys may replay keys again, as a macro
+that ends by replaying itself does.
+*/
+const maxFeedDepth = 1000
+
+/*
+keyText is the text a key types, or "" for keys such as esc that type none.
+*/
+func keyText(key string) string {
+	if key == "space" {
+		return " "
+	}
+	if key == "" || nextGrapheme(key, 0) != len(key) {
+		return ""
+	}
+	return key
+}
+
+/*
+HandleKey runs one key in the current mode, as if typed. Reports whether the key
+ends the editor.
+*/
+func (e *Editor) HandleKey(key string) bool {
+	if e.recording != 0 && e.feeding == 0 {
+		e.recorded = append(e.recorded, key)
+	}
+	switch e.mode {
+	case ModeNormal:
+		return e.normalKey(key)
+	case ModeInsert:
+		e.insertKey(key)
+	case ModeVisual:
+		return e.visualKey(key)
+	case ModeCommand:
+		return e.commandKey(key)
+	case ModeUndoTree:
+		e.undoTreeKey(key)
+	case ModeHex:
+		e.hexKey(key)
+	case ModeConfirm:
+		e.ConfirmSubstitution(key)
+	}
+	return false
+}
+
+/*
+FeedKeys runs keys written in key notation, as ParseKeys reads it, one after
+another, stopping with ErrKeyFailed at the first that fails. Reports whether
+they end the editor.
+*/
+func (e *Editor) FeedKeys(keys string) (bool, error) {
+	if e.feeding >= maxFeedDepth {
+		return false, errors.New("E169: Command too recursive")
+	}
+	e.feeding++
+	defer func() { e.feeding-- }()
+
+	for _, key := range ParseKeys(keys) {
+		e.failed = false
+		if e.HandleKey(key) {
+			return true, nil
+		}
+		if e.failed {
+			return false, ErrKeyFailed
+		}
+	}
+	return false, nil
+}
+
+/*
+keyNames maps the names in key notation, lower-cased, to keys.
+*/
+var keyNames = map[string]string{
+	"esc":      "esc",
+	"cr":       "enter",
+	"enter":    "enter",
+	"return":   "enter",
+	"bs":       "backspace",
+	"space":    "space",
+	"tab":      "tab",
+	"lt":       "<",
+	"bar":      "|",
+	"del":      "delete",
+	"insert":   "insert",
+	"up":       "up",
+	"down":     "down",
+	"left":     "left",
+	"right":    "right",
+	"home":     "home",
+	"end":      "end",
+	"pageup":   "pgup",
+	"pagedown": "pgdown",
+}
+
+/*
+ParseKeys splits keys written in vim's key notation into keys. Characters stand
+for themselves, and names in angle brackets for special keys: <Esc>, <CR>,
+<BS>, <Space>, <Tab>, <lt> for a literal <, arrows such as <Up>, and modifiers
+as in <C-r> and <A-k> or <M-k>. A < that starts no such name is literal.
+*/
+func ParseKeys(s string) []string {
+	var keys []string
+	for i := 0; i < len(s); {
+		if s[i] == '<' {
+			if end := strings.IndexByte(s[i:], '>'); end > 1 {
+				if key, ok := parseKeyName(s[i+1 : i+end]); ok {
+					keys = append(keys, key)
+					i += end + 1
+					continue
+				}
+			}
+		}
+		next := nextGrapheme(s, i)
+		key := s[i:next]
+		if key == " " {
+			key = "space"
+		}
+		keys = append(keys, key)
+		i = next
+	}
+	return keys
+}
+
+func parseKeyName(name string) (string, bool) {
+	var mods string
+	for len(name) > 2 && name[1] == '-' {
+		switch name[0] {
+		case 'c', 'C':
+			mods += "ctrl+"
+		case 'a', 'A', 'm', 'M':
+			mods += "alt+"
+		default:
+			return "", false
+		}
+		name = name[2:]
+	}
+	if key, ok := keyNames[strings.ToLower(name)]; ok {
+		return mods + key, true
+	}
+	if mods != "" && utf8.RuneCountInString(name) == 1 {
+		return mods + strings.ToLower(name), true
+	}
+	return "", false
+}
+
+/*
+countDigit reports whether key continues a numeric prefix. A leading 0 is not a
+count but the move to the start of the line.
+*/
+func countDigit(key string, pending int) (int, bool) {
+	if len(key) != 1 || key[0] < '0' || key[0] > '9' || (key == "0" && pending == 0) {
+		return 0, false
+	}
+	return int(key[0] - '0'), true
+}
+
+/*
+motionPrefixes are the keys that wait for more keys to complete a motion or,
+for m, a text object selection such as mi( or maw.
+*/
+var motionPrefixes = map[string]bool{"g": true, "f": true, "t": true, "F": true, "T": true, "m": true}
+
+/*
+motionKey takes care of what normal and visual mode share: the numeric prefix,
+prefix keys like g and f, the motions, which extend the selection in visual
+mode, and text objects. Returns the count for the key and whether the key was
+consumed.
+*/
+func (e *Editor) motionKey(key string) (int, bool) {
+	prefix := e.TakePending()
+	if prefix == "" {
+		if digit, ok := countDigit(key, e.GetCount()); ok {
+			e.AppendCount(digit)
+			return 0, true
+		}
+		if motionPrefixes[key] {
+			e.SetPending(key)
+			return 0, true
+		}
+	}
+	if prefix == "m" {
+		if key == "i" || key == "a" {
+			// The count waits for the object
+			e.SetPending(prefix + key)
+		} else {
+			e.TakeCount()
+		}
+		return 0, true
+	}
+	counted := e.GetCount() > 0
+	count := e.TakeCount()
+	before := e.cursor
+
+	switch prefix {
+	case "mi", "ma":
+		e.SelectTextObject(keyText(key), prefix == "ma", count)
+		return count, true
+	case "g":
+		switch key {
+		case "g":
+			if !counted {
+				count = 1
+			}
+			e.MoveToLine(count)
+		case "e":
+			e.MoveWordEndBackward(count, false)
+		case "E":
+			e.MoveWordEndBackward(count, true)
+		}
+		if key != "g" {
+			e.unmoved(before)
+		}
+		return count, true
+	case "f", "t", "F", "T":
+		e.FindChar(keyText(key), prefix == "f" || prefix == "t", prefix == "t" || prefix == "T", count)
+		e.unmoved(before)
+		return count, true
+	}
+
+	switch key {
+	case "h", "left":
+		e.MoveCursor(0, -count)
+	case "j", "down":
+		e.MoveCursor(count, 0)
+	case "k", "up":
+		e.MoveCursor(-count, 0)
+	case "l", "right":
+		e.MoveCursor(0, count)
+
+	case "w", "W":
+		e.MoveWordForward(count, key == "W")
+	case "b", "B":
+		e.MoveWordBackward(count, key == "B")
+	case "e", "E":
+		e.MoveWordEnd(count, key == "E")
+
+	case "0":
+		e.MoveToLineStart()
+	case "^":
+		e.MoveToFirstNonBlank()
+	case "$":
+		e.MoveToLineEnd()
+
+	case "G":
+		if !counted {
+			count = e.buffer.LineCount()
+		}
+		e.MoveToLine(count)
+	case "}":
+		e.MoveParagraphForward(count)
+	case "{":
+		e.MoveParagraphBackward(count)
+	case "%":
+		if counted {
+			e.MoveToPercent(count)
+		} else {
+			e.MoveToMatchingBracket()
+		}
+
+	case ";":
+		e.RepeatFind(count, false)
+	case ",":
+		e.RepeatFind(count, true)
+
+	case "/":
+		e.openPrompt(PromptSearchForward)
+	case "?":
+		e.openPrompt(PromptSearchBackward)
+	case "n":
+		e.SearchNext(count, false)
+	case "N":
+		e.SearchNext(count, true)
+	case "*":
+		e.SearchWord(true, count)
+	case "#":
+		e.SearchWord(false, count)
+
+	case "H":
+		e.MoveToScreenTop(count)
+	case "M":
+		e.MoveToScreenMiddle()
+	case "L":
+		e.MoveToScreenBottom(count)
+	case "ctrl+d", "ctrl+u":
+		size := 0
+		if counted {
+			size = count
+		}
+		direction := 1
+		if key == "ctrl+u" {
+			direction = -1
+		}
+		e.ScrollHalfPage(direction, size)
+
+	default:
+		return count, false
+	}
+	if mayFail[key] {
+		e.unmoved(before)
+	}
+	return count, true
+}
+
+/*
+mayFail are the motions that fail when they cannot move, unlike 0 or G, which
+are never wrong however close the cursor already is.
+*/
+var mayFail = map[string]bool{
+	"h": true, "j": true, "k": true, "l": true, "left": true, "down": true, "up": true, "right": true,
+	"w": true, "W": true, "b": true, "B": true, "e": true, "E": true, "}": true, "{": true,
+	";": true, ",": true, "n": true, "N": true, "*": true, "#": true,
+}
+
+/*
+unmoved fails the key when the motion left the cursor where it was before, as j
+does on the last line, so replayed keys stop there.
+*/
+func (e *Editor) unmoved(before Position) {
+	if e.cursor == before {
+		e.failed = true
+	}
+}
+
+/*
+normalKey handles a key in normal mode and reports whether it ends the editor.
+q belongs to macro recording, so a clean buffer is quit with ctrl+c or :q.
+*/
+func (e *Editor) normalKey(key string) bool {
+	if handled, quit := e.registerKey(key); handled {
+		return quit
+	}
+	count, handled := e.motionKey(key)
+	if handled {
+		return false
+	}
+
+	switch key {
+	case "ctrl+c":
+		if !e.buffer.IsDirty() {
+			return true
+		}
+		e.SetMessage(MessageError, ErrNoWrite.Error())
+
+	case "i":
+		e.SetMode(ModeInsert)
+	case "a":
+		e.MoveCursor(0, 1)
+		e.SetMode(ModeInsert)
+
+	case "v":
+		e.SetMode(ModeVisual)
+
+	case "x":
+		e.SelectLine(count)
+		e.SetMode(ModeVisual)
+
+	case "d":
+		if e.uncounted(key, count) && !e.selection.IsEmpty() {
+			e.DeleteSelection()
+		}
+	case "c":
+		if e.uncounted(key, count) && !e.selection.IsEmpty() {
+			e.ChangeSelection()
+		}
+	case "y":
+		if e.uncounted(key, count) {
+			e.YankSelection()
+		}
+	case "p":
+		e.Paste(count)
+
+	case "C":
+		e.CopySelectionBelow(count)
+	case "space":
+		e.KeepPrimarySelection()
+	case "alt+space":
+		e.RemovePrimarySelection()
+	case "(":
+		e.RotatePrimary(-count)
+	case ")":
+		e.RotatePrimary(count)
+
+	case "u":
+		for range count {
+			e.Undo()
+		}
+	case "ctrl+r":
+		for range count {
+			e.Redo()
+		}
+
+	case ":":
+		e.SetMode(ModeCommand)
+		e.ClearCommand()
+		e.ClearMessage()
+	}
+	return false
+}
+
+/*
+uncounted refuses a count before an operator. d, c and y act on the selection
+as it is, so repeating them would act on what the first one left; the selection
+is grown with a count instead, as in 3x before d.
+*/
+func (e *Editor) uncounted(key string, count int) bool {
+	if count > 1 {
+		e.SetMessage(MessageError, fmt.Sprintf("Count not allowed for %s; select first, as in %dx%s", key, count, key))
+		e.failed = true
+		return false
+	}
+	return true
+}
+
+func (e *Editor) insertKey(key string) {
+	switch key {
+	case "esc":
+		e.MoveCursor(0, -1)
+		e.SetMode(ModeNormal)
+
+	case "enter":
+		e.InsertNewline()
+
+	case "backspace":
+		e.Backspace()
+
+	case "left":
+		e.MoveCursor(0, -1)
+	case "right":
+		e.MoveCursor(0, 1)
+	case "up":
+		e.MoveCursor(-1, 0)
+	case "down":
+		e.MoveCursor(1, 0)
+
+	default:
+		// Insert the key's text, which may be a multi-rune grapheme cluster
+		for _, r := range keyText(key) {
+			e.InsertChar(r)
+		}
+	}
+}
+
+func (e *Editor) visualKey(key string) bool {
+	if handled, quit := e.registerKey(key); handled {
+		return quit
+	}
+	count, handled := e.motionKey(key)
+	if handled {
+		return false
+	}
+
+	switch key {
+	case "esc":
+		e.SetMode(ModeNormal)
+
+	case "d":
+		if e.uncounted(key, count) {
+			e.DeleteSelection()
+			e.SetMode(ModeNormal)
+		}
+	case "c":
+		if e.uncounted(key, count) {
+			e.ChangeSelection()
+		}
+	case "y":
+		if e.uncounted(key, count) {
+			e.YankSelection()
+			e.SetMode(ModeNormal)
+		}
+
+	case "C":
+		e.CopySelectionBelow(count)
+	case "space":
+		e.KeepPrimarySelection()
+	case "alt+space":
+		e.RemovePrimarySelection()
+	case "(":
+		e.RotatePrimary(-count)
+	case ")":
+		e.RotatePrimary(count)
+
+	case "s":
+		e.openPrompt(PromptSelect)
+	case "S":
+		e.openPrompt(PromptSplit)
+	case "K":
+		e.openPrompt(PromptKeep)
+	case "alt+k":
+		e.openPrompt(PromptDrop)
+	case "alt+s":
+		e.SplitLines()
+
+	case ":":
+		// The command applies to the selected lines, as in vim
+		e.SetMode(ModeCommand)
+		e.ClearCommand()
+		e.ClearMessage()
+		for _, r := range "'<,'>" {
+			e.AppendCommand(r)
+		}
+	}
+	return false
+}
+
+func (e *Editor) commandKey(key string) bool {
+	switch key {
+	case "esc":
+		if e.prompt != PromptCommand {
+			e.ClosePrompt()
+		} else {
+			e.SetMode(e.commandReturnMode())
+		}
+		e.ClearCommand()
+
+	case "enter":
+		if e.ExecuteCommand() {
+			return true
+		}
+		if e.mode == ModeCommand {
+			e.SetMode(e.commandReturnMode())
+		}
+
+	case "backspace":
+		e.BackspaceCommand()
+	case "up":
+		e.RecallHistory(-1)
+	case "down":
+		e.RecallHistory(1)
+
+	default:
+		for _, r := range keyText(key) {
+			e.AppendCommand(r)
+		}
+	}
+	return false
+}
+
+/*
+openPrompt reads a regex on the command line for a search or one of the
+selection commands.
+*/
+func (e *Editor) openPrompt(p Prompt) {
+	e.OpenPrompt(p)
+	e.ClearMessage()
+}
+
+/*
+commandReturnMode is the mode a finished command line returns to: hex mode if
+it was entered from a hex session that is still open.
+*/
+func (e *Editor) commandReturnMode() Mode {
+	if e.HexActive() {
+		return ModeHex
+	}
+	return ModeNormal
+}
+
+func (e *Editor) hexKey(key string) {
+	ascii := e.hex.ascii
+	cursor := e.hex.cursor
+	rowStart := cursor - cursor%HexRowBytes
+	page := e.viewHeight * HexRowBytes
+
+	switch {
+	case key == "esc":
+		e.reportError(e.CloseHex())
+	case key == "tab":
+		e.ToggleHexColumn()
+	case key == "insert" || (key == "i" && !ascii):
+		e.ToggleHexInsert()
+	case key == "delete" || (key == "x" && !ascii):
+		e.DeleteHexByte()
+
+	case key == "left":
+		e.MoveHex(-1)
+	case key == "right":
+		e.MoveHex(1)
+	case key == "h" && !ascii:
+		e.MoveHexNibble(-1)
+	case key == "l" && !ascii:
+		e.MoveHexNibble(1)
+	case key == "up" || (key == "k" && !ascii):
+		e.MoveHex(-HexRowBytes)
+	case key == "down" || (key == "j" && !ascii):
+		e.MoveHex(HexRowBytes)
+	case key == "home":
+		e.MoveHexTo(rowStart)
+	case key == "end":
+		e.MoveHexTo(rowStart + HexRowBytes - 1)
+	case key == "pgup":
+		e.MoveHex(-page)
+	case key == "pgdown":
+		e.MoveHex(page)
+	case key == "g" && !ascii:
+		e.MoveHexTo(0)
+	case key == "G" && !ascii:
+		e.MoveHexTo(len(e.hex.data))
+
+	case key == ":" && !ascii:
+		e.SetMode(ModeCommand)
+		e.ClearCommand()
+		e.ClearMessage()
+
+	default:
+		for _, r := range keyText(key) {
+			e.HexInput(r)
+		}
+	}
+}
+
+func (e *Editor) undoTreeKey(key string) {
+	switch key {
+	case "esc", "q", "enter":
+		e.SetMode(ModeNormal)
+
+	case "j", "down":
+		e.MoveUndoTreeSelection(1)
+	case "k", "up":
+		e.MoveUndoTreeSelection(-1)
+	}
+}
+
+func init() {
+	registerCommand(exCommand{name: "normal", abbrev: 4, ranged: true, bang: true, run: func(e *Editor, c *exCall) error {
+		return e.Normal(c)
+	}})
+}
+
+/*
+Normal runs :normal {keys}: the keys are replayed in normal mode, once at the
+cursor or, with a range, on each line of it with the cursor at the start of the
+line. The keys for a line stop at the first that fails, and whatever they leave
+unfinished, such as insert mode or a half-typed command, is ended as if with
+esc. All their changes undo as one step.
+*/
+func (e *Editor) Normal(c *exCall) error {
+	if c.arg == "" {
+		return errors.New("E471: Argument required")
+	}
+	if e.mode != ModeNormal {
+		// Run from the command line, which has not returned to normal mode yet
+		e.SetMode(ModeNormal)
+	}
+
+	e.beginChange()
+	defer e.endChange()
+	if !c.ranged {
+		return e.replay(c)
+	}
+	for line := c.first; line <= c.last && line < e.buffer.LineCount(); line++ {
+		e.collapse()
+		e.cursor = Position{Line: line}
+		e.selection = NewSelection(e.cursor)
+		if err := e.replay(c); err != nil || c.quit {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+replay feeds the keys of a :normal and ends anything they left unfinished.
+*/
+func (e *Editor) replay(c *exCall) error {
+	quit, err := e.FeedKeys(c.arg)
+	c.quit = quit
+	if quit || (err != nil && !errors.Is(err, ErrKeyFailed)) {
+		return err
+	}
+	e.TakePending()
+	e.TakeCount()
+	// A prompt opened from visual mode takes a second esc
+	for range 2 {
+		if e.mode != ModeNormal {
+			e.HandleKey("esc")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package editor
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"ihello<Esc>", []string{"i", "h", "e", "l", "l", "o", "esc"}},
+		{"a b", []string{"a", "space", "b"}},
+		{"<CR><cr><Enter><BS><Tab><Space>", []string{"enter", "enter", "enter", "backspace", "tab", "space"}},
+		{"<C-r><c-R><A-k><M-k>", []string{"ctrl+r", "ctrl+r", "alt+k", "alt+k"}},
+		{"<C-A-x>", []string{"ctrl+alt+x"}},
+		{"<lt>Esc>", []string{"<", "E", "s", "c", ">"}},
+		{"<bogus>", []string{"<", "b", "o", "g", "u", "s", ">"}},
+		{"a<", []string{"a", "<"}},
+		{"<>", []string{"<", ">"}},
+		{"é👍🏽", []string{"é", "👍🏽"}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			if got := ParseKeys(tt.in); !slices.Equal(got, tt.want) {
+				t.Errorf("ParseKeys(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormal(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		cmd   string
+		want  []string
+		quit  bool
+		err   string
+	}{
+		{
+			name:  "insert left open is ended",
+			lines: []string{"abc"},
+			cmd:   "normal ihello",
+			want:  []string{"helloabc"},
+		},
+		{
+			name:  "keys in notation",
+			lines: []string{"abc"},
+			cmd:   "norm $ix<Esc>0iy",
+			want:  []string{"yabxc"},
+		},
+		{
+			name:  "every line of a range",
+			lines: []string{"a", "b", "c"},
+			cmd:   "%norm i- ",
+			want:  []string{"-a", "-b", "-c"},
+		},
+		{
+			name:  "each line starts at its first column",
+			lines: []string{"abc", "abc"},
+			cmd:   "1,2norm lix",
+			want:  []string{"axbc", "axbc"},
+		},
+		{
+			name:  "a failing key skips the rest of that line only",
+			lines: []string{"abc", "xyz", "b"},
+			cmd:   "%norm fbiX",
+			want:  []string{"aXbc", "xyz", "b"},
+		},
+		{
+			name:  "a count",
+			lines: []string{"abcdef"},
+			cmd:   "norm 3lix",
+			want:  []string{"abcxdef"},
+		},
+		{
+			name:  "visual mode left open is ended",
+			lines: []string{"abc"},
+			cmd:   "norm vl",
+			want:  []string{"abc"},
+		},
+		{
+			name:  "command line left open is ended",
+			lines: []string{"abc"},
+			cmd:   "norm :s/a/b/",
+			want:  []string{"abc"},
+		},
+		{
+			name:  "ex commands",
+			lines: []string{"abc"},
+			cmd:   "norm :s/a/b/<CR>",
+			want:  []string{"bbc"},
+		},
+		{
+			name:  "under global",
+			lines: []string{"a1", "b", "a2"},
+			cmd:   "g/a/norm ix",
+			want:  []string{"xa1", "b", "xa2"},
+		},
+		{
+			name:  "quitting",
+			lines: []string{"abc"},
+			cmd:   "norm :q<CR>",
+			want:  []string{"abc"},
+			quit:  true,
+		},
+		{
+			name:  "no keys",
+			lines: []string{"abc"},
+			cmd:   "normal",
+			want:  []string{"abc"},
+			err:   "E471",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			quit, err := e.runEx(tt.cmd)
+			if tt.err == "" && err != nil {
+				t.Fatalf("runEx(%q): %v", tt.cmd, err)
+			}
+			if tt.err != "" && (err == nil || !strings.HasPrefix(err.Error(), tt.err)) {
+				t.Fatalf("runEx(%q) = %v, want %s", tt.cmd, err, tt.err)
+			}
+			if quit != tt.quit {
+				t.Errorf("quit = %v, want %v", quit, tt.quit)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+			if !quit && e.GetMode() != ModeNormal {
+				t.Errorf("mode %v after :normal, want normal", e.GetMode())
+			}
+
+			// All the changes undo as one step
+			feed(t, e, "u")
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.lines) {
+				t.Errorf("after undo lines %q, want %q", got, tt.lines)
+			}
+		})
+	}
+}
+
+func TestNormalFromCommandLine(t *testing.T) {
+	e := newTestEditor("a", "b")
+	feed(t, e, ":%norm ix<CR>")
+	if got, want := e.buffer.allLines(), []string{"xa", "xb"}; !slices.Equal(got, want) {
+		t.Errorf("lines %q, want %q", got, want)
+	}
+	if e.GetMode() != ModeNormal {
+		t.Errorf("mode %v, want normal", e.GetMode())
+	}
+}
+
+func TestCounts(t *testing.T) {
+	tests := []struct {
+		keys   string
+		lines  []string
+		want   []string
+		cursor Position
+		msg    string
+	}{
+		{keys: "5j", cursor: Position{Line: 5}},
+		{keys: "3l", cursor: Position{Col: 3}},
+		{keys: "99j", cursor: Position{Line: 19}},
+		{keys: "2w", lines: []string{"a b c d"}, cursor: Position{Col: 4}},
+		{keys: "3xd", lines: []string{"1", "2", "3", "4"}, want: []string{"", "4"}},
+		{keys: "vly3p", lines: []string{"ab"}, want: []string{"aaaab"}, cursor: Position{Col: 4}},
+		{keys: "2d", msg: "Count not allowed for d; select first, as in 2xd"},
+		{keys: "x2d", msg: "Count not allowed for d"},
+		{keys: "vl3y", msg: "Count not allowed for y"},
+		{keys: "x2c", msg: "Count not allowed for c"},
+		{keys: "d3w", lines: []string{"a b c d"}, cursor: Position{Col: 6}},
+		{keys: "<C-d>", cursor: Position{Line: 5}},
+		{keys: "3<C-d>", cursor: Position{Line: 3}},
+		{keys: "3<C-d><C-d>", cursor: Position{Line: 6}},
+		{keys: "<C-d><C-d><C-u>", cursor: Position{Line: 5}},
+		{keys: "4<C-d><C-u>", cursor: Position{Line: 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keys, func(t *testing.T) {
+			lines := tt.lines
+			if lines == nil {
+				for i := range 20 {
+					lines = append(lines, fmt.Sprintf("line %d", i))
+				}
+			}
+			want := tt.want
+			if want == nil {
+				want = lines
+			}
+			e := newTestEditor(lines...)
+			e.SetViewport(0, 10)
+			typeKeys(e, tt.keys)
+
+			if got := e.buffer.allLines(); !slices.Equal(got, want) {
+				t.Errorf("lines %q, want %q", got, want)
+			}
+			if e.GetCount() != 0 {
+				t.Errorf("count %d left pending", e.GetCount())
+			}
+			if tt.msg != "" {
+				if msg := e.GetMessage(); msg.Level != MessageError || !strings.HasPrefix(msg.Text, tt.msg) {
+					t.Errorf("message %q, want %q", msg.Text, tt.msg)
+				}
+				return
+			}
+			if e.cursor != tt.cursor {
+				t.Errorf("cursor %+v, want %+v", e.cursor, tt.cursor)
+			}
+		})
+	}
+}
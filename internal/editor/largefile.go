@@ -0,0 +1,313 @@
+package editor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+DefaultLargeFileThreshold is the file size from which buffers switch to
+large-file mode. It can be changed with ":set largefile=SIZE".
+*/
+const DefaultLargeFileThreshold = 64 << 20
+
+const (
+	indexChunkSize   = 1 << 20 // Bytes read per step of the background scan
+	indexBlockLines  = 64      // Lines per recorded offset
+	indexCacheBlocks = 8       // Decoded blocks kept for scrolling
+)
+
+/*
+lineIndex locates the lines of a large file without holding its text. A
+background goroutine scans the file in chunks, hashing it on the way, and records
+the byte offset of every indexBlockLines-th line, which costs a fraction of the
+memory a full offset table would. A line is read on demand by decoding the block
+that holds it; the few most recently decoded blocks are cached so scrolling reads
+each block from disk once. The file stays open as long as the index since text
+that was never edited is only ever read from it.
+*/
+type lineIndex struct {
+	file *os.File
+	size int64
+	done chan struct{}
+
+	mu       sync.Mutex
+	blocks   []int64
+	lines    int
+	crlf     int
+	scanned  int64
+	finished bool
+	layout   fileLayout
+	hash     string
+	err      error
+	cache    []indexBlock
+}
+
+type indexBlock struct {
+	block int
+	lines []string
+}
+
+/*
+openLineIndex starts indexing a file. The first chunk is scanned before
+returning so the top of the file can be shown straight away; the rest is
+indexed in the background.
+*/
+func openLineIndex(filename string, size int64) (*lineIndex, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	x := &lineIndex{file: f, size: size, done: make(chan struct{}), blocks: []int64{0}}
+	h := sha256.New()
+	buf := make([]byte, indexChunkSize)
+	more, err := x.scanChunk(buf, h)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if !more {
+		x.finish(h)
+		return x, nil
+	}
+
+	go func() {
+		for more && err == nil {
+			more, err = x.scanChunk(buf, h)
+		}
+		if err != nil {
+			x.mu.Lock()
+			x.err = err
+			x.mu.Unlock()
+		}
+		x.finish(h)
+	}()
+	return x, nil
+}
+
+/*
+scanChunk reads and indexes the next chunk of the file. It reports whether any
+of the file remains to be read.
+*/
+func (x *lineIndex) scanChunk(buf []byte, h hash.Hash) (bool, error) {
+	x.mu.Lock()
+	off, lines, crlf := x.scanned, x.lines, x.crlf
+	x.mu.Unlock()
+
+	n, err := x.file.ReadAt(buf, off)
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	chunk := buf[:n]
+	h.Write(chunk)
+
+	var starts []int64
+	for i := 0; ; {
+		j := bytes.IndexByte(chunk[i:], '\n')
+		if j < 0 {
+			break
+		}
+		p := i + j
+		if p > 0 && chunk[p-1] == '\r' {
+			crlf++
+		} else if p == 0 && off > 0 && x.byteAt(off-1) == '\r' {
+			crlf++
+		}
+		lines++
+		if lines%indexBlockLines == 0 {
+			starts = append(starts, off+int64(p)+1)
+		}
+		i = p + 1
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if off == 0 && bytes.HasPrefix(chunk, utf8BOM) {
+		x.layout.bom = true
+	}
+	x.blocks = append(x.blocks, starts...)
+	x.lines, x.crlf = lines, crlf
+	x.scanned = off + int64(n)
+	x.setFormat()
+	return n > 0 && err == nil, nil
+}
+
+func (x *lineIndex) byteAt(off int64) byte {
+	var b [1]byte
+	x.file.ReadAt(b[:], off)
+	return b[0]
+}
+
+/*
+setFormat applies the rule splitContent uses: the file is DOS only if every line
+break so far is CRLF. While scanning this is provisional, so decoded blocks are
+dropped whenever it flips.
+*/
+func (x *lineIndex) setFormat() {
+	format := FormatUnix
+	if x.lines > 0 && x.crlf == x.lines {
+		format = FormatDOS
+	}
+	if format != x.layout.format {
+		x.layout.format = format
+		x.cache = nil
+	}
+}
+
+/*
+finish records the file's final layout and hash once the scan ends. A last line
+without a terminator counts as a line of its own.
+*/
+func (x *lineIndex) finish(h hash.Hash) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.scanned > 0 && x.byteAt(x.scanned-1) == '\n' {
+		x.layout.finalNewline = true
+	} else {
+		x.lines++
+	}
+	x.finished = true
+	x.hash = hex.EncodeToString(h.Sum(nil))
+	x.cache = nil
+	close(x.done)
+}
+
+/*
+Len returns the number of lines indexed so far, which is the whole file once
+indexing finished. A file always has at least one line.
+*/
+func (x *lineIndex) Len() int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return max(x.lines, 1)
+}
+
+func (x *lineIndex) Line(i int) string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	lines := x.block(i / indexBlockLines)
+	if k := i % indexBlockLines; k < len(lines) {
+		return lines[k]
+	}
+	return ""
+}
+
+/*
+block decodes the lines of one block, from its recorded offset to the next one
+or to the end of what has been scanned. Blocks still being scanned may end in a
+partial line, so they are not cached.
+*/
+func (x *lineIndex) block(b int) []string {
+	for _, cached := range x.cache {
+		if cached.block == b {
+			return cached.lines
+		}
+	}
+	if b >= len(x.blocks) {
+		return nil
+	}
+
+	start, end := x.blocks[b], x.scanned
+	complete := x.finished
+	if b+1 < len(x.blocks) {
+		end = x.blocks[b+1]
+		complete = true
+	}
+	data := make([]byte, end-start)
+	n, _ := x.file.ReadAt(data, start)
+	data = data[:n]
+	if start == 0 && x.layout.bom {
+		data = bytes.TrimPrefix(data, utf8BOM)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if x.layout.format == FormatDOS {
+		for i, line := range lines {
+			lines[i] = strings.TrimSuffix(line, "\r")
+		}
+	}
+
+	if complete {
+		if len(x.cache) == indexCacheBlocks {
+			x.cache = x.cache[1:]
+		}
+		x.cache = append(x.cache, indexBlock{block: b, lines: lines})
+	}
+	return lines
+}
+
+/*
+Lines returns count lines starting at from, for materializing part of the file
+that is about to be edited.
+*/
+func (x *lineIndex) Lines(from, count int) []string {
+	lines := make([]string, count)
+	for i := range lines {
+		lines[i] = x.Line(from + i)
+	}
+	return lines
+}
+
+func (x *lineIndex) Done() bool {
+	select {
+	case <-x.done:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+Progress reports the fraction of the file scanned, between 0 and 1.
+*/
+func (x *lineIndex) Progress() float64 {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if x.size == 0 {
+		return 1
+	}
+	return float64(x.scanned) / float64(x.size)
+}
+
+/*
+Close releases the file. A scan still running fails on its next read and stops.
+*/
+func (x *lineIndex) Close() {
+	x.file.Close()
+}
+
+/*
+parseSize reads a byte count with an optional K, M or G suffix, as accepted by
+":set largefile".
+*/
+func parseSize(s string) (int64, error) {
+	digits := strings.TrimSpace(s)
+	shift := 0
+	if digits != "" {
+		switch digits[len(digits)-1] {
+		case 'k', 'K':
+			shift = 10
+		case 'm', 'M':
+			shift = 20
+		case 'g', 'G':
+			shift = 30
+		}
+	}
+	if shift > 0 {
+		digits = digits[:len(digits)-1]
+	}
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("E521: Number required after =: %s", s)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,55 @@
+package editor
+
+import (
+	"fmt"
+)
+
+/*
+MessageLevel ranks a message shown in the message area so the UI can color it.
+*/
+type MessageLevel int
+
+const (
+	MessageInfo MessageLevel = iota
+	MessageWarning
+	MessageError
+)
+
+/*
+Message is the one-line feedback shown beneath the status line: the outcome of
+the last command, a file summary after loading or saving, or an error. It stays
+until replaced or cleared by the next command line.
+*/
+type Message struct {
+	Text  string
+	Level MessageLevel
+}
+
+func (m Message) IsEmpty() bool {
+	return m.Text == ""
+}
+
+/*
+fileSummary describes a file the way the message area reports reads and writes,
+for example `"main.go" 42 lines, 1.2KB`.
+*/
+func fileSummary(name string, lines, size int) string {
+	noun := "lines"
+	if lines == 1 {
+		noun = "line"
+	}
+	return fmt.Sprintf("%q %d %s, %s", name, lines, noun, formatSize(size))
+}
+
+func formatSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1fKB", float64(n)/1024)
+	case n < 1024*1024*1024:
+		return fmt.Sprintf("%.1fMB", float64(n)/(1024*1024))
+	default:
+		return fmt.Sprintf("%.1fGB", float64(n)/(1024*1024*1024))
+	}
+}
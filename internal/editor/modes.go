@@ -12,6 +12,9 @@ const (
 	ModeInsert
 	ModeVisual
 	ModeCommand
+	ModeUndoTree
+	ModeHex
+	ModeConfirm
 )
 
 func (m Mode) String() string {
@@ -24,7 +27,48 @@ func (m Mode) String() string {
 		return "VISUAL"
 	case ModeCommand:
 		return "COMMAND"
+	case ModeUndoTree:
+		return "UNDO"
+	case ModeHex:
+		return "HEX"
+	case ModeConfirm:
+		return "CONFIRM"
 	default:
 		return "UNKNOWN"
 	}
+}
+
+/*
+Prompt is what the command line is read for: an ex command after ":" or a
+regex for one of the selection commands.
+*/
+type Prompt int
+
+const (
+	PromptCommand Prompt = iota
+	PromptSelect
+	PromptSplit
+	PromptKeep
+	PromptDrop
+	PromptSearchForward
+	PromptSearchBackward
+)
+
+func (p Prompt) String() string {
+	switch p {
+	case PromptSelect:
+		return "select:"
+	case PromptSplit:
+		return "split:"
+	case PromptKeep:
+		return "keep:"
+	case PromptDrop:
+		return "drop:"
+	case PromptSearchForward:
+		return "/"
+	case PromptSearchBackward:
+		return "?"
+	default:
+		return ":"
+	}
 }
\ No newline at end of file
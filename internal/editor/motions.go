@@ -0,0 +1,462 @@
+package editor
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+motion computes where the cursor goes from pos. Motions only compute positions,
+so move can apply them repeatedly for a count and visual mode can extend the
+selection to wherever they end.
+*/
+type motion func(pos Position) Position
+
+/*
+move applies m count times, stopping early once it no longer gets anywhere, and
+places the cursor on the result, extending the selection in visual mode.
+*/
+func (e *Editor) move(count int, m motion) {
+	e.each(func() {
+		pos := e.cursor
+		for range max(count, 1) {
+			next := m(pos)
+			if next == pos {
+				break
+			}
+			pos = next
+		}
+		e.MoveCursorTo(pos)
+	})
+}
+
+/*
+Character classes for word motions. A word is a run of letters, digits and
+underscores or a run of other non-blank characters; a WORD is any run of
+non-blank characters.
+*/
+const (
+	classBlank = iota
+	classWord
+	classPunct
+)
+
+func charClass(line string, col int, big bool) int {
+	if col >= len(line) {
+		return classBlank
+	}
+	r, _ := utf8.DecodeRuneInString(line[col:])
+	switch {
+	case unicode.IsSpace(r):
+		return classBlank
+	case big || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return classWord
+	}
+	return classPunct
+}
+
+/*
+nextPosition steps to the next grapheme, continuing at the start of the next
+line. An empty line is a position of its own. Returns false at the end of the
+buffer.
+*/
+func (b *Buffer) nextPosition(pos Position) (Position, bool) {
+	line := b.GetLine(pos.Line)
+	if col := nextGrapheme(line, pos.Col); col < len(line) {
+		return Position{Line: pos.Line, Col: col}, true
+	}
+	if pos.Line+1 < b.LineCount() {
+		return Position{Line: pos.Line + 1}, true
+	}
+	return pos, false
+}
+
+func (b *Buffer) prevPosition(pos Position) (Position, bool) {
+	if pos.Col > 0 {
+		return Position{Line: pos.Line, Col: prevGrapheme(b.GetLine(pos.Line), pos.Col)}, true
+	}
+	if pos.Line > 0 {
+		line := b.GetLine(pos.Line - 1)
+		return Position{Line: pos.Line - 1, Col: prevGrapheme(line, len(line))}, true
+	}
+	return pos, false
+}
+
+func (b *Buffer) classAt(pos Position, big bool) int {
+	return charClass(b.GetLine(pos.Line), pos.Col, big)
+}
+
+func (b *Buffer) emptyLine(line int) bool {
+	return b.GetLine(line) == ""
+}
+
+/*
+wordStart finds the start of the next word, like vim's w: it leaves the current
+word and skips blanks, but stops on an empty line.
+*/
+func (b *Buffer) wordStart(pos Position, big bool) Position {
+	class := b.classAt(pos, big)
+	for {
+		next, ok := b.nextPosition(pos)
+		if !ok {
+			return pos
+		}
+		crossed := next.Line != pos.Line
+		pos = next
+		if crossed || b.classAt(pos, big) != class {
+			break
+		}
+	}
+	for b.classAt(pos, big) == classBlank && !b.emptyLine(pos.Line) {
+		next, ok := b.nextPosition(pos)
+		if !ok {
+			return pos
+		}
+		pos = next
+	}
+	return pos
+}
+
+/*
+wordEnd finds the last character of the current or next word, like vim's e.
+*/
+func (b *Buffer) wordEnd(pos Position, big bool) Position {
+	pos, ok := b.nextPosition(pos)
+	if !ok {
+		return pos
+	}
+	for b.classAt(pos, big) == classBlank {
+		if pos, ok = b.nextPosition(pos); !ok {
+			return pos
+		}
+	}
+	class := b.classAt(pos, big)
+	for {
+		next, ok := b.nextPosition(pos)
+		if !ok || next.Line != pos.Line || b.classAt(next, big) != class {
+			return pos
+		}
+		pos = next
+	}
+}
+
+/*
+wordBackward finds the start of the current or previous word, like vim's b.
+*/
+func (b *Buffer) wordBackward(pos Position, big bool) Position {
+	pos, ok := b.prevPosition(pos)
+	if !ok {
+		return pos
+	}
+	for b.classAt(pos, big) == classBlank && !b.emptyLine(pos.Line) {
+		if pos, ok = b.prevPosition(pos); !ok {
+			return pos
+		}
+	}
+	class := b.classAt(pos, big)
+	for {
+		prev, ok := b.prevPosition(pos)
+		if !ok || prev.Line != pos.Line || b.classAt(prev, big) != class {
+			return pos
+		}
+		pos = prev
+	}
+}
+
+/*
+wordEndBackward finds the last character of the previous word, like vim's ge.
+*/
+func (b *Buffer) wordEndBackward(pos Position, big bool) Position {
+	class := b.classAt(pos, big)
+	for {
+		prev, ok := b.prevPosition(pos)
+		if !ok {
+			return pos
+		}
+		crossed := prev.Line != pos.Line
+		pos = prev
+		if crossed || b.classAt(pos, big) != class {
+			break
+		}
+	}
+	for b.classAt(pos, big) == classBlank && !b.emptyLine(pos.Line) {
+		prev, ok := b.prevPosition(pos)
+		if !ok {
+			return pos
+		}
+		pos = prev
+	}
+	return pos
+}
+
+/*
+MoveWordForward moves to the start of the count-th next word; MoveWordBackward,
+MoveWordEnd and MoveWordEndBackward are vim's b, e and ge. The big variants move
+by WORDs, which only whitespace separates.
+*/
+func (e *Editor) MoveWordForward(count int, big bool) {
+	e.move(count, func(pos Position) Position { return e.buffer.wordStart(pos, big) })
+}
+
+func (e *Editor) MoveWordBackward(count int, big bool) {
+	e.move(count, func(pos Position) Position { return e.buffer.wordBackward(pos, big) })
+}
+
+func (e *Editor) MoveWordEnd(count int, big bool) {
+	e.move(count, func(pos Position) Position { return e.buffer.wordEnd(pos, big) })
+}
+
+func (e *Editor) MoveWordEndBackward(count int, big bool) {
+	e.move(count, func(pos Position) Position { return e.buffer.wordEndBackward(pos, big) })
+}
+
+func firstNonBlank(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+/*
+MoveToFirstNonBlank moves to the line's first character that is not
+indentation, like vim's ^.
+*/
+func (e *Editor) MoveToFirstNonBlank() {
+	e.each(func() {
+		e.MoveCursorTo(Position{Line: e.cursor.Line, Col: firstNonBlank(e.buffer.GetLine(e.cursor.Line))})
+	})
+}
+
+/*
+MoveToLine moves to the first non-blank of a line given by its 1-based number,
+clamped to the buffer, as gg and G do with a count.
+*/
+func (e *Editor) MoveToLine(number int) {
+	line := min(max(number, 1), e.buffer.LineCount()) - 1
+	e.MoveCursorTo(Position{Line: line, Col: firstNonBlank(e.buffer.GetLine(line))})
+}
+
+/*
+MoveToPercent moves to the line count percent of the way through the buffer,
+like vim's N%.
+*/
+func (e *Editor) MoveToPercent(count int) {
+	e.MoveToLine((min(count, 100)*e.buffer.LineCount() + 99) / 100)
+}
+
+/*
+paragraphForward moves to the next empty line past the current paragraph, or
+to the end of the buffer, like vim's }.
+*/
+func (b *Buffer) paragraphForward(pos Position) Position {
+	line := pos.Line
+	for line < b.LineCount() && b.emptyLine(line) {
+		line++
+	}
+	for line < b.LineCount() && !b.emptyLine(line) {
+		line++
+	}
+	if line == b.LineCount() {
+		last := b.LineCount() - 1
+		return Position{Line: last, Col: len(b.GetLine(last))}
+	}
+	return Position{Line: line}
+}
+
+func (b *Buffer) paragraphBackward(pos Position) Position {
+	line := pos.Line
+	for line >= 0 && b.emptyLine(line) {
+		line--
+	}
+	for line >= 0 && !b.emptyLine(line) {
+		line--
+	}
+	return Position{Line: max(line, 0)}
+}
+
+func (e *Editor) MoveParagraphForward(count int) {
+	e.move(count, e.buffer.paragraphForward)
+}
+
+func (e *Editor) MoveParagraphBackward(count int) {
+	e.move(count, e.buffer.paragraphBackward)
+}
+
+var bracketPairs = map[byte]byte{'(': ')', '[': ']', '{': '}', ')': '(', ']': '[', '}': '{'}
+
+/*
+matchBracket finds the partner of the first bracket at or after the cursor on
+its line, counting nested pairs across lines, like vim's %. Brackets are ASCII,
+so scanning bytes cannot mistake part of a multibyte character for one.
+*/
+func (b *Buffer) matchBracket(pos Position) Position {
+	text := b.GetLine(pos.Line)
+	col := pos.Col
+	for col < len(text) && bracketPairs[text[col]] == 0 {
+		col++
+	}
+	if col == len(text) {
+		return pos
+	}
+	open := text[col]
+	partner := bracketPairs[open]
+	step := 1
+	if open == ')' || open == ']' || open == '}' {
+		step = -1
+	}
+
+	depth := 0
+	for line := pos.Line; line >= 0 && line < b.LineCount(); line += step {
+		text = b.GetLine(line)
+		if line != pos.Line {
+			col = 0
+			if step < 0 {
+				col = len(text) - 1
+			}
+		}
+		for ; col >= 0 && col < len(text); col += step {
+			switch text[col] {
+			case open:
+				depth++
+			case partner:
+				depth--
+				if depth == 0 {
+					return Position{Line: line, Col: col}
+				}
+			}
+		}
+	}
+	return pos
+}
+
+func (e *Editor) MoveToMatchingBracket() {
+	e.move(1, e.buffer.matchBracket)
+}
+
+/*
+findState is the last f, t, F or T search, which ; and , repeat.
+*/
+type findState struct {
+	target  string
+	forward bool
+	till    bool
+}
+
+/*
+find locates the count-th occurrence of the target on pos's line. Till stops one
+character short of it. A repeated till search skips an occurrence right next to
+the cursor, which it would otherwise find again without moving.
+*/
+func (b *Buffer) find(pos Position, f findState, count int, repeat bool) Position {
+	line := b.GetLine(pos.Line)
+	found := pos.Col
+	if f.forward {
+		from := nextGrapheme(line, pos.Col)
+		if f.till && repeat {
+			from = nextGrapheme(line, from)
+		}
+		for range count {
+			i := strings.Index(line[min(from, len(line)):], f.target)
+			if i < 0 {
+				return pos
+			}
+			found = from + i
+			from = found + len(f.target)
+		}
+		if f.till {
+			found = prevGrapheme(line, found)
+		}
+	} else {
+		end := pos.Col
+		if f.till && repeat {
+			end = prevGrapheme(line, end)
+		}
+		for range count {
+			i := strings.LastIndex(line[:end], f.target)
+			if i < 0 {
+				return pos
+			}
+			found, end = i, i
+		}
+		if f.till {
+			found += len(f.target)
+		}
+	}
+	return Position{Line: pos.Line, Col: found}
+}
+
+/*
+FindChar implements f, t, F and T, moving along the line to the count-th
+occurrence of target, and remembers the search for RepeatFind.
+*/
+func (e *Editor) FindChar(target string, forward, till bool, count int) {
+	if target == "" {
+		return
+	}
+	e.lastFind = &findState{target: target, forward: forward, till: till}
+	f := *e.lastFind
+	e.move(1, func(pos Position) Position { return e.buffer.find(pos, f, max(count, 1), false) })
+}
+
+/*
+RepeatFind repeats the last FindChar, in the opposite direction when reverse is
+set, as ; and , do.
+*/
+func (e *Editor) RepeatFind(count int, reverse bool) {
+	if e.lastFind == nil {
+		return
+	}
+	f := *e.lastFind
+	if reverse {
+		f.forward = !f.forward
+	}
+	e.move(1, func(pos Position) Position { return e.buffer.find(pos, f, max(count, 1), true) })
+}
+
+/*
+SetViewport tells the editor which lines are on screen, for the motions that
+are relative to it.
+*/
+func (e *Editor) SetViewport(top, height int) {
+	e.viewTop = top
+	e.viewHeight = max(height, 1)
+}
+
+func (e *Editor) GetViewport() (top, height int) {
+	return e.viewTop, e.viewHeight
+}
+
+/*
+MoveToScreenTop, MoveToScreenMiddle and MoveToScreenBottom are vim's H, M and
+L. A count for H and L counts lines from the edge of the screen.
+*/
+func (e *Editor) MoveToScreenTop(count int) {
+	e.MoveToLine(e.viewTop + max(count, 1))
+}
+
+func (e *Editor) MoveToScreenMiddle() {
+	visible := min(e.viewHeight, e.buffer.LineCount()-e.viewTop)
+	e.MoveToLine(e.viewTop + (visible+1)/2)
+}
+
+func (e *Editor) MoveToScreenBottom(count int) {
+	bottom := min(e.viewTop+e.viewHeight, e.buffer.LineCount())
+	e.MoveToLine(max(bottom-max(count, 1)+1, e.viewTop+1))
+}
+
+/*
+ScrollHalfPage scrolls the view and the cursor by half a screen, down for a
+positive direction and up for a negative one, like ctrl+d and ctrl+u. A count
+sets how many lines to scroll instead, for this and later scrolls, as vim's
+'scroll' option does; 0 keeps the size in use.
+*/
+func (e *Editor) ScrollHalfPage(direction, count int) {
+	if count > 0 {
+		e.scroll = count
+	}
+	lines := max(e.viewHeight/2, 1)
+	if e.scroll > 0 {
+		lines = e.scroll
+	}
+	lines *= direction
+	e.viewTop = min(max(e.viewTop+lines, 0), max(e.buffer.LineCount()-e.viewHeight, 0))
+	e.MoveCursor(lines, 0)
+}
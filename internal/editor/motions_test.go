@@ -0,0 +1,127 @@
+package editor
+
+import (
+	"testing"
+)
+
+func TestMotions(t *testing.T) {
+	text := []string{
+		"foo.bar baz",    // 0
+		"  indented(x)",  // 1
+		"",               // 2
+		"para two",       // 3
+		"still two",      // 4
+		"",               // 5
+		"",               // 6
+		"f(a[b]{c}) end", // 7
+	}
+	tests := []struct {
+		name  string
+		start Position
+		keys  string
+		want  Position
+		fails bool
+	}{
+		{name: "w stops at punctuation", keys: "w", want: Position{Col: 3}},
+		{name: "W skips punctuation", keys: "W", want: Position{Col: 8}},
+		{name: "w counts", keys: "3w", want: Position{Col: 8}},
+		{name: "w crosses lines to the first word", start: Position{Col: 8}, keys: "w", want: Position{Line: 1, Col: 2}},
+		{name: "w stops at an empty line", start: Position{Line: 1, Col: 12}, keys: "w", want: Position{Line: 2}},
+		{name: "b", start: Position{Col: 8}, keys: "b", want: Position{Col: 4}},
+		{name: "B", start: Position{Col: 8}, keys: "B", want: Position{Col: 0}},
+		{name: "b crosses lines", start: Position{Line: 1, Col: 2}, keys: "b", want: Position{Col: 8}},
+		{name: "e", keys: "e", want: Position{Col: 2}},
+		{name: "e from a word end", start: Position{Col: 2}, keys: "e", want: Position{Col: 3}},
+		{name: "E", keys: "E", want: Position{Col: 6}},
+		{name: "ge", start: Position{Col: 8}, keys: "ge", want: Position{Col: 6}},
+		{name: "gE", start: Position{Line: 1, Col: 2}, keys: "gE", want: Position{Col: 10}},
+		{name: "w in the last word moves to its end", start: Position{Line: 7, Col: 11}, keys: "w", want: Position{Line: 7, Col: 13}},
+		{name: "w at the end of the buffer fails", start: Position{Line: 7, Col: 13}, keys: "w", want: Position{Line: 7, Col: 13}, fails: true},
+		{name: "b at the start fails", keys: "b", fails: true},
+
+		{name: "^", start: Position{Line: 1, Col: 9}, keys: "^", want: Position{Line: 1, Col: 2}},
+		{name: "0", start: Position{Line: 1, Col: 9}, keys: "0", want: Position{Line: 1}},
+		{name: "$", keys: "$", want: Position{Col: 10}},
+		{name: "gg", start: Position{Line: 4, Col: 3}, keys: "gg", want: Position{}},
+		{name: "gg with a count", keys: "4gg", want: Position{Line: 3}},
+		{name: "G", keys: "G", want: Position{Line: 7}},
+		{name: "G with a count", keys: "2G", want: Position{Line: 1, Col: 2}},
+		{name: "G past the end", keys: "99G", want: Position{Line: 7}},
+		{name: "percent of the file", keys: "50%", want: Position{Line: 3}},
+
+		{name: "} to the next blank line", keys: "}", want: Position{Line: 2}},
+		{name: "} with a count", keys: "2}", want: Position{Line: 5}},
+		{name: "} skips runs of blank lines", start: Position{Line: 3}, keys: "2}", want: Position{Line: 7, Col: 13}},
+		{name: "{ back to the blank line", start: Position{Line: 4, Col: 2}, keys: "{", want: Position{Line: 2}},
+		{name: "{ at the top fails", keys: "{", fails: true},
+
+		{name: "% to the closing bracket", start: Position{Line: 7, Col: 1}, keys: "%", want: Position{Line: 7, Col: 9}},
+		{name: "% back to the opening bracket", start: Position{Line: 7, Col: 9}, keys: "%", want: Position{Line: 7, Col: 1}},
+		{name: "% on nested brackets", start: Position{Line: 7, Col: 3}, keys: "%", want: Position{Line: 7, Col: 5}},
+		{name: "% finds the next bracket on the line", start: Position{Line: 7}, keys: "%", want: Position{Line: 7, Col: 9}},
+		{name: "% without a bracket stays", start: Position{Line: 3}, keys: "%", want: Position{Line: 3}},
+
+		{name: "f", keys: "fa", want: Position{Col: 5}},
+		{name: "t", keys: "ta", want: Position{Col: 4}},
+		{name: "f with a count", keys: "2fa", want: Position{Col: 9}},
+		{name: "F", start: Position{Col: 10}, keys: "Fb", want: Position{Col: 8}},
+		{name: "T", start: Position{Col: 10}, keys: "Tb", want: Position{Col: 9}},
+		{name: "; repeats", keys: "fa;", want: Position{Col: 9}},
+		{name: ", reverses", keys: "fa;,", want: Position{Col: 5}},
+		{name: "; after t moves past the adjacent match", keys: "tb;", want: Position{Col: 7}},
+		{name: "f stays on its line", keys: "fx", fails: true},
+		{name: "; without a find fails", keys: ";", fails: true},
+
+		{name: "j keeps the column", start: Position{Line: 3, Col: 7}, keys: "j", want: Position{Line: 4, Col: 7}},
+		{name: "j past the end fails", start: Position{Line: 7}, keys: "j", want: Position{Line: 7}, fails: true},
+		{name: "H", start: Position{Line: 7}, keys: "H", want: Position{Line: 2}},
+		{name: "H with a count", start: Position{Line: 7}, keys: "2H", want: Position{Line: 3}},
+		{name: "M", keys: "M", want: Position{Line: 4}},
+		{name: "L", keys: "L", want: Position{Line: 6}},
+		{name: "L with a count", keys: "3L", want: Position{Line: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(text...)
+			e.SetViewport(2, 5)
+			e.cursor = tt.start
+			e.selection = NewSelection(tt.start)
+
+			fed, err := e.FeedKeys(tt.keys)
+			if fails := err != nil; fails != tt.fails {
+				t.Errorf("FeedKeys(%q) = %v, %v; want failure %v", tt.keys, fed, err, tt.fails)
+			}
+			if e.cursor != tt.want {
+				t.Errorf("cursor %+v, want %+v", e.cursor, tt.want)
+			}
+		})
+	}
+}
+
+func TestMotionsExtendInVisualMode(t *testing.T) {
+	tests := []struct {
+		keys       string
+		anchor     Position
+		head       Position
+		wantVisual bool
+	}{
+		{keys: "vw", anchor: Position{}, head: Position{Col: 4}, wantVisual: true},
+		{keys: "vj", anchor: Position{}, head: Position{Line: 1}, wantVisual: true},
+		{keys: "v$", anchor: Position{}, head: Position{Col: 8}, wantVisual: true},
+		{keys: "vG", anchor: Position{}, head: Position{Line: 1}, wantVisual: true},
+		{keys: "vft", anchor: Position{}, head: Position{Col: 4}, wantVisual: true},
+		{keys: "w", anchor: Position{Col: 4}, head: Position{Col: 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.keys, func(t *testing.T) {
+			e := newTestEditor("one two.", "three")
+			feed(t, e, tt.keys)
+			if got := e.GetMode() == ModeVisual; got != tt.wantVisual {
+				t.Errorf("visual mode = %v, want %v", got, tt.wantVisual)
+			}
+			if e.selection.Anchor != tt.anchor || e.selection.Head != tt.head {
+				t.Errorf("selection %+v-%+v, want %+v-%+v", e.selection.Anchor, e.selection.Head, tt.anchor, tt.head)
+			}
+		})
+	}
+}
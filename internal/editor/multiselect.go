@@ -0,0 +1,264 @@
+package editor
+
+import (
+	"slices"
+	"sort"
+)
+
+/*
+GetSelections returns every selection in document order. With a single
+selection that is just the primary one, GetSelection.
+*/
+func (e *Editor) GetSelections() []Selection {
+	if len(e.selections) < 2 {
+		return []Selection{e.selection}
+	}
+	sels := slices.Clone(e.selections)
+	sels[e.primary] = e.selection
+	return sels
+}
+
+/*
+PrimarySelection is the index into GetSelections of the primary selection, the
+one the cursor, scrolling and status line follow.
+*/
+func (e *Editor) PrimarySelection() int {
+	if len(e.selections) < 2 {
+		return 0
+	}
+	return e.primary
+}
+
+/*
+each runs op once per selection with the cursor and selection set to it, so an
+operation written for one selection applies to all of them. Selections are
+visited from last to first and every buffer edit shifts the others, so text
+inserted or deleted for one never displaces another. All edits form a single
+undo step, and selections that end up overlapping are merged. Nested calls run
+op directly.
+*/
+func (e *Editor) each(op func()) {
+	if e.iterating || len(e.selections) < 2 {
+		op()
+		return
+	}
+	e.iterating = true
+	e.selections[e.primary] = e.selection
+	e.buffer.observer = func(ed edit) {
+		for i := range e.selections {
+			e.selections[i].Anchor = shiftPosition(e.selections[i].Anchor, ed)
+			e.selections[i].Head = shiftPosition(e.selections[i].Head, ed)
+		}
+	}
+
+	e.beginChange()
+	for i := len(e.selections) - 1; i >= 0; i-- {
+		e.selection = e.selections[i]
+		e.cursor = e.selection.Head
+		op()
+		if e.selection.Head != e.cursor {
+			e.selection = NewSelection(e.cursor)
+		}
+		e.selections[i] = e.selection
+	}
+
+	e.buffer.observer = nil
+	e.iterating = false
+	e.selection = e.selections[e.primary]
+	e.mergeSelections()
+	e.endChange()
+}
+
+/*
+shiftPosition maps a position through an edit, so it keeps pointing at the same
+text. Positions inside deleted text move to where it was; positions at an
+insertion point move past the inserted text.
+*/
+func shiftPosition(pos Position, ed edit) Position {
+	if ed.Deleted != "" {
+		end := textEnd(ed.Pos, ed.Deleted)
+		switch {
+		case !after(pos, ed.Pos):
+		case after(end, pos):
+			pos = ed.Pos
+		case pos.Line == end.Line:
+			pos = Position{Line: ed.Pos.Line, Col: ed.Pos.Col + pos.Col - end.Col}
+		default:
+			pos.Line -= end.Line - ed.Pos.Line
+		}
+	}
+	if ed.Inserted != "" && !after(ed.Pos, pos) {
+		end := textEnd(ed.Pos, ed.Inserted)
+		if pos.Line == ed.Pos.Line {
+			pos = Position{Line: end.Line, Col: end.Col + pos.Col - ed.Pos.Col}
+		} else {
+			pos.Line += end.Line - ed.Pos.Line
+		}
+	}
+	return pos
+}
+
+/*
+mergeSelections sorts the selections into document order and merges those that
+overlap or share a start, such as two cursors moved onto the same spot. The
+merged selection is primary if either part was.
+*/
+func (e *Editor) mergeSelections() {
+	if len(e.selections) < 2 {
+		return
+	}
+	e.selections[e.primary] = e.selection
+
+	type entry struct {
+		sel     Selection
+		primary bool
+	}
+	entries := make([]entry, len(e.selections))
+	for i, sel := range e.selections {
+		entries[i] = entry{sel, i == e.primary}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return after(entries[j].sel.Start(), entries[i].sel.Start())
+	})
+
+	var merged []entry
+	for _, en := range entries {
+		n := len(merged)
+		if n == 0 {
+			merged = append(merged, en)
+			continue
+		}
+		last := &merged[n-1]
+		if en.sel.Start() != last.sel.Start() && !after(last.sel.End(), en.sel.Start()) {
+			merged = append(merged, en)
+			continue
+		}
+		forward := last.sel.Anchor == last.sel.Start()
+		if last.sel.IsEmpty() {
+			forward = en.sel.Anchor == en.sel.Start()
+		}
+		start, end := last.sel.Start(), last.sel.End()
+		if after(en.sel.End(), end) {
+			end = en.sel.End()
+		}
+		last.sel = Selection{Anchor: start, Head: end}
+		if !forward {
+			last.sel = Selection{Anchor: end, Head: start}
+		}
+		last.primary = last.primary || en.primary
+	}
+
+	e.selections = e.selections[:0]
+	for i, en := range merged {
+		e.selections = append(e.selections, en.sel)
+		if en.primary {
+			e.primary = i
+		}
+	}
+	e.selection = e.selections[e.primary]
+	e.cursor = e.selection.Head
+	if len(e.selections) == 1 {
+		e.selections = nil
+		e.primary = 0
+	}
+}
+
+/*
+collapse drops every selection but the primary and empties it at the cursor,
+for changes like undo or reloading after which the others mean nothing.
+*/
+func (e *Editor) collapse() {
+	e.selections = nil
+	e.primary = 0
+	e.selection = NewSelection(e.cursor)
+}
+
+/*
+addSelection adds sel as the new primary selection.
+*/
+func (e *Editor) addSelection(sel Selection) {
+	if len(e.selections) < 2 {
+		e.selections = []Selection{e.selection}
+	}
+	e.selections[e.primary] = e.selection
+	e.selections = append(e.selections, sel)
+	e.primary = len(e.selections) - 1
+	e.selection = sel
+	e.cursor = sel.Head
+	e.mergeSelections()
+}
+
+/*
+KeepPrimarySelection drops all selections but the primary one.
+*/
+func (e *Editor) KeepPrimarySelection() {
+	e.selections = nil
+	e.primary = 0
+}
+
+/*
+RemovePrimarySelection drops the primary selection, making the next one primary.
+*/
+func (e *Editor) RemovePrimarySelection() {
+	if len(e.selections) < 2 {
+		return
+	}
+	e.selections = slices.Delete(e.selections, e.primary, e.primary+1)
+	e.primary %= len(e.selections)
+	e.selection = e.selections[e.primary]
+	e.cursor = e.selection.Head
+	if len(e.selections) == 1 {
+		e.selections = nil
+		e.primary = 0
+	}
+}
+
+/*
+CopySelectionBelow adds a copy of the primary selection on the next lines that
+are long enough to hold it, at the same screen columns, and makes the copy
+primary; a count adds that many copies.
+*/
+func (e *Editor) CopySelectionBelow(count int) {
+	for range max(count, 1) {
+		sel := e.selection
+		start, end := sel.Start(), sel.End()
+		anchorCell := DisplayColumn(e.buffer.GetLine(sel.Anchor.Line), sel.Anchor.Col)
+		headCell := DisplayColumn(e.buffer.GetLine(sel.Head.Line), sel.Head.Col)
+		height := end.Line - start.Line
+
+		copied := false
+		for line := end.Line + 1; line+height < e.buffer.LineCount(); line++ {
+			anchor, ok := e.cellPosition(line+sel.Anchor.Line-start.Line, anchorCell, sel.IsEmpty())
+			if !ok {
+				continue
+			}
+			head, ok := e.cellPosition(line+sel.Head.Line-start.Line, headCell, sel.IsEmpty())
+			if !ok {
+				continue
+			}
+			e.addSelection(Selection{Anchor: anchor, Head: head})
+			copied = true
+			break
+		}
+		if !copied {
+			return
+		}
+	}
+}
+
+/*
+cellPosition finds the position drawn at a screen cell of a line, if the line
+reaches that far. A cursor must rest on a character unless the line is empty.
+*/
+func (e *Editor) cellPosition(line, cell int, cursor bool) (Position, bool) {
+	text := e.buffer.GetLine(line)
+	width := DisplayColumn(text, len(text))
+	if cell > width || (cursor && cell == width && text != "") {
+		return Position{}, false
+	}
+	col := columnForDisplay(text, cell)
+	if DisplayColumn(text, col) != cell {
+		return Position{}, false
+	}
+	return Position{Line: line, Col: col}, true
+}
@@ -0,0 +1,207 @@
+package editor
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestMultipleSelections(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		keys    string
+		want    []string
+		cursors []Position // heads of the selections in document order
+		primary int
+	}{
+		{
+			name:    "C adds a cursor on the next line",
+			lines:   []string{"abc", "def"},
+			keys:    "lC",
+			want:    []string{"abc", "def"},
+			cursors: []Position{{Col: 1}, {Line: 1, Col: 1}},
+			primary: 1,
+		},
+		{
+			name:    "C skips lines too short",
+			lines:   []string{"abc", "d", "efg"},
+			keys:    "llC",
+			want:    []string{"abc", "d", "efg"},
+			cursors: []Position{{Col: 2}, {Line: 2, Col: 2}},
+			primary: 1,
+		},
+		{
+			name:    "insert at every cursor",
+			lines:   []string{"abc", "def", "ghi"},
+			keys:    "2Cix<Esc>",
+			want:    []string{"xabc", "xdef", "xghi"},
+			cursors: []Position{{}, {Line: 1}, {Line: 2}},
+			primary: 2,
+		},
+		{
+			name:    "line breaks shift the cursors below",
+			lines:   []string{"ab", "cd"},
+			keys:    "lCi<CR><Esc>",
+			want:    []string{"a", "b", "c", "d"},
+			cursors: []Position{{Line: 1}, {Line: 3}},
+			primary: 1,
+		},
+		{
+			name:    "several cursors on one line",
+			lines:   []string{"a b c"},
+			keys:    "xs\\w<CR><Esc>ix<Esc>",
+			want:    []string{"ax bx cx"},
+			cursors: []Position{{Col: 1}, {Col: 4}, {Col: 7}},
+			primary: 2,
+		},
+		{
+			name:    "delete in every selection",
+			lines:   []string{"one two", "one two"},
+			keys:    "Cvlld",
+			want:    []string{"e two", "e two"},
+			cursors: []Position{{}, {Line: 1}},
+			primary: 1,
+		},
+		{
+			name:    "each selection pastes its own yank",
+			lines:   []string{"ab", "cd"},
+			keys:    "Cvlyp",
+			want:    []string{"aab", "ccd"},
+			cursors: []Position{{Col: 2}, {Line: 1, Col: 2}},
+			primary: 1,
+		},
+		{
+			name:    "cursors moved onto one spot merge",
+			lines:   []string{"ab", "c"},
+			keys:    "Ck",
+			want:    []string{"ab", "c"},
+			cursors: []Position{{}},
+		},
+		{
+			name:    "selections grown into each other merge",
+			lines:   []string{"abcdef"},
+			keys:    "xs[ace]<CR>ll",
+			want:    []string{"abcdef"},
+			cursors: []Position{{Col: 6}},
+		},
+		{
+			name:    "deleting the selections leaves a cursor in each place",
+			lines:   []string{"a-b-c"},
+			keys:    "xs-<CR>d",
+			want:    []string{"abc"},
+			cursors: []Position{{Col: 1}, {Col: 2}},
+			primary: 1,
+		},
+		{
+			name:    "space keeps the primary selection",
+			lines:   []string{"a", "b", "c"},
+			keys:    "2C<space>",
+			want:    []string{"a", "b", "c"},
+			cursors: []Position{{Line: 2}},
+		},
+		{
+			name:    "alt+space drops the primary selection",
+			lines:   []string{"a", "b", "c"},
+			keys:    "2C<A-space>",
+			want:    []string{"a", "b", "c"},
+			cursors: []Position{{}, {Line: 1}},
+		},
+		{
+			name:    "undo restores the text and collapses the selections",
+			lines:   []string{"a", "b"},
+			keys:    "Cix<Esc>u",
+			want:    []string{"a", "b"},
+			cursors: []Position{{Line: 1}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			typeKeys(e, tt.keys)
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+			var heads []Position
+			for _, sel := range e.GetSelections() {
+				heads = append(heads, sel.Head)
+			}
+			if !slices.Equal(heads, tt.cursors) {
+				t.Errorf("selection heads %+v, want %+v", heads, tt.cursors)
+			}
+			if e.PrimarySelection() != tt.primary {
+				t.Errorf("primary selection %d, want %d", e.PrimarySelection(), tt.primary)
+			}
+		})
+	}
+}
+
+func TestMergeSelections(t *testing.T) {
+	sel := func(anchorCol, headCol int) Selection {
+		return Selection{Anchor: Position{Col: anchorCol}, Head: Position{Col: headCol}}
+	}
+	tests := []struct {
+		name    string
+		sels    []Selection
+		primary int
+		want    []Selection
+		wantPri int
+	}{
+		{
+			name:    "apart stay apart, sorted",
+			sels:    []Selection{sel(4, 6), sel(0, 2)},
+			want:    []Selection{sel(0, 2), sel(4, 6)},
+			wantPri: 1,
+		},
+		{
+			name:    "touching stay apart",
+			sels:    []Selection{sel(0, 2), sel(2, 4)},
+			primary: 1,
+			want:    []Selection{sel(0, 2), sel(2, 4)},
+			wantPri: 1,
+		},
+		{
+			name:    "overlapping merge and keep the primary",
+			sels:    []Selection{sel(0, 3), sel(2, 5), sel(7, 8)},
+			primary: 1,
+			want:    []Selection{sel(0, 5), sel(7, 8)},
+		},
+		{
+			name: "contained",
+			sels: []Selection{sel(0, 6), sel(2, 3)},
+			want: []Selection{sel(0, 6)},
+		},
+		{
+			name: "backward selections stay backward",
+			sels: []Selection{sel(3, 0), sel(5, 2)},
+			want: []Selection{sel(5, 0)},
+		},
+		{
+			name: "cursors on one spot",
+			sels: []Selection{sel(2, 2), sel(2, 2)},
+			want: []Selection{sel(2, 2)},
+		},
+		{
+			name: "a cursor at the start of a selection",
+			sels: []Selection{sel(2, 2), sel(2, 4)},
+			want: []Selection{sel(2, 4)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor("0123456789")
+			e.selections = slices.Clone(tt.sels)
+			e.primary = tt.primary
+			e.selection = tt.sels[tt.primary]
+			e.mergeSelections()
+			if got := e.GetSelections(); !slices.Equal(got, tt.want) {
+				t.Errorf("merged %+v, want %+v", got, tt.want)
+			}
+			if e.PrimarySelection() != tt.wantPri {
+				t.Errorf("primary %d, want %d", e.PrimarySelection(), tt.wantPri)
+			}
+			if e.cursor != e.selection.Head {
+				t.Errorf("cursor %+v off the primary selection %+v", e.cursor, e.selection)
+			}
+		})
+	}
+}
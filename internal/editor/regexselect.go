@@ -0,0 +1,214 @@
+package editor
+
+import (
+	"regexp"
+)
+
+/*
+OpenPrompt starts reading a command line for p. Unlike entering command mode
+through SetMode it keeps the selections, which the selection commands act on
+once the regex is entered.
+*/
+func (e *Editor) OpenPrompt(p Prompt) {
+	e.prompt = p
+	e.promptReturn = e.mode
+	e.mode = ModeCommand
+	e.command = ""
+	e.searchOrigin = e.cursor
+	e.historyIndex = len(e.searchHistory)
+}
+
+func (e *Editor) GetPrompt() Prompt {
+	return e.prompt
+}
+
+/*
+ClosePrompt abandons a prompt opened by OpenPrompt, returning to the mode it was
+opened from with the cursor and selections as they were.
+*/
+func (e *Editor) ClosePrompt() {
+	if e.prompt == PromptSearchForward || e.prompt == PromptSearchBackward {
+		e.cursor = e.searchOrigin
+	}
+	e.prompt = PromptCommand
+	e.mode = e.promptReturn
+	e.command = ""
+}
+
+/*
+runPrompt applies the regex typed at a selection or search prompt. An empty
+regex does nothing, except that searching for it repeats the last search.
+*/
+func (e *Editor) runPrompt() {
+	p, pattern := e.prompt, e.command
+	e.ClosePrompt()
+	if p == PromptSearchForward || p == PromptSearchBackward {
+		e.search(pattern, p == PromptSearchForward)
+		return
+	}
+	if pattern == "" {
+		return
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		e.SetMessage(MessageError, "E383: Invalid search string: "+pattern)
+		return
+	}
+
+	switch p {
+	case PromptSelect:
+		e.SelectMatches(re)
+	case PromptSplit:
+		e.SplitSelections(re)
+	case PromptKeep:
+		e.KeepMatching(re, true)
+	case PromptDrop:
+		e.KeepMatching(re, false)
+	}
+}
+
+/*
+SelectMatches replaces each selection with the matches of re inside it, like
+kakoune's s.
+*/
+func (e *Editor) SelectMatches(re *regexp.Regexp) {
+	ok := e.reshape(func(sel Selection) []Selection {
+		var sels []Selection
+		for _, m := range e.buffer.matches(sel, re) {
+			if m.Start() != m.End() {
+				sels = append(sels, m)
+			}
+		}
+		return sels
+	})
+	if !ok {
+		e.SetMessage(MessageError, "E486: Pattern not found: "+re.String())
+	}
+}
+
+/*
+SplitSelections splits each selection on the matches of re, keeping the text
+between them, like kakoune's S.
+*/
+func (e *Editor) SplitSelections(re *regexp.Regexp) {
+	e.reshape(func(sel Selection) []Selection {
+		var sels []Selection
+		start := sel.Start()
+		for _, m := range e.buffer.matches(sel, re) {
+			if after(m.Start(), start) {
+				sels = append(sels, Selection{Anchor: start, Head: m.Start()})
+			}
+			start = m.End()
+		}
+		if after(sel.End(), start) {
+			sels = append(sels, Selection{Anchor: start, Head: sel.End()})
+		}
+		return sels
+	})
+}
+
+/*
+SplitLines splits each selection at line boundaries, leaving one selection per
+line it covers without the line breaks, like kakoune's alt+s.
+*/
+func (e *Editor) SplitLines() {
+	e.reshape(func(sel Selection) []Selection {
+		start, end := sel.Start(), sel.End()
+		var sels []Selection
+		for line := start.Line; line <= end.Line; line++ {
+			from := Position{Line: line}
+			to := Position{Line: line, Col: len(e.buffer.GetLine(line))}
+			if line == start.Line {
+				from = start
+			}
+			if line == end.Line {
+				to = end
+			}
+			if after(to, from) {
+				sels = append(sels, Selection{Anchor: from, Head: to})
+			}
+		}
+		return sels
+	})
+}
+
+/*
+KeepMatching keeps the selections whose text matches re, or with keep false
+those whose text does not, like kakoune's K and alt+k. At least one selection
+always remains.
+*/
+func (e *Editor) KeepMatching(re *regexp.Regexp, keep bool) {
+	ok := e.reshape(func(sel Selection) []Selection {
+		if re.MatchString(e.buffer.GetSelectedText(sel)) != keep {
+			return nil
+		}
+		return []Selection{sel}
+	})
+	if !ok {
+		e.SetMessage(MessageError, "E486: No selections remaining: "+re.String())
+	}
+}
+
+/*
+RotatePrimary makes the selection count places after the primary one primary,
+wrapping around; a negative count goes backwards.
+*/
+func (e *Editor) RotatePrimary(count int) {
+	n := len(e.selections)
+	if n < 2 {
+		return
+	}
+	e.selections[e.primary] = e.selection
+	e.primary = ((e.primary+count)%n + n) % n
+	e.selection = e.selections[e.primary]
+	e.cursor = e.selection.Head
+}
+
+/*
+reshape replaces every selection with the ones fn derives from it. The last
+selection derived from the primary one becomes primary. It reports false, and
+changes nothing, when no selections would remain.
+*/
+func (e *Editor) reshape(fn func(sel Selection) []Selection) bool {
+	primary := e.PrimarySelection()
+	var sels []Selection
+	newPrimary := 0
+	for i, sel := range e.GetSelections() {
+		sels = append(sels, fn(sel)...)
+		if i == primary {
+			newPrimary = max(len(sels)-1, 0)
+		}
+	}
+	if len(sels) == 0 {
+		return false
+	}
+
+	e.selection = sels[newPrimary]
+	e.cursor = e.selection.Head
+	e.selections, e.primary = nil, 0
+	if len(sels) > 1 {
+		e.selections, e.primary = sels, newPrimary
+		e.mergeSelections()
+	}
+	if !e.selection.IsEmpty() {
+		e.mode = ModeVisual
+	}
+	return true
+}
+
+/*
+matches finds the matches of re in the text of sel, as selections from the
+start of each match to its end.
+*/
+func (b *Buffer) matches(sel Selection, re *regexp.Regexp) []Selection {
+	start := sel.Start()
+	text := b.GetSelectedText(sel)
+	var sels []Selection
+	for _, loc := range re.FindAllStringIndex(text, -1) {
+		sels = append(sels, Selection{
+			Anchor: textEnd(start, text[:loc[0]]),
+			Head:   textEnd(start, text[:loc[1]]),
+		})
+	}
+	return sels
+}
@@ -0,0 +1,162 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRegexSelect(t *testing.T) {
+	tests := []struct {
+		name    string
+		lines   []string
+		keys    string
+		want    []string // the text of each selection in document order
+		primary int
+		msg     string
+	}{
+		{
+			name:    "s selects every match",
+			lines:   []string{"foo bar foo"},
+			keys:    "xsfoo<CR>",
+			want:    []string{"foo", "foo"},
+			primary: 1,
+		},
+		{
+			name:    "s across lines",
+			lines:   []string{"ab", "cd"},
+			keys:    "vj$s\\w<CR>",
+			want:    []string{"a", "b", "c", "d"},
+			primary: 3,
+		},
+		{
+			name:  "s drops empty matches",
+			lines: []string{"baab"},
+			keys:  "xsa*<CR>",
+			want:  []string{"aa"},
+		},
+		{
+			name:  "s with only empty matches",
+			lines: []string{"abc"},
+			keys:  "xs^<CR>",
+			want:  []string{"abc"},
+			msg:   "E486: Pattern not found: ^",
+		},
+		{
+			name:  "s without a match",
+			lines: []string{"abc"},
+			keys:  "xsz<CR>",
+			want:  []string{"abc"},
+			msg:   "E486",
+		},
+		{
+			name:  "s with an invalid regex",
+			lines: []string{"abc"},
+			keys:  "xs(<CR>",
+			want:  []string{"abc"},
+			msg:   "E383",
+		},
+		{
+			name:    "S splits on matches",
+			lines:   []string{"a,b,,c"},
+			keys:    "xS,<CR>",
+			want:    []string{"a", "b", "c"},
+			primary: 2,
+		},
+		{
+			name:    "S on empty matches splits between characters",
+			lines:   []string{"abc"},
+			keys:    "xSx*<CR>",
+			want:    []string{"a", "b", "c"},
+			primary: 2,
+		},
+		{
+			name:    "S on a match at either end",
+			lines:   []string{" a b "},
+			keys:    "xS <CR>",
+			want:    []string{"a", "b"},
+			primary: 1,
+		},
+		{
+			name:  "S leaving nothing keeps the selection",
+			lines: []string{"abc"},
+			keys:  "xS.*<CR>",
+			want:  []string{"abc"},
+		},
+		{
+			name:    "alt+s splits on lines",
+			lines:   []string{"ab", "", "cd"},
+			keys:    "vjj$<A-s>",
+			want:    []string{"ab", "cd"},
+			primary: 1,
+		},
+		{
+			name:  "K keeps matching selections",
+			lines: []string{"ab cd be"},
+			keys:  "xs\\w+<CR>Kb<CR>",
+			want:  []string{"ab", "be"},
+			// The primary selection, be, is kept
+			primary: 1,
+		},
+		{
+			name:  "alt+k drops matching selections",
+			lines: []string{"ab cd be"},
+			keys:  "xs\\w+<CR><A-k>b<CR>",
+			want:  []string{"cd"},
+		},
+		{
+			name:    "K keeping nothing changes nothing",
+			lines:   []string{"ab cd"},
+			keys:    "xs\\w+<CR>Kz<CR>",
+			want:    []string{"ab", "cd"},
+			primary: 1,
+			msg:     "E486: No selections remaining: z",
+		},
+		{
+			name:  ") rotates the primary selection forward",
+			lines: []string{"a b c"},
+			keys:  "xs\\w<CR>)",
+			want:  []string{"a", "b", "c"},
+		},
+		{
+			name:    "( rotates it backward",
+			lines:   []string{"a b c"},
+			keys:    "xs\\w<CR>(",
+			want:    []string{"a", "b", "c"},
+			primary: 1,
+		},
+		{
+			name:    "esc at the prompt keeps the selections",
+			lines:   []string{"a b"},
+			keys:    "xs\\w<CR>s<Esc>",
+			want:    []string{"a", "b"},
+			primary: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			typeKeys(e, tt.keys)
+
+			var got []string
+			for _, sel := range e.GetSelections() {
+				got = append(got, e.buffer.GetSelectedText(sel))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("selections %q, want %q", got, tt.want)
+			}
+			if e.PrimarySelection() != tt.primary {
+				t.Errorf("primary selection %d, want %d", e.PrimarySelection(), tt.primary)
+			}
+			if e.cursor != e.selection.Head {
+				t.Errorf("cursor %+v off the primary selection %+v", e.cursor, e.selection)
+			}
+			if msg := e.GetMessage().Text; tt.msg != "" && !strings.HasPrefix(msg, tt.msg) {
+				t.Errorf("message %q, want %s", msg, tt.msg)
+			}
+			if e.GetMode() != ModeVisual {
+				t.Errorf("mode %v, want visual", e.GetMode())
+			}
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Registers a to z hold text by name. "a before y yanks into register a as well as
+the clipboard, "A appends to it instead, and "a before p pastes from it. A
+recorded macro is kept in its register as its keys in key notation, so it can be
+pasted, edited and yanked back like any other text before it is replayed.
+*/
+
+/*
+ErrKeyFailed stops replayed keys at the first one that fails: a motion that
+cannot move, such as j on the last line, or a key that reports an error. The
+failure itself is already in the message area.
+*/
+var ErrKeyFailed = errors.New("key failed")
+
+func validRegister(r rune) bool {
+	return r <= unicode.MaxASCII && unicode.IsLetter(r)
+}
+
+/*
+registerKey handles the keys that name a register in normal and visual mode:
+"x selects the register for the next yank or paste, qx starts recording a macro
+into it, q stops recording and @x replays it. Reports whether the key was
+consumed and whether it ends the editor.
+*/
+func (e *Editor) registerKey(key string) (handled, quit bool) {
+	switch e.pending {
+	case "\"":
+		e.TakePending()
+		if r, size := utf8.DecodeRuneInString(key); size == len(key) && validRegister(r) {
+			e.register = r
+		}
+		return true, false
+	case "q":
+		e.TakePending()
+		e.TakeCount()
+		e.StartRecording(key)
+		return true, false
+	case "@":
+		e.TakePending()
+		quit, err := e.RunMacro(key, e.TakeCount())
+		e.reportError(err)
+		return true, quit
+	case "":
+		switch {
+		case key == "q" && e.recording != 0:
+			e.TakeCount()
+			e.StopRecording()
+			return true, false
+		case key == "\"" || key == "q" || key == "@":
+			e.SetPending(key)
+			return true, false
+		}
+	}
+	return false, false
+}
+
+/*
+takeRegister consumes the register selected with ", or 0 when none was.
+*/
+func (e *Editor) takeRegister() rune {
+	r := e.register
+	e.register = 0
+	return r
+}
+
+/*
+registerText is what p pastes from register r, the clipboard when r is 0.
+*/
+func (e *Editor) registerText(r rune) []string {
+	if r == 0 {
+		return e.clipboard
+	}
+	return e.registers[unicode.ToLower(r)]
+}
+
+/*
+setRegister stores texts, one per selection, in register r, or appends them for
+an upper-case name. Appending to a register holding as many entries extends
+each entry; otherwise the entries are joined into one.
+*/
+func (e *Editor) setRegister(r rune, texts []string) {
+	if e.registers == nil {
+		e.registers = make(map[rune][]string)
+	}
+	name := unicode.ToLower(r)
+	prev := e.registers[name]
+	switch {
+	case r == name || len(prev) == 0:
+		e.registers[name] = texts
+	case len(prev) == len(texts):
+		joined := make([]string, len(texts))
+		for i := range texts {
+			joined[i] = prev[i] + texts[i]
+		}
+		e.registers[name] = joined
+	default:
+		e.registers[name] = []string{strings.Join(prev, "\n") + strings.Join(texts, "\n")}
+	}
+}
+
+/*
+StartRecording starts recording the keys typed from now on into a register, as
+q followed by its name does.
+*/
+func (e *Editor) StartRecording(name string) {
+	r, size := utf8.DecodeRuneInString(name)
+	if size != len(name) || !validRegister(r) {
+		return
+	}
+	e.recording = r
+	e.recorded = nil
+}
+
+/*
+StopRecording stores the recorded keys in their register, leaving out the q
+that stopped the recording.
+*/
+func (e *Editor) StopRecording() {
+	keys := e.recorded[:max(len(e.recorded)-1, 0)]
+	e.setRegister(e.recording, []string{FormatKeys(keys)})
+	e.recording = 0
+	e.recorded = nil
+}
+
+/*
+Recording is the register a macro is being recorded into, or 0 when none is.
+*/
+func (e *Editor) Recording() rune {
+	return e.recording
+}
+
+/*
+RunMacro replays the keys in a register count times, like @a; @@ replays the
+register replayed last. Replaying stops at the first key that fails, count
+included, so a macro repeated many times ends where it stops applying. A final
+line break, as yanking whole lines leaves, is not replayed. Reports whether the
+keys end the editor.
+*/
+func (e *Editor) RunMacro(name string, count int) (bool, error) {
+	r, size := utf8.DecodeRuneInString(name)
+	if r == '@' && size == len(name) {
+		if e.lastMacro == 0 {
+			return false, errors.New("E748: No previously used register")
+		}
+		r = e.lastMacro
+	}
+	if size != len(name) || !validRegister(r) {
+		return false, fmt.Errorf("E354: Invalid register name: '%s'", name)
+	}
+	r = unicode.ToLower(r)
+	e.lastMacro = r
+	keys := strings.TrimSuffix(strings.Join(e.registers[r], "\n"), "\n")
+
+	for range max(count, 1) {
+		quit, err := e.FeedKeys(keys)
+		if quit {
+			return true, nil
+		}
+		if errors.Is(err, ErrKeyFailed) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+/*
+notationNames are the names FormatKeys writes for keys that type no text.
+*/
+var notationNames = map[string]string{
+	"esc":       "Esc",
+	"enter":     "CR",
+	"backspace": "BS",
+	"tab":       "Tab",
+	"delete":    "Del",
+	"insert":    "Insert",
+	"up":        "Up",
+	"down":      "Down",
+	"left":      "Left",
+	"right":     "Right",
+	"home":      "Home",
+	"end":       "End",
+	"pgup":      "PageUp",
+	"pgdown":    "PageDown",
+	"space":     "Space",
+}
+
+/*
+FormatKeys writes keys in the key notation ParseKeys reads, such as
+"ihello<Esc>". Keys the editor has no use for are left out.
+*/
+func FormatKeys(keys []string) string {
+	var b strings.Builder
+	for _, key := range keys {
+		switch {
+		case key == "<":
+			b.WriteString("<lt>")
+		case keyText(key) != "":
+			b.WriteString(keyText(key))
+		default:
+			if name, ok := keyNotation(key); ok {
+				b.WriteString("<" + name + ">")
+			}
+		}
+	}
+	return b.String()
+}
+
+func keyNotation(key string) (string, bool) {
+	var mods string
+	for {
+		if rest, ok := strings.CutPrefix(key, "ctrl+"); ok {
+			mods, key = mods+"C-", rest
+		} else if rest, ok := strings.CutPrefix(key, "alt+"); ok {
+			mods, key = mods+"A-", rest
+		} else {
+			break
+		}
+	}
+	if name, ok := notationNames[key]; ok {
+		return mods + name, true
+	}
+	if mods != "" && utf8.RuneCountInString(key) == 1 {
+		return mods + key, true
+	}
+	return "", false
+}
@@ -0,0 +1,143 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMacros(t *testing.T) {
+	tests := []struct {
+		name     string
+		lines    []string
+		macro    string // put in register b before typing keys
+		keys     string
+		want     []string
+		register string
+		msg      string
+	}{
+		{
+			name:     "record and replay",
+			lines:    []string{"abc"},
+			keys:     "qaix<Esc>q@a",
+			want:     []string{"xxabc"},
+			register: "ix<Esc>",
+		},
+		{
+			name:  "count",
+			lines: []string{"abc"},
+			keys:  "qaix<Esc>q3@a",
+			want:  []string{"xxxxabc"},
+		},
+		{
+			name:  "@@ replays the last register",
+			lines: []string{"abc"},
+			keys:  "qaix<Esc>q@a@@",
+			want:  []string{"xxxabc"},
+		},
+		{
+			name:     "upper case appends",
+			lines:    []string{"abc"},
+			keys:     "qaix<Esc>qqAiy<Esc>q@a",
+			want:     []string{"yxyxabc"},
+			register: "ix<Esc>iy<Esc>",
+		},
+		{
+			name:  "a count stops at the first motion that fails",
+			lines: []string{"a", "b", "c"},
+			keys:  "qajix<Esc>q100@a",
+			want:  []string{"a", "xb", "xc"},
+		},
+		{
+			name:  "a macro stops at the first motion that fails",
+			lines: []string{"ab", "cd"},
+			macro: "ix<Esc>jiy<Esc>jiz<Esc>",
+			keys:  "@b",
+			want:  []string{"xab", "ycd"},
+		},
+		{
+			name:     "the register pastes as its keys",
+			lines:    []string{""},
+			keys:     "qaihi<Esc>qu\"ap",
+			want:     []string{"ihi<Esc>"},
+			register: "ihi<Esc>",
+		},
+		{
+			name:  "no previous register",
+			lines: []string{"abc"},
+			keys:  "@@",
+			want:  []string{"abc"},
+			msg:   "E748",
+		},
+		{
+			name:  "invalid register",
+			lines: []string{"abc"},
+			keys:  "@1",
+			want:  []string{"abc"},
+			msg:   "E354",
+		},
+		{
+			name:  "a macro replaying itself",
+			lines: []string{"abc"},
+			keys:  "qa@aq@a",
+			want:  []string{"abc"},
+			msg:   "E169",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			if tt.macro != "" {
+				e.setRegister('b', []string{tt.macro})
+			}
+			typeKeys(e, tt.keys)
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+			if got := strings.Join(e.registers['a'], "\n"); tt.register != "" && got != tt.register {
+				t.Errorf("register a holds %q, want %q", got, tt.register)
+			}
+			if msg := e.GetMessage().Text; tt.msg != "" && !strings.HasPrefix(msg, tt.msg) {
+				t.Errorf("message %q, want %s", msg, tt.msg)
+			}
+			if e.Recording() != 0 {
+				t.Errorf("still recording into %q", e.Recording())
+			}
+		})
+	}
+}
+
+/*
+typeKeys sends keys one at a time as if typed, which unlike FeedKeys records them
+into a macro.
+*/
+func typeKeys(e *Editor, keys string) {
+	for _, key := range ParseKeys(keys) {
+		e.HandleKey(key)
+	}
+}
+
+func TestFormatKeys(t *testing.T) {
+	tests := []struct {
+		keys []string
+		want string
+	}{
+		{[]string{"i", "h", "i", "esc"}, "ihi<Esc>"},
+		{[]string{"<", "space", "enter", "backspace"}, "<lt> <CR><BS>"},
+		{[]string{"ctrl+r", "alt+k", "ctrl+alt+x"}, "<C-r><A-k><C-A-x>"},
+		{[]string{"é", "👍🏽"}, "é👍🏽"},
+		{[]string{"f13", "x"}, "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := FormatKeys(tt.keys)
+			if got != tt.want {
+				t.Errorf("FormatKeys(%q) = %q, want %q", tt.keys, got, tt.want)
+			}
+			// What FormatKeys writes, ParseKeys reads back
+			if again := FormatKeys(ParseKeys(got)); again != got {
+				t.Errorf("%q reads back as %q", got, again)
+			}
+		})
+	}
+}
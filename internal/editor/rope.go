@@ -0,0 +1,300 @@
+package editor
+
+/*
+ropeLeafSize bounds how many lines a leaf holds. Larger leaves mean a shallower
+tree; smaller leaves mean cheaper copies when adjacent leaves are merged.
+*/
+const ropeLeafSize = 64
+
+/*
+lazyLeafSize is how many lines a leaf backed by a large file's index covers.
+Such leaves cost nothing until edited, so they can be much larger.
+*/
+const lazyLeafSize = 4096
+
+/*
+rope stores lines in a height-balanced binary tree whose leaves hold short runs of
+lines and whose internal nodes cache the line count beneath them. Indexing,
+inserting and deleting lines are all O(log n): edits split the tree at the affected
+line numbers and join the pieces back together, rebalancing AVL-style on the way up.
+Leaves of a large file may be lazy: instead of lines they refer to a run of the
+file's lineIndex, and only load their text when a line in them is changed.
+*/
+type rope struct {
+	root *ropeNode
+}
+
+type ropeNode struct {
+	lines       []string
+	left, right *ropeNode
+	count       int
+	height      int
+	src         *lineIndex
+	srcFrom     int
+}
+
+func newRope(lines []string) *rope {
+	return &rope{root: buildRope(lines)}
+}
+
+/*
+buildRope lays lines out into full leaves and pairs them up level by level,
+producing a perfectly balanced tree in linear time.
+*/
+func buildRope(lines []string) *ropeNode {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var level []*ropeNode
+	for i := 0; i < len(lines); i += ropeLeafSize {
+		end := i + ropeLeafSize
+		if end > len(lines) {
+			end = len(lines)
+		}
+		level = append(level, newRopeLeaf(append([]string(nil), lines[i:end]...)))
+	}
+	return pairRopeLevels(level)
+}
+
+/*
+newLazyRope covers the first count lines of a large file with lazy leaves.
+*/
+func newLazyRope(src *lineIndex, count int) *rope {
+	var level []*ropeNode
+	for i := 0; i < count; i += lazyLeafSize {
+		level = append(level, newLazyLeaf(src, i, min(lazyLeafSize, count-i)))
+	}
+	if len(level) == 0 {
+		return &rope{}
+	}
+	return &rope{root: pairRopeLevels(level)}
+}
+
+func pairRopeLevels(level []*ropeNode) *ropeNode {
+	for len(level) > 1 {
+		var next []*ropeNode
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, newRopeNode(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		level = next
+	}
+	return level[0]
+}
+
+func newRopeLeaf(lines []string) *ropeNode {
+	return &ropeNode{lines: lines, count: len(lines)}
+}
+
+func newLazyLeaf(src *lineIndex, from, count int) *ropeNode {
+	return &ropeNode{src: src, srcFrom: from, count: count}
+}
+
+/*
+load reads a lazy leaf's lines into memory so they can be changed.
+*/
+func (n *ropeNode) load() {
+	if n.src != nil {
+		n.lines = n.src.Lines(n.srcFrom, n.count)
+		n.src = nil
+	}
+}
+
+func newRopeNode(left, right *ropeNode) *ropeNode {
+	return &ropeNode{
+		left:   left,
+		right:  right,
+		count:  left.count + right.count,
+		height: max(left.height, right.height) + 1,
+	}
+}
+
+func (n *ropeNode) isLeaf() bool {
+	return n.left == nil
+}
+
+func ropeHeight(n *ropeNode) int {
+	if n == nil {
+		return -1
+	}
+	return n.height
+}
+
+func (r *rope) Len() int {
+	if r.root == nil {
+		return 0
+	}
+	return r.root.count
+}
+
+func (r *rope) Line(i int) string {
+	leaf, k := r.find(i)
+	if leaf.src != nil {
+		return leaf.src.Line(leaf.srcFrom + k)
+	}
+	return leaf.lines[k]
+}
+
+func (r *rope) SetLine(i int, s string) {
+	leaf, k := r.find(i)
+	leaf.load()
+	leaf.lines[k] = s
+}
+
+/*
+find descends to the leaf holding line i and returns it with the index inside it.
+*/
+func (r *rope) find(i int) (*ropeNode, int) {
+	n := r.root
+	for !n.isLeaf() {
+		if i < n.left.count {
+			n = n.left
+		} else {
+			i -= n.left.count
+			n = n.right
+		}
+	}
+	return n, i
+}
+
+/*
+Insert places lines so the first of them becomes line i.
+*/
+func (r *rope) Insert(i int, lines []string) {
+	if len(lines) == 0 {
+		return
+	}
+	left, right := ropeSplit(r.root, i)
+	r.root = ropeJoin(ropeJoin(left, buildRope(lines)), right)
+}
+
+/*
+Delete removes lines in the half-open range [from, to).
+*/
+func (r *rope) Delete(from, to int) {
+	if from >= to {
+		return
+	}
+	left, rest := ropeSplit(r.root, from)
+	_, right := ropeSplit(rest, to-from)
+	r.root = ropeJoin(left, right)
+}
+
+/*
+Each calls fn with every line in order, walking leaves directly rather than
+indexing so a full scan stays linear.
+*/
+func (r *rope) Each(fn func(line string)) {
+	var walk func(n *ropeNode)
+	walk = func(n *ropeNode) {
+		if n == nil {
+			return
+		}
+		if n.isLeaf() && n.src != nil {
+			for k := 0; k < n.count; k++ {
+				fn(n.src.Line(n.srcFrom + k))
+			}
+			return
+		}
+		if n.isLeaf() {
+			for _, line := range n.lines {
+				fn(line)
+			}
+			return
+		}
+		walk(n.left)
+		walk(n.right)
+	}
+	walk(r.root)
+}
+
+/*
+ropeSplit divides a tree into the first i lines and the rest. Leaves are split
+by slicing; the two halves never grow in place, so sharing the backing array is safe.
+Lazy leaves split into two lazy leaves without loading anything.
+*/
+func ropeSplit(n *ropeNode, i int) (*ropeNode, *ropeNode) {
+	if n == nil {
+		return nil, nil
+	}
+	if i <= 0 {
+		return nil, n
+	}
+	if i >= n.count {
+		return n, nil
+	}
+
+	if n.isLeaf() && n.src != nil {
+		return newLazyLeaf(n.src, n.srcFrom, i), newLazyLeaf(n.src, n.srcFrom+i, n.count-i)
+	}
+	if n.isLeaf() {
+		return newRopeLeaf(n.lines[:i:i]), newRopeLeaf(n.lines[i:])
+	}
+	if i <= n.left.count {
+		ll, lr := ropeSplit(n.left, i)
+		return ll, ropeJoin(lr, n.right)
+	}
+	rl, rr := ropeSplit(n.right, i-n.left.count)
+	return ropeJoin(n.left, rl), rr
+}
+
+/*
+ropeJoin concatenates two trees. The shorter tree is attached along the spine of
+the taller one at matching height and rotations restore balance on the way back
+up, costing time proportional to the height difference. Small neighbouring leaves
+are merged so repeated edits do not fragment the tree into single-line leaves.
+*/
+func ropeJoin(left, right *ropeNode) *ropeNode {
+	if left == nil {
+		return right
+	}
+	if right == nil {
+		return left
+	}
+
+	if left.isLeaf() && right.isLeaf() && left.src == nil && right.src == nil && left.count+right.count <= ropeLeafSize {
+		lines := make([]string, 0, left.count+right.count)
+		lines = append(lines, left.lines...)
+		lines = append(lines, right.lines...)
+		return newRopeLeaf(lines)
+	}
+
+	if left.height > right.height+1 {
+		return ropeBalance(newRopeNode(left.left, ropeJoin(left.right, right)))
+	}
+	if right.height > left.height+1 {
+		return ropeBalance(newRopeNode(ropeJoin(left, right.left), right.right))
+	}
+	return newRopeNode(left, right)
+}
+
+func ropeBalance(n *ropeNode) *ropeNode {
+	diff := ropeHeight(n.left) - ropeHeight(n.right)
+	switch {
+	case diff > 1:
+		if ropeHeight(n.left.left) < ropeHeight(n.left.right) {
+			n = newRopeNode(ropeRotateLeft(n.left), n.right)
+		}
+		return ropeRotateRight(n)
+	case diff < -1:
+		if ropeHeight(n.right.right) < ropeHeight(n.right.left) {
+			n = newRopeNode(n.left, ropeRotateRight(n.right))
+		}
+		return ropeRotateLeft(n)
+	}
+	return n
+}
+
+func ropeRotateLeft(n *ropeNode) *ropeNode {
+	r := n.right
+	return newRopeNode(newRopeNode(n.left, r.left), r.right)
+}
+
+func ropeRotateRight(n *ropeNode) *ropeNode {
+	l := n.left
+	return newRopeNode(l.left, newRopeNode(l.right, n.right))
+}
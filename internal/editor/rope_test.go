@@ -0,0 +1,172 @@
+package editor
+
+import (
+	"fmt"
+	"math/bits"
+	"math/rand/v2"
+	"slices"
+	"testing"
+)
+
+const benchLines = 1_000_000
+
+func benchText(n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("line %d of the benchmark buffer", i)
+	}
+	return lines
+}
+
+/*
+lineStore is the line storage the benchmarks compare: the rope, and the plain
+slice the buffer kept its lines in before it.
+*/
+type lineStore interface {
+	Len() int
+	Line(i int) string
+	SetLine(i int, s string)
+	Insert(i int, lines []string)
+	Delete(from, to int)
+}
+
+/*
+sliceLines replays the slice buffer's edits, which copy every line after the
+change.
+*/
+type sliceLines []string
+
+func (s *sliceLines) Len() int                { return len(*s) }
+func (s *sliceLines) Line(i int) string       { return (*s)[i] }
+func (s *sliceLines) SetLine(i int, l string) { (*s)[i] = l }
+
+func (s *sliceLines) Insert(i int, lines []string) {
+	*s = slices.Insert(*s, i, lines...)
+}
+
+func (s *sliceLines) Delete(from, to int) {
+	*s = append((*s)[:from], (*s)[to:]...)
+}
+
+var lineStores = []struct {
+	name string
+	make func(lines []string) lineStore
+}{
+	{"rope", func(lines []string) lineStore { return newRope(lines) }},
+	{"slice", func(lines []string) lineStore {
+		s := sliceLines(slices.Clone(lines))
+		return &s
+	}},
+}
+
+/*
+BenchmarkInsertNewline splits a line in the middle of the buffer, as enter does.
+*/
+func BenchmarkInsertNewline(b *testing.B) {
+	text := benchText(benchLines)
+	for _, store := range lineStores {
+		b.Run(store.name, func(b *testing.B) {
+			lines := store.make(text)
+			for b.Loop() {
+				i := lines.Len() / 2
+				line := lines.Line(i)
+				col := len(line) / 2
+				lines.SetLine(i, line[:col])
+				lines.Insert(i+1, []string{line[col:]})
+			}
+		})
+	}
+}
+
+/*
+BenchmarkDeleteRange joins lines across a range in the middle of the buffer, as
+d on a selection spanning lines does. The buffer is rebuilt, untimed, before it
+shrinks to half its size.
+*/
+func BenchmarkDeleteRange(b *testing.B) {
+	text := benchText(benchLines)
+	for _, store := range lineStores {
+		b.Run(store.name, func(b *testing.B) {
+			lines := store.make(text)
+			for b.Loop() {
+				if lines.Len() < benchLines/2 {
+					b.StopTimer()
+					lines = store.make(text)
+					b.StartTimer()
+				}
+				i := lines.Len() / 2
+				lines.SetLine(i, lines.Line(i)[:4]+lines.Line(i + 2)[4:])
+				lines.Delete(i+1, i+3)
+			}
+		})
+	}
+}
+
+func TestRopeMatchesSlice(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines int
+		seed  uint64
+	}{
+		{"single line", 1, 1},
+		{"one leaf", ropeLeafSize, 2},
+		{"many leaves", ropeLeafSize * 20, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rng := rand.New(rand.NewPCG(tt.seed, tt.seed))
+			want := benchText(tt.lines)
+			r := newRope(want)
+			want = slices.Clone(want)
+
+			for step := range 2000 {
+				var op string
+				switch i := rng.IntN(len(want) + 1); rng.IntN(3) {
+				case 0:
+					added := make([]string, rng.IntN(ropeLeafSize*3)+1)
+					for k := range added {
+						added[k] = fmt.Sprintf("step %d line %d", step, k)
+					}
+					op = fmt.Sprintf("Insert(%d, %d lines)", i, len(added))
+					r.Insert(i, added)
+					want = slices.Insert(want, i, added...)
+				case 1:
+					to := min(i+rng.IntN(ropeLeafSize*3), len(want))
+					// The buffer always keeps a line
+					if to-i == len(want) {
+						to--
+					}
+					op = fmt.Sprintf("Delete(%d, %d)", i, to)
+					r.Delete(i, to)
+					want = slices.Delete(want, i, to)
+				default:
+					i = min(i, len(want)-1)
+					op = fmt.Sprintf("SetLine(%d)", i)
+					r.SetLine(i, fmt.Sprintf("set at step %d", step))
+					want[i] = fmt.Sprintf("set at step %d", step)
+				}
+
+				if r.Len() != len(want) {
+					t.Fatalf("step %d %s: Len() = %d, want %d", step, op, r.Len(), len(want))
+				}
+				if i := rng.IntN(len(want)); r.Line(i) != want[i] {
+					t.Fatalf("step %d %s: Line(%d) = %q, want %q", step, op, i, r.Line(i), want[i])
+				}
+			}
+
+			var got []string
+			r.Each(func(line string) { got = append(got, line) })
+			if !slices.Equal(got, want) {
+				t.Fatalf("Each() differs from the reference after %d lines", len(want))
+			}
+			for i := range want {
+				if r.Line(i) != want[i] {
+					t.Fatalf("Line(%d) = %q, want %q", i, r.Line(i), want[i])
+				}
+			}
+			if h, limit := ropeHeight(r.root), 2*bits.Len(uint(r.Len()))+2; h > limit {
+				t.Errorf("height %d for %d lines, want at most %d", h, r.Len(), limit)
+			}
+		})
+	}
+}
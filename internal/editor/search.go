@@ -0,0 +1,281 @@
+package editor
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+/*
+searchState is a compiled search pattern. Matches never span lines. whole limits
+matches to whole words, for * and #, since regexp's \b only knows ASCII words.
+*/
+type searchState struct {
+	pattern string
+	re      *regexp.Regexp
+	forward bool
+	whole   bool
+}
+
+/*
+compileSearch compiles a typed search pattern with smart-case: it ignores case
+unless the pattern contains an upper-case letter.
+*/
+func compileSearch(pattern string, forward bool) (*searchState, error) {
+	expr := pattern
+	if !strings.ContainsFunc(pattern, unicode.IsUpper) {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &searchState{pattern: pattern, re: re, forward: forward}, nil
+}
+
+/*
+matches returns the byte ranges of the matches in line.
+*/
+func (s *searchState) matches(line string) [][]int {
+	locs := s.re.FindAllStringIndex(line, -1)
+	if !s.whole {
+		return locs
+	}
+	return slices.DeleteFunc(locs, func(loc []int) bool {
+		before := loc[0] > 0 && charClass(line, prevGrapheme(line, loc[0]), false) == classWord
+		return before || charClass(line, loc[1], false) == classWord
+	})
+}
+
+/*
+next finds the first match after pos, or before it searching backwards,
+wrapping around the buffer. Reports whether it wrapped.
+*/
+func (s *searchState) next(b *Buffer, pos Position, forward bool) (match Position, wrapped, ok bool) {
+	n := b.LineCount()
+	for i := 0; i <= n; i++ {
+		line := pos.Line + i
+		if !forward {
+			line = pos.Line - i
+		}
+		wrapped = line < 0 || line >= n
+		line = (line%n + n) % n
+
+		locs := s.matches(b.GetLine(line))
+		if !forward {
+			slices.Reverse(locs)
+		}
+		for _, loc := range locs {
+			col := loc[0]
+			switch {
+			case i > 0 && i < n:
+			case i == 0 && forward && col > pos.Col:
+			case i == 0 && !forward && col < pos.Col:
+			case i == n && forward && col <= pos.Col:
+			case i == n && !forward && col >= pos.Col:
+			default:
+				continue
+			}
+			return Position{Line: line, Col: col}, wrapped, true
+		}
+	}
+	return pos, false, false
+}
+
+/*
+activeSearch is the search whose matches are highlighted: the pattern being
+typed at a search prompt, else the last search unless :nohlsearch hid it.
+*/
+func (e *Editor) activeSearch() *searchState {
+	if e.mode == ModeCommand && (e.prompt == PromptSearchForward || e.prompt == PromptSearchBackward) {
+		s, err := compileSearch(e.command, e.prompt == PromptSearchForward)
+		if err != nil || e.command == "" {
+			return nil
+		}
+		return s
+	}
+	if !e.highlight {
+		return nil
+	}
+	return e.lastSearch
+}
+
+/*
+SearchMatches returns the highlighted matches on lines first up to last.
+*/
+func (e *Editor) SearchMatches(first, last int) []Selection {
+	s := e.activeSearch()
+	if s == nil {
+		return nil
+	}
+	var sels []Selection
+	for line := max(first, 0); line < min(last, e.buffer.LineCount()); line++ {
+		for _, loc := range s.matches(e.buffer.GetLine(line)) {
+			sels = append(sels, Selection{
+				Anchor: Position{Line: line, Col: loc[0]},
+				Head:   Position{Line: line, Col: loc[1]},
+			})
+		}
+	}
+	return sels
+}
+
+/*
+SearchCount reports which of the highlighted matches the cursor is at, counting
+those that start at or before it, and how many there are. Large files are not
+scanned and report no matches.
+*/
+func (e *Editor) SearchCount() (current, total int) {
+	s := e.activeSearch()
+	if s == nil || e.buffer.IsLarge() {
+		return 0, 0
+	}
+	for line := range e.buffer.LineCount() {
+		for _, loc := range s.matches(e.buffer.GetLine(line)) {
+			total++
+			if !after(Position{Line: line, Col: loc[0]}, e.cursor) {
+				current = total
+			}
+		}
+	}
+	return current, total
+}
+
+/*
+incsearch previews the search being typed, putting the cursor on the first
+match from where the search started.
+*/
+func (e *Editor) incsearch() {
+	if e.prompt != PromptSearchForward && e.prompt != PromptSearchBackward {
+		return
+	}
+	e.cursor = e.searchOrigin
+	if s := e.activeSearch(); s != nil {
+		if pos, _, ok := s.next(e.buffer, e.searchOrigin, s.forward); ok {
+			e.cursor = pos
+		}
+	}
+}
+
+/*
+search makes pattern the last search, an empty pattern reusing the previous one
+in the new direction, and moves to its next match.
+*/
+func (e *Editor) search(pattern string, forward bool) {
+	if pattern == "" {
+		if e.lastSearch == nil {
+			e.SetMessage(MessageError, "E35: No previous regular expression")
+			return
+		}
+		pattern = e.lastSearch.pattern
+	}
+	s, err := compileSearch(pattern, forward)
+	if err != nil {
+		e.SetMessage(MessageError, "E383: Invalid search string: "+pattern)
+		return
+	}
+	e.remember(pattern)
+	e.lastSearch = s
+	e.SearchNext(1, false)
+}
+
+/*
+remember adds a pattern to the end of the search history, dropping an earlier
+copy of it.
+*/
+func (e *Editor) remember(pattern string) {
+	e.searchHistory = slices.DeleteFunc(e.searchHistory, func(p string) bool { return p == pattern })
+	e.searchHistory = append(e.searchHistory, pattern)
+}
+
+/*
+RecallHistory replaces the search being typed with an older (-1) or newer (+1)
+entry of the search history. Going past the newest entry clears the line.
+*/
+func (e *Editor) RecallHistory(delta int) {
+	if e.prompt != PromptSearchForward && e.prompt != PromptSearchBackward {
+		return
+	}
+	e.historyIndex = min(max(e.historyIndex+delta, 0), len(e.searchHistory))
+	e.command = ""
+	if e.historyIndex < len(e.searchHistory) {
+		e.command = e.searchHistory[e.historyIndex]
+	}
+	e.incsearch()
+}
+
+/*
+SearchNext moves to the count'th next match of the last search, in its
+direction or the opposite one when reverse is set, like n and N.
+*/
+func (e *Editor) SearchNext(count int, reverse bool) {
+	s := e.lastSearch
+	if s == nil {
+		e.SetMessage(MessageError, "E35: No previous regular expression")
+		return
+	}
+	e.highlight = true
+	forward := s.forward != reverse
+
+	found, wrapped := false, false
+	e.move(count, func(pos Position) Position {
+		next, wrap, ok := s.next(e.buffer, pos, forward)
+		found = found || ok
+		wrapped = wrapped || wrap
+		return next
+	})
+
+	prefix := "?"
+	if forward {
+		prefix = "/"
+	}
+	switch {
+	case !found:
+		e.SetMessage(MessageError, "E486: Pattern not found: "+s.pattern)
+	case wrapped && forward:
+		e.SetMessage(MessageWarning, "search hit BOTTOM, continuing at TOP")
+	case wrapped:
+		e.SetMessage(MessageWarning, "search hit TOP, continuing at BOTTOM")
+	default:
+		e.SetMessage(MessageInfo, prefix+s.pattern)
+	}
+}
+
+/*
+SearchWord searches for the word under the cursor, or the next one on the line,
+as a whole word matched exactly, like * and #.
+*/
+func (e *Editor) SearchWord(forward bool, count int) {
+	line := e.buffer.GetLine(e.cursor.Line)
+	start := e.cursor.Col
+	for start < len(line) && charClass(line, start, false) != classWord {
+		start = nextGrapheme(line, start)
+	}
+	if start >= len(line) {
+		e.SetMessage(MessageError, "E348: No string under cursor")
+		return
+	}
+	for start > 0 && charClass(line, prevGrapheme(line, start), false) == classWord {
+		start = prevGrapheme(line, start)
+	}
+	word := line[start:runEnd(line, start, false)]
+
+	pattern := regexp.QuoteMeta(word)
+	e.remember(pattern)
+	e.lastSearch = &searchState{pattern: pattern, re: regexp.MustCompile(pattern), forward: forward, whole: true}
+
+	// The word itself is the first match when it lies ahead of the cursor
+	if (forward && start > e.cursor.Col) || (!forward && start < e.cursor.Col) {
+		count++
+	}
+	e.SearchNext(count, false)
+}
+
+/*
+NoHighlight hides the match highlighting until the next search, like
+:nohlsearch.
+*/
+func (e *Editor) NoHighlight() {
+	e.highlight = false
+}
@@ -0,0 +1,102 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSearch(t *testing.T) {
+	text := []string{
+		"foo bar",    // 0
+		"Foo baz",    // 1
+		"foobar foo", // 2
+		"",           // 3
+		"bar",        // 4
+	}
+	tests := []struct {
+		name  string
+		start Position
+		keys  string
+		want  Position
+		msg   string
+		level MessageLevel
+		count [2]int // the match counter, current/total
+	}{
+		{name: "forward", keys: "/bar<CR>", want: Position{Col: 4}, msg: "/bar", count: [2]int{1, 3}},
+		{name: "n goes on", keys: "/bar<CR>n", want: Position{Line: 2, Col: 3}, count: [2]int{2, 3}},
+		{name: "N goes back", keys: "/bar<CR>nN", want: Position{Col: 4}, count: [2]int{1, 3}},
+		{name: "a count", keys: "/bar<CR>2n", want: Position{Line: 4}, count: [2]int{3, 3}},
+		{name: "wrapping", keys: "/bar<CR>3n", want: Position{Col: 4}, msg: "search hit BOTTOM, continuing at TOP", level: MessageWarning},
+		{name: "backward", start: Position{Line: 4}, keys: "?bar<CR>", want: Position{Line: 2, Col: 3}, msg: "?bar"},
+		{name: "backward wrapping", keys: "?bar<CR>", want: Position{Line: 4}, msg: "search hit TOP, continuing at BOTTOM", level: MessageWarning},
+		{name: "n keeps the direction of ?", start: Position{Line: 4}, keys: "?bar<CR>n", want: Position{Col: 4}},
+		{name: "lower case ignores case", keys: "/foo<CR>", want: Position{Line: 1}, count: [2]int{2, 4}},
+		{name: "upper case matches case", keys: "/Foo<CR>", want: Position{Line: 1}, count: [2]int{1, 1}},
+		{name: "upper case skips lower", start: Position{Line: 1}, keys: "/Foo<CR>", want: Position{Line: 1}, msg: "search hit BOTTOM, continuing at TOP", level: MessageWarning},
+		{name: "regex", keys: "/ba[rz]$<CR>n", want: Position{Line: 1, Col: 4}},
+		{name: "not found", keys: "/qux<CR>", msg: "E486: Pattern not found: qux", level: MessageError},
+		{name: "invalid regex", keys: "/(<CR>", msg: "E383: Invalid search string: (", level: MessageError},
+		{name: "empty pattern repeats the last search", keys: "/bar<CR>/<CR>", want: Position{Line: 2, Col: 3}},
+		{name: "empty pattern without a last search", keys: "/<CR>", msg: "E35", level: MessageError},
+		{name: "n without a last search", keys: "n", msg: "E35", level: MessageError},
+
+		{name: "* matches whole words and case", keys: "*", want: Position{Line: 2, Col: 7}, msg: "/foo", count: [2]int{2, 2}},
+		{name: "* twice", keys: "**", want: Position{}, msg: "search hit BOTTOM", level: MessageWarning},
+		{name: "# searches backward", start: Position{Line: 2, Col: 8}, keys: "#", want: Position{}},
+		{name: "* from a blank takes the next word", start: Position{Col: 3}, keys: "*", want: Position{Line: 4}},
+		{name: "* on an empty line", start: Position{Line: 3}, keys: "*", want: Position{Line: 3}, msg: "E348", level: MessageError},
+
+		{name: "incremental search moves as you type", keys: "/baz", want: Position{Line: 1, Col: 4}, count: [2]int{1, 1}},
+		{name: "esc returns to where the search started", start: Position{Line: 2}, keys: "/baz<Esc>", want: Position{Line: 2}},
+		{name: "history recalls the last search", keys: "/baz<CR>/bar<CR>gg/<Up><CR>", want: Position{Col: 4}},
+		{name: "history goes further back", keys: "/baz<CR>/bar<CR>gg/<Up><Up><CR>", want: Position{Line: 1, Col: 4}},
+		{name: "history past the newest entry clears the line", keys: "/baz<CR>/<Up><Down>qux<CR>", want: Position{Line: 1, Col: 4}, msg: "E486", level: MessageError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(text...)
+			e.cursor = tt.start
+			e.selection = NewSelection(tt.start)
+			e.ClearMessage()
+			typeKeys(e, tt.keys)
+
+			if e.cursor != tt.want {
+				t.Errorf("cursor %+v, want %+v", e.cursor, tt.want)
+			}
+			msg := e.GetMessage()
+			if tt.msg != "" && (!strings.HasPrefix(msg.Text, tt.msg) || msg.Level != tt.level) {
+				t.Errorf("message %q at level %v, want %q at %v", msg.Text, msg.Level, tt.msg, tt.level)
+			}
+			if current, total := e.SearchCount(); [2]int{current, total} != tt.count && tt.count != [2]int{} {
+				t.Errorf("match %d/%d, want %d/%d", current, total, tt.count[0], tt.count[1])
+			}
+		})
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	e := newTestEditor("aXa", "b", "Xa")
+	typeKeys(e, "/a<CR>")
+	got := e.SearchMatches(0, 3)
+	want := []Selection{
+		{Anchor: Position{Col: 0}, Head: Position{Col: 1}},
+		{Anchor: Position{Col: 2}, Head: Position{Col: 3}},
+		{Anchor: Position{Line: 2, Col: 1}, Head: Position{Line: 2, Col: 2}},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("matches %+v, want %+v", got, want)
+	}
+	if lines := e.SearchMatches(1, 2); len(lines) != 0 {
+		t.Errorf("matches on line 2: %+v", lines)
+	}
+
+	typeKeys(e, ":noh<CR>")
+	if got := e.SearchMatches(0, 3); got != nil {
+		t.Errorf("matches %+v highlighted after :noh", got)
+	}
+	typeKeys(e, "n")
+	if got := e.SearchMatches(0, 3); len(got) != 3 {
+		t.Errorf("n highlights %d matches, want 3", len(got))
+	}
+}
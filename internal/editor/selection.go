@@ -2,7 +2,8 @@ package editor
 
 /*
 Position represents a cursor location in the buffer using zero-indexed line and column.
-The Col field is byte-based, not rune-based, simplifying buffer operations.
+The Col field is a byte offset that always falls on a grapheme cluster boundary, so
+buffer operations slice strings directly while never splitting a visible character.
 */
 type Position struct {
 	Line int
@@ -0,0 +1,341 @@
+package editor
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+substitution is a :s in progress. It walks the matches from line to the end of
+the last line, replacing them; with the c flag it stops at each one until
+ConfirmSubstitution answers for it. The whole run is one undo group, opened when
+it starts and closed by finishSubstitution.
+*/
+type substitution struct {
+	re          *regexp.Regexp
+	replacement string
+	global      bool
+
+	line int
+	last int
+
+	// The line being worked on as it was before any replacement, and its
+	// matches not yet handled. As in vim, matching goes on in this text, so a
+	// replacement is never matched again; what follows the last replaced match,
+	// from restCol in source, is at rest in the buffer.
+	source  string
+	matches [][]int
+	rest    Position
+	restCol int
+
+	// The match awaiting confirmation, as FindStringSubmatchIndex returns it
+	match []int
+
+	matched      int
+	replaced     int
+	lines        int
+	changedLine  int
+	originalLine int
+}
+
+/*
+splitDelimited splits s at the unescaped delimiters into at most three parts.
+A backslash before the delimiter makes it literal; other escapes are kept for
+the regexp.
+*/
+func splitDelimited(s string, delim rune) []string {
+	var parts []string
+	var part strings.Builder
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped && r == delim:
+			part.WriteRune(r)
+		case escaped:
+			part.WriteRune('\\')
+			part.WriteRune(r)
+		case r == '\\':
+			escaped = true
+			continue
+		case r == delim && len(parts) < 2:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteRune(r)
+		}
+		escaped = false
+	}
+	if escaped {
+		part.WriteRune('\\')
+	}
+	return append(parts, part.String())
+}
+
+/*
+replacementTemplate turns the typed replacement into a template for
+regexp.Expand: $1 or ${name} insert groups, \n inserts a line break and \\ a
+backslash.
+*/
+func replacementTemplate(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+/*
+Substitute runs :s/pattern/replacement/flags on lines first to last. The
+pattern uses Go regexp syntax, an empty one meaning the last search. Flags are g
+to replace every match on a line rather than the first, i to ignore case and c
+to confirm each replacement. All replacements undo as one change.
+*/
+func (e *Editor) Substitute(first, last int, arg string) error {
+	delim, size := utf8.DecodeRuneInString(arg)
+	if size == 0 {
+		return errors.New("E471: Argument required")
+	}
+	if unicode.IsLetter(delim) || unicode.IsDigit(delim) || delim == '\\' || delim == '"' || delim == '|' {
+		return errors.New("E146: Regular expressions can't be delimited by letters")
+	}
+	parts := splitDelimited(arg[size:], delim)
+	pattern := parts[0]
+	replacement := ""
+	if len(parts) > 1 {
+		replacement = parts[1]
+	}
+	var global, ignoreCase, confirm bool
+	if len(parts) > 2 {
+		for _, flag := range parts[2] {
+			switch flag {
+			case 'g':
+				global = true
+			case 'i':
+				ignoreCase = true
+			case 'c':
+				confirm = true
+			default:
+				return errors.New("E488: Trailing characters: " + parts[2])
+			}
+		}
+	}
+
+	if pattern == "" {
+		if e.lastSearch == nil {
+			return errors.New("E35: No previous regular expression")
+		}
+		pattern = e.lastSearch.pattern
+	}
+	expr := pattern
+	if ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return errors.New("E383: Invalid search string: " + pattern)
+	}
+	if confirm && e.global != nil {
+		return errors.New("Cannot confirm substitutions under :global")
+	}
+	if !e.editable() {
+		return nil // Already reported
+	}
+
+	// The pattern becomes the last search, so n finds what was replaced
+	e.remember(pattern)
+	e.lastSearch = &searchState{pattern: pattern, re: re, forward: true}
+
+	e.subst = &substitution{
+		re:           re,
+		replacement:  replacementTemplate(replacement),
+		global:       global,
+		line:         first,
+		last:         last,
+		changedLine:  -1,
+		originalLine: e.cursor.Line,
+	}
+	e.collapse()
+	e.beginChange()
+	if !confirm {
+		e.substituteRest()
+		return nil
+	}
+	e.highlight = true
+	e.mode = ModeConfirm
+	e.nextConfirmation()
+	return nil
+}
+
+/*
+nextMatch finds the next match of the running substitution within its lines:
+the next one left on the line being worked on, or the first (or with the g flag,
+every) match on the next line that has any.
+*/
+func (e *Editor) nextMatch() ([]int, bool) {
+	s := e.subst
+	for len(s.matches) == 0 {
+		if s.line > min(s.last, e.buffer.LineCount()-1) {
+			return nil, false
+		}
+		n := 1
+		if s.global {
+			n = -1
+		}
+		s.source = e.buffer.GetLine(s.line)
+		s.matches = s.re.FindAllStringSubmatchIndex(s.source, n)
+		s.rest = Position{Line: s.line}
+		s.restCol = 0
+		s.line++
+	}
+	loc := s.matches[0]
+	s.matches = s.matches[1:]
+	return loc, true
+}
+
+/*
+position returns where column col of the source line is in the buffer.
+*/
+func (s *substitution) position(col int) Position {
+	return Position{Line: s.rest.Line, Col: s.rest.Col + col - s.restCol}
+}
+
+/*
+replaceMatch replaces a match found by nextMatch. The rest of the source line
+now follows the replacement.
+*/
+func (e *Editor) replaceMatch(loc []int) {
+	s := e.subst
+	text := string(s.re.ExpandString(nil, s.replacement, s.source, loc))
+
+	start := s.position(loc[0])
+	e.buffer.deleteRange(start, s.position(loc[1]))
+	end := e.buffer.insertText(start, text)
+
+	s.replaced++
+	if start.Line != s.changedLine {
+		s.lines++
+	}
+	s.changedLine = end.Line
+	s.last += end.Line - start.Line
+	s.line += end.Line - start.Line
+	s.rest = end
+	s.restCol = loc[1]
+}
+
+/*
+substituteRest replaces every remaining match and finishes.
+*/
+func (e *Editor) substituteRest() {
+	for {
+		loc, ok := e.nextMatch()
+		if !ok {
+			break
+		}
+		e.subst.matched++
+		e.replaceMatch(loc)
+	}
+	e.finishSubstitution()
+}
+
+/*
+nextConfirmation moves to the next match and asks whether to replace it, or
+finishes when there are none left.
+*/
+func (e *Editor) nextConfirmation() {
+	s := e.subst
+	loc, ok := e.nextMatch()
+	if !ok {
+		e.finishSubstitution()
+		return
+	}
+	s.matched++
+	s.match = loc
+	e.cursor = s.position(loc[0])
+	e.selection = NewSelection(e.cursor)
+	text := string(s.re.ExpandString(nil, s.replacement, s.source, loc))
+	e.SetMessage(MessageInfo, fmt.Sprintf("replace with %s (y/n/a/q/l)?", text))
+}
+
+/*
+ConfirmSubstitution answers the question asked for the current match of a :s
+with the c flag: y replaces it, n skips it, a replaces it and all the rest, l
+replaces it and stops, and q or esc stops.
+*/
+func (e *Editor) ConfirmSubstitution(key string) {
+	s := e.subst
+	if s == nil {
+		return
+	}
+	switch key {
+	case "y":
+		e.replaceMatch(s.match)
+		e.nextConfirmation()
+	case "n":
+		e.nextConfirmation()
+	case "a":
+		e.replaceMatch(s.match)
+		e.substituteRest()
+	case "l":
+		e.replaceMatch(s.match)
+		e.finishSubstitution()
+	case "q", "esc":
+		e.finishSubstitution()
+	}
+}
+
+/*
+finishSubstitution closes the undo group, leaves the cursor on the first
+non-blank of the last changed line and reports what was done.
+*/
+func (e *Editor) finishSubstitution() {
+	s := e.subst
+	e.subst = nil
+	e.mode = ModeNormal
+
+	line := s.originalLine
+	if s.lines > 0 {
+		line = s.changedLine
+	}
+	e.cursor = Position{Line: line, Col: firstNonBlank(e.buffer.GetLine(line))}
+	e.clampCursor()
+	e.selection = NewSelection(e.cursor)
+	e.endChange()
+
+	// Under :g the lines without a match are not an error, and :g reports the total
+	if e.global != nil {
+		e.global.replaced += s.replaced
+		e.global.lines += s.lines
+		return
+	}
+	switch {
+	case s.matched == 0:
+		e.SetMessage(MessageError, "E486: Pattern not found: "+e.lastSearch.pattern)
+	case s.replaced == 0:
+		e.ClearMessage()
+	default:
+		e.SetMessage(MessageInfo, fmt.Sprintf("%s on %s", plural(s.replaced, "substitution"), plural(s.lines, "line")))
+	}
+}
+
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,218 @@
+package editor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSubstitute(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+		cmd   string
+		want  []string
+		msg   string
+	}{
+		{
+			name:  "first match on the line",
+			lines: []string{"aaa"},
+			cmd:   "s/a/b/",
+			want:  []string{"baa"},
+			msg:   "1 substitution on 1 line",
+		},
+		{
+			name:  "g replaces every match",
+			lines: []string{"aaa"},
+			cmd:   "s/a/b/g",
+			want:  []string{"bbb"},
+			msg:   "3 substitutions on 1 line",
+		},
+		{
+			name:  "i ignores case",
+			lines: []string{"Aa"},
+			cmd:   "s/a/b/gi",
+			want:  []string{"bb"},
+		},
+		{
+			name:  "flags without the final delimiter missing",
+			lines: []string{"aa"},
+			cmd:   "s/a/b",
+			want:  []string{"ba"},
+		},
+		{
+			name:  "whole buffer",
+			lines: []string{"a1", "x", "a2"},
+			cmd:   "%s/a/b/",
+			want:  []string{"b1", "x", "b2"},
+			msg:   "2 substitutions on 2 lines",
+		},
+		{
+			name:  "groups in the replacement",
+			lines: []string{"hello world"},
+			cmd:   `s/(\w+) (\w+)/$2 ${1}!/`,
+			want:  []string{"world hello!"},
+		},
+		{
+			name:  "line breaks in the replacement",
+			lines: []string{"a,b", "c,d"},
+			cmd:   `%s/,/\n/g`,
+			want:  []string{"a", "b", "c", "d"},
+		},
+		{
+			name:  "escaped delimiter",
+			lines: []string{"a/b"},
+			cmd:   `s/a\/b/x/`,
+			want:  []string{"x"},
+		},
+		{
+			name:  "other delimiter",
+			lines: []string{"a/b/c"},
+			cmd:   "s#/#-#g",
+			want:  []string{"a-b-c"},
+		},
+		{
+			name:  "replacement left empty",
+			lines: []string{"abc"},
+			cmd:   "s/b//",
+			want:  []string{"ac"},
+		},
+		{
+			name:  "empty match at the start of each line",
+			lines: []string{"a", "b"},
+			cmd:   "%s/^/> /",
+			want:  []string{"> a", "> b"},
+		},
+		{
+			name:  "empty match at the end of the line",
+			lines: []string{"ab"},
+			cmd:   "s/$/!/g",
+			want:  []string{"ab!"},
+		},
+		{
+			name:  "empty matches between characters",
+			lines: []string{"abc"},
+			cmd:   "s/x*/-/g",
+			want:  []string{"-a-b-c-"},
+		},
+		{
+			name:  "empty matches step over whole characters",
+			lines: []string{"éa"},
+			cmd:   "s/x*/-/g",
+			want:  []string{"-é-a-"},
+		},
+		{
+			name:  "empty and non-empty matches",
+			lines: []string{"baaac"},
+			cmd:   "s/a*/-/g",
+			want:  []string{"-b-c-"},
+		},
+		{
+			name:  "matches starting inside replaced text",
+			lines: []string{"xc"},
+			cmd:   `s/x|bc|c/ab/g`,
+			want:  []string{"abab"},
+		},
+		{
+			name:  "replacements are not matched again",
+			lines: []string{"aa"},
+			cmd:   "s/a/aa/g",
+			want:  []string{"aaaa"},
+		},
+		{
+			name:  "anchors see the whole line",
+			lines: []string{"aaa"},
+			cmd:   "s/^a/b/g",
+			want:  []string{"baa"},
+		},
+		{
+			name:  "word boundaries see the whole line",
+			lines: []string{"x xx"},
+			cmd:   `s/\bx/y/g`,
+			want:  []string{"y yx"},
+		},
+		{
+			name:  "matches after a line break in the replacement",
+			lines: []string{"a,b,c", "d,e"},
+			cmd:   `%s/,/;\n/g`,
+			want:  []string{"a;", "b;", "c", "d;", "e"},
+			msg:   "3 substitutions on 2 lines",
+		},
+		{
+			name:  "no match",
+			lines: []string{"abc"},
+			cmd:   "s/x/y/",
+			want:  []string{"abc"},
+			msg:   "E486: Pattern not found: x",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			if _, err := e.runEx(tt.cmd); err != nil {
+				t.Fatalf("runEx(%q): %v", tt.cmd, err)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("lines %q, want %q", got, tt.want)
+			}
+			if tt.msg != "" && e.GetMessage().Text != tt.msg {
+				t.Errorf("message %q, want %q", e.GetMessage().Text, tt.msg)
+			}
+
+			// Every replacement undoes as one step
+			feed(t, e, "u")
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.lines) {
+				t.Errorf("after undo lines %q, want %q", got, tt.lines)
+			}
+		})
+	}
+}
+
+func TestSubstituteErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		arg  string
+		err  string
+	}{
+		{"no pattern", "", "E471"},
+		{"letter delimiter", "a1a2a", "E146"},
+		{"unknown flag", "/a/b/gz", "E488"},
+		{"invalid pattern", "/(/x/", "E383"},
+		{"no previous pattern", "//x/", "E35"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor("a")
+			err := e.Substitute(0, 0, tt.arg)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+				t.Errorf("Substitute(%q) = %v, want %s", tt.arg, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSubstituteConfirm(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers string
+		want    string
+	}{
+		{"yes and no", "yny", "bab"},
+		{"all", "na", "abb"},
+		{"last", "nl", "aba"},
+		{"quit", "yq", "baa"},
+		{"escape", "<Esc>", "aaa"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor("aaa")
+			feed(t, e, ":s/a/b/gc<CR>"+tt.answers)
+			if got := e.buffer.GetLine(0); got != tt.want {
+				t.Errorf("line %q, want %q", got, tt.want)
+			}
+			if e.GetMode() != ModeNormal {
+				t.Errorf("mode %v after answering, want normal", e.GetMode())
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package editor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const swapFileVersion = 1
+
+/*
+swapFile journals the unsaved changes of an open buffer. Rather than a copy of
+the text it stores the edits that lead from the file as last loaded or saved
+(identified by BaseHash) to the buffer's current state, which keeps periodic
+writes small even for large files. PID and Host identify the editor that owns
+the file, so a second editor can tell a live session from a crashed one.
+*/
+type swapFile struct {
+	Version  int
+	PID      int
+	Host     string
+	Filename string
+	BaseHash string
+	Time     time.Time
+	Edits    []edit
+}
+
+/*
+swapState tracks the editor's relationship with the buffer's swap file. The
+editor only writes the swap file it owns; one left behind by another session is
+kept in found until the user recovers or deletes it. written remembers the
+buffer state last journaled so unchanged buffers are not rewritten.
+*/
+type swapState struct {
+	path    string
+	owned   bool
+	found   *swapFile
+	written swapMark
+}
+
+/*
+swapMark identifies a buffer state for WriteSwap: the undo state, and the
+history's revision, which moves with every edit, including those of an insert
+session still being typed or of a large file without undo, and every save.
+*/
+type swapMark struct {
+	current  *undoNode
+	revision int
+}
+
+/*
+swapPath places the swap file next to the file as a hidden sibling, so it lives
+on the same filesystem and is found by any editor opening the same path.
+*/
+func swapPath(filename string) string {
+	dir, base := filepath.Split(filename)
+	return filepath.Join(dir, "."+base+".tw.swp")
+}
+
+func readSwap(path string) (*swapFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var sf swapFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, err
+	}
+	if sf.Version != swapFileVersion {
+		return nil, fmt.Errorf("unsupported swap file version %d", sf.Version)
+	}
+	return &sf, nil
+}
+
+/*
+openSwap claims the swap file for a freshly loaded buffer. A swap file owned by
+a live editor means the file is already being edited, which is only warned
+about. One left by a dead session is kept for recovery unless it journals no
+changes, in which case it is stale and silently replaced.
+*/
+func (e *Editor) openSwap() {
+	e.releaseSwap()
+	e.swap = swapState{}
+	if e.buffer.filename == "" {
+		return
+	}
+	e.swap.path = swapPath(e.buffer.filename)
+
+	sf, err := readSwap(e.swap.path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		e.claimSwap()
+	case err != nil:
+		e.SetMessage(MessageWarning, fmt.Sprintf("E325: Unreadable swap file %s: %v; :swapdelete to remove it", e.swap.path, err))
+	case sf.otherSessionAlive():
+		e.SetMessage(MessageWarning, fmt.Sprintf("E325: %q is already open in threadweaver (pid %d on %s)", e.buffer.filename, sf.PID, sf.Host))
+	case len(sf.Edits) == 0:
+		e.claimSwap()
+	default:
+		e.swap.found = sf
+		e.SetMessage(MessageWarning, fmt.Sprintf("E325: Swap file from %s has unsaved changes: :recover, :swapdiff or :swapdelete", sf.Time.Format("2006-01-02 15:04")))
+	}
+}
+
+/*
+otherSessionAlive reports whether the editor that wrote the swap file may still
+be running. A swap file from another host cannot be checked and is assumed live.
+*/
+func (sf *swapFile) otherSessionAlive() bool {
+	host, _ := os.Hostname()
+	if sf.Host != host {
+		return true
+	}
+	return sf.PID != os.Getpid() && processAlive(sf.PID)
+}
+
+func (e *Editor) claimSwap() {
+	e.swap.owned = true
+	e.swap.found = nil
+	e.reportError(e.WriteSwap())
+}
+
+/*
+WriteSwap journals the buffer's unsaved changes to its swap file. It is called
+periodically, and only writes when the buffer changed or was saved since the
+last write, counting the edits of an insert session still being typed. The swap
+file takes the file's permissions so it never exposes more than the file itself.
+*/
+func (e *Editor) WriteSwap() error {
+	h := e.buffer.history
+	state := swapMark{current: h.current, revision: h.revision}
+	if !e.swap.owned || e.swap.written == state {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	sf := swapFile{
+		Version:  swapFileVersion,
+		PID:      os.Getpid(),
+		Host:     host,
+		Filename: e.buffer.filename,
+		BaseHash: e.buffer.disk.hash,
+		Time:     time.Now(),
+		Edits:    h.unsaved(),
+	}
+	data, err := json.Marshal(sf)
+	if err != nil {
+		return err
+	}
+
+	perm := fs.FileMode(0600)
+	if info, err := os.Stat(e.buffer.filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+	if err := writeFileAtomic(e.swap.path, data, perm); err != nil {
+		return fmt.Errorf("E513: Unable to write swap file: %w", err)
+	}
+	e.swap.written = state
+	return nil
+}
+
+/*
+Recover replays the journal of a swap file left by a crashed session as a single
+undoable change. The journal only applies to the exact text it was recorded
+against, so a file changed since then is refused rather than corrupted.
+*/
+func (e *Editor) Recover() error {
+	sf, err := e.foundSwap()
+	if err != nil {
+		return err
+	}
+	if e.buffer.Indexing() {
+		return ErrIndexing
+	}
+	if e.buffer.readOnly {
+		return ErrReadOnly
+	}
+
+	e.beginChange()
+	if e.buffer.IsLarge() {
+		for _, ed := range sf.Edits {
+			e.buffer.applyEdit(ed)
+		}
+	} else {
+		e.buffer.replaceLines(e.replaySwap(sf).allLines())
+	}
+	e.endChange()
+	e.clampCursor()
+	e.collapse()
+
+	os.Remove(e.swap.path)
+	e.claimSwap()
+	e.SetMessage(MessageInfo, fmt.Sprintf("Recovered %d edits; :w to keep them", len(sf.Edits)))
+	return nil
+}
+
+/*
+SwapDiff reports how the swap file's version differs from the file on disk
+without touching the buffer, by replaying the journal on a scratch copy and
+comparing lines.
+*/
+func (e *Editor) SwapDiff() error {
+	sf, err := e.foundSwap()
+	if err != nil {
+		return err
+	}
+
+	disk := &Buffer{lines: newRope(e.buffer.base), history: newHistory()}
+	if e.buffer.IsLarge() {
+		disk = e.buffer
+	}
+	first, lastOld, lastNew := diffBounds(disk, e.replaySwap(sf))
+	if first < 0 {
+		e.SetMessage(MessageInfo, "Swap file matches the file")
+		return nil
+	}
+	e.SetMessage(MessageInfo, fmt.Sprintf("Swap file differs at lines %d-%d: %d lines on disk, %d in swap",
+		first+1, lastNew+1, lastOld-first+1, lastNew-first+1))
+	return nil
+}
+
+/*
+foundSwap returns the swap file left by another session once its journal is
+known to apply: it must have been recorded against the file as it is on disk.
+A large file keeps no copy of that text besides the buffer itself, so its
+journal is refused once the buffer has been edited too.
+*/
+func (e *Editor) foundSwap() (*swapFile, error) {
+	sf := e.swap.found
+	if sf == nil {
+		return nil, errors.New("E305: No swap file found")
+	}
+	if sf.BaseHash != e.buffer.disk.hash {
+		return nil, errors.New("E308: File changed since the swap file was written; :swapdelete to discard it")
+	}
+	if e.buffer.IsLarge() && (e.buffer.IsDirty() || !e.buffer.history.atSaved()) {
+		return nil, errors.New("E37: No write since last change; :e! to discard it before using the swap file")
+	}
+	return sf, nil
+}
+
+/*
+replaySwap applies the swap file's journal to a scratch copy of the text it was
+recorded against: the lines last loaded or saved, or for a large file, which
+keeps no such copy, the unedited buffer.
+*/
+func (e *Editor) replaySwap(sf *swapFile) *Buffer {
+	lines := e.buffer.base
+	if e.buffer.IsLarge() {
+		lines = e.buffer.allLines()
+	}
+	scratch := &Buffer{lines: newRope(lines), history: newHistory()}
+	for _, ed := range sf.Edits {
+		scratch.applyEdit(ed)
+	}
+	return scratch
+}
+
+/*
+diffBounds trims the lines two buffers share at the start and end and returns
+the first differing line plus the last differing line in each, or -1 when the
+buffers are identical.
+*/
+func diffBounds(a, b *Buffer) (first, lastA, lastB int) {
+	n, m := a.LineCount(), b.LineCount()
+	first = 0
+	for first < n && first < m && a.GetLine(first) == b.GetLine(first) {
+		first++
+	}
+	if first == n && first == m {
+		return -1, -1, -1
+	}
+
+	lastA, lastB = n-1, m-1
+	for lastA >= first && lastB >= first && a.GetLine(lastA) == b.GetLine(lastB) {
+		lastA--
+		lastB--
+	}
+	return first, lastA, lastB
+}
+
+/*
+DeleteSwap discards a swap file left by another session and takes over
+journaling for this one.
+*/
+func (e *Editor) DeleteSwap() error {
+	if e.swap.path == "" || e.swap.owned {
+		return errors.New("E305: No swap file found")
+	}
+	if err := os.Remove(e.swap.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	e.claimSwap()
+	e.SetMessage(MessageInfo, "Swap file deleted")
+	return nil
+}
+
+func (e *Editor) releaseSwap() {
+	if e.swap.owned {
+		os.Remove(e.swap.path)
+		e.swap.owned = false
+	}
+}
+
+/*
+Close releases resources held for the open file. On a clean exit the swap file
+is removed, so one left behind always means a session ended unexpectedly.
+*/
+func (e *Editor) Close() {
+	e.releaseSwap()
+	e.buffer.Close()
+}
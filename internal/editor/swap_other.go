@@ -0,0 +1,20 @@
+//go:build !unix
+
+package editor
+
+import (
+	"os"
+)
+
+/*
+processAlive relies on FindProcess, which on non-unix platforms opens a handle to
+the process and fails when it no longer exists.
+*/
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
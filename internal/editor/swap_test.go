@@ -0,0 +1,247 @@
+package editor
+
+import (
+	"os"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSwapRecovery(t *testing.T) {
+	tests := []struct {
+		name  string
+		large bool
+		keys  string
+		disk  string // rewritten before after is typed
+		after string
+		touch string
+		local string // typed in the recovering session first
+		want  []string
+		err   string
+	}{
+		{
+			name: "finished insert sessions",
+			keys: "ione<CR><Esc>itwo <Esc>",
+			want: []string{"one", "two abc"},
+		},
+		{
+			name: "insert session still being typed",
+			keys: "ihello",
+			want: []string{"helloabc"},
+		},
+		{
+			name: "undone changes are not replayed",
+			keys: "ia<Esc>ib<Esc>u",
+			want: []string{"aabc"},
+		},
+		{
+			name: "only changes since the last save",
+			keys: "isaved <Esc>:w<CR>ilater <Esc>",
+			want: []string{"savedlater  abc"},
+		},
+		{
+			name:  "large file without undo",
+			large: true,
+			keys:  "ihi<Esc>",
+			want:  []string{"hiabc"},
+		},
+		{
+			name:  "edits after :merge",
+			keys:  "iX<Esc>",
+			disk:  "abc\nnew\n",
+			after: ":merge<CR>iY<Esc>",
+			want:  []string{"Y<<<<<<< buffer", "Xabc", "=======", "abc", "new", ">>>>>>> disk"},
+		},
+		{
+			name:  "edits after :keep",
+			keys:  "iX<Esc>",
+			disk:  "other\n",
+			after: ":keep<CR>iY<Esc>",
+			want:  []string{"YXabc"},
+		},
+		{
+			name:  "undone past :keep",
+			keys:  "iX<Esc>",
+			disk:  "other\n",
+			after: ":keep<CR>u",
+			want:  []string{"abc"},
+		},
+		{
+			name:  "buffer edited before recovering",
+			keys:  "ione<CR><Esc>itwo <Esc>",
+			local: "xdilocal<Esc>",
+			want:  []string{"one", "two abc"},
+		},
+		{
+			name:  "large file edited before recovering",
+			large: true,
+			keys:  "ihi<Esc>",
+			local: "xd",
+			err:   "E37",
+		},
+		{
+			name:  "file changed since the swap file was written",
+			keys:  "ihello<Esc>",
+			touch: "other\n",
+			err:   "E308",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "abc\n")
+			crashed := openSwapTestFile(t, filename, tt.large)
+			feed(t, crashed, tt.keys)
+			if err := crashed.WriteSwap(); err != nil {
+				t.Fatalf("WriteSwap: %v", err)
+			}
+			if tt.disk != "" {
+				mustWrite(t, filename, tt.disk, 0644)
+				feed(t, crashed, tt.after)
+				if err := crashed.WriteSwap(); err != nil {
+					t.Fatalf("WriteSwap after %q: %v", tt.after, err)
+				}
+			}
+			// The crashed session never closes, so its swap file stays behind
+			if tt.touch != "" {
+				mustWrite(t, filename, tt.touch, 0644)
+			}
+
+			e := openSwapTestFile(t, filename, tt.large)
+			if e.swap.found == nil {
+				t.Fatalf("swap file not found: %s", e.GetMessage().Text)
+			}
+			feed(t, e, tt.local)
+			local := e.buffer.allLines()
+			err := e.Recover()
+			if tt.err != "" {
+				if err == nil || !strings.HasPrefix(err.Error(), tt.err) {
+					t.Fatalf("Recover() = %v, want %s", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Recover: %v", err)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, tt.want) {
+				t.Errorf("recovered %q, want %q", got, tt.want)
+			}
+			if !e.buffer.IsDirty() {
+				t.Error("recovered buffer is clean")
+			}
+			if !e.swap.owned {
+				t.Error("swap file not taken over after recovery")
+			}
+			feed(t, e, "u")
+			if got := e.buffer.allLines(); !tt.large && !slices.Equal(got, local) {
+				t.Errorf("undo after recovery gives %q, want %q", got, local)
+			}
+		})
+	}
+}
+
+func TestSwapDiff(t *testing.T) {
+	tests := []struct {
+		name  string
+		keys  string
+		local string
+		msg   string
+	}{
+		{
+			name: "changed line",
+			keys: "jiX<Esc>",
+			msg:  "Swap file differs at lines 2-2: 1 lines on disk, 1 in swap",
+		},
+		{
+			name: "added lines",
+			keys: "jinew<CR><Esc>",
+			msg:  "Swap file differs at lines 2-2: 0 lines on disk, 1 in swap",
+		},
+		{
+			name:  "against the file, not the edited buffer",
+			keys:  "jiX<Esc>",
+			local: "xdxd",
+			msg:   "Swap file differs at lines 2-2: 1 lines on disk, 1 in swap",
+		},
+		{
+			name: "edits that cancel out",
+			keys: "iX<BS><Esc>",
+			msg:  "Swap file matches the file",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "one\ntwo\nthree\n")
+			crashed := openSwapTestFile(t, filename, false)
+			feed(t, crashed, tt.keys)
+			if err := crashed.WriteSwap(); err != nil {
+				t.Fatalf("WriteSwap: %v", err)
+			}
+
+			e := openSwapTestFile(t, filename, false)
+			feed(t, e, tt.local)
+			local := e.buffer.allLines()
+			if err := e.SwapDiff(); err != nil {
+				t.Fatalf("SwapDiff: %v", err)
+			}
+			if msg := e.GetMessage().Text; msg != tt.msg {
+				t.Errorf("message %q, want %q", msg, tt.msg)
+			}
+			if got := e.buffer.allLines(); !slices.Equal(got, local) {
+				t.Errorf("SwapDiff changed the buffer to %q", got)
+			}
+		})
+	}
+}
+
+func TestSwapStale(t *testing.T) {
+	tests := []struct {
+		name string
+		keys string
+	}{
+		{"no edits", ""},
+		{"edits saved", "ihello<Esc>:w<CR>"},
+		{"edits undone", "ihello<Esc>u"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "abc\n")
+			crashed := openSwapTestFile(t, filename, false)
+			feed(t, crashed, tt.keys)
+			if err := crashed.WriteSwap(); err != nil {
+				t.Fatalf("WriteSwap: %v", err)
+			}
+
+			e := openSwapTestFile(t, filename, false)
+			if e.swap.found != nil || !e.swap.owned {
+				t.Errorf("swap file without changes not replaced: %s", e.GetMessage().Text)
+			}
+		})
+	}
+}
+
+func TestSwapRemovedOnClose(t *testing.T) {
+	filename := tempFile(t, "abc\n")
+	e := openSwapTestFile(t, filename, false)
+	feed(t, e, "ihello<Esc>")
+	if err := e.WriteSwap(); err != nil {
+		t.Fatalf("WriteSwap: %v", err)
+	}
+	e.Close()
+	if _, err := os.Stat(swapPath(filename)); !os.IsNotExist(err) {
+		t.Errorf("swap file left after a clean exit: %v", err)
+	}
+}
+
+func openSwapTestFile(t *testing.T, filename string, large bool) *Editor {
+	t.Helper()
+	e := New()
+	if large {
+		e.buffer.largeFileThreshold = 1
+	}
+	if err := e.LoadFile(filename); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	for e.PollIndex() {
+	}
+	return e
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package editor
+
+import (
+	"errors"
+	"syscall"
+)
+
+/*
+processAlive probes pid with signal 0, which checks for existence without
+delivering anything. EPERM still means the process exists under another user.
+*/
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
@@ -0,0 +1,555 @@
+package editor
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+/*
+textObject finds an object that covers the range from start up to end, which
+is empty for a bare cursor. inner objects are the content alone; around adds the
+delimiters or surrounding whitespace. Returns false if there is none.
+*/
+type textObject func(b *Buffer, start, end Position, around bool) (Position, Position, bool)
+
+var textObjects = map[string]textObject{
+	"w":  wordObject(false),
+	"W":  wordObject(true),
+	"s":  sentenceObject,
+	"p":  paragraphObject,
+	"i":  indentObject,
+	"t":  tagObject,
+	"(":  pairObject('(', ')'),
+	")":  pairObject('(', ')'),
+	"b":  pairObject('(', ')'),
+	"[":  pairObject('[', ']'),
+	"]":  pairObject('[', ']'),
+	"{":  pairObject('{', '}'),
+	"}":  pairObject('{', '}'),
+	"B":  pairObject('{', '}'),
+	"<":  pairObject('<', '>'),
+	">":  pairObject('<', '>'),
+	"\"": quoteObject('"'),
+	"'":  quoteObject('\''),
+	"`":  quoteObject('`'),
+}
+
+/*
+SelectTextObject selects the object named by key at the cursor, such as ( for
+parentheses, w for a word or t for an XML tag. Once the selection covers an
+object, selecting it again grows to the next larger one: from the inside to
+around, then to the enclosing pair, so a count or repeated keys widen step by
+step.
+*/
+func (e *Editor) SelectTextObject(key string, around bool, count int) {
+	e.each(func() {
+		find, ok := textObjects[key]
+		if !ok {
+			return
+		}
+		start, end := e.cursor, e.cursor
+		if e.mode == ModeVisual {
+			start, end = e.selection.Start(), e.selection.End()
+		}
+
+		for range max(count, 1) {
+			s, t, ok := growObject(e.buffer, find, start, end, around)
+			if !ok {
+				break
+			}
+			start, end = s, t
+		}
+		if start == end {
+			return
+		}
+		e.selection = Selection{Anchor: start, Head: end}
+		e.cursor = end
+		e.mode = ModeVisual
+	})
+}
+
+/*
+growObject finds an object for a bare cursor, or one that strictly contains a
+selection. When the inner object only covers what is already selected it takes
+the around object, and failing that it looks again one character further out,
+which reaches the enclosing pair of nested delimiters.
+*/
+func growObject(b *Buffer, find textObject, start, end Position, around bool) (Position, Position, bool) {
+	larger := func(s, t Position) bool {
+		if start == end {
+			return s != t
+		}
+		return !after(s, start) && !after(end, t) && (s != start || t != end)
+	}
+	from, to := start, end
+	for range 2 {
+		if s, t, ok := find(b, from, to, around); ok && larger(s, t) {
+			return s, t, true
+		}
+		if !around {
+			if s, t, ok := find(b, from, to, true); ok && larger(s, t) {
+				return s, t, true
+			}
+		}
+		if start == end {
+			break
+		}
+		from, _ = b.prevPosition(start)
+		to, _ = b.nextPosition(end)
+	}
+	return start, end, false
+}
+
+/*
+after reports whether a comes after b in the buffer.
+*/
+func after(a, b Position) bool {
+	return a.Line > b.Line || (a.Line == b.Line && a.Col > b.Col)
+}
+
+/*
+lastSelected is the position of the last character in a range, which for an
+empty range is the cursor itself.
+*/
+func (b *Buffer) lastSelected(start, end Position) Position {
+	if start == end {
+		return start
+	}
+	if end.Col == 0 && end.Line > start.Line {
+		line := b.GetLine(end.Line - 1)
+		return Position{Line: end.Line - 1, Col: len(line)}
+	}
+	return Position{Line: end.Line, Col: prevGrapheme(b.GetLine(end.Line), end.Col)}
+}
+
+/*
+wordObject selects runs of one character class on a line: a word, a run of
+punctuation or a run of blanks. Around takes the whitespace after the word, or
+before it if there is none after; on blanks it takes the following word.
+*/
+func wordObject(big bool) textObject {
+	return func(b *Buffer, start, end Position, around bool) (Position, Position, bool) {
+		last := b.lastSelected(start, end)
+		if last.Line != start.Line {
+			return start, end, false
+		}
+		line := b.GetLine(start.Line)
+		if line == "" {
+			return start, end, false
+		}
+
+		s := start.Col
+		class := charClass(line, s, big)
+		for s > 0 && charClass(line, prevGrapheme(line, s), big) == class {
+			s = prevGrapheme(line, s)
+		}
+		t := runEnd(line, last.Col, big)
+
+		if around {
+			if charClass(line, last.Col, big) == classBlank {
+				if t < len(line) {
+					t = runEnd(line, t, big)
+				}
+			} else if t < len(line) && charClass(line, t, big) == classBlank {
+				t = runEnd(line, t, big)
+			} else {
+				for s > 0 && charClass(line, prevGrapheme(line, s), big) == classBlank {
+					s = prevGrapheme(line, s)
+				}
+			}
+		}
+		return Position{Line: start.Line, Col: s}, Position{Line: start.Line, Col: t}, true
+	}
+}
+
+/*
+runEnd returns the end of the run of characters of the same class as the one at col.
+*/
+func runEnd(line string, col int, big bool) int {
+	class := charClass(line, col, big)
+	for col < len(line) && charClass(line, col, big) == class {
+		col = nextGrapheme(line, col)
+	}
+	return col
+}
+
+/*
+lineRange selects whole lines, ending after the last character of the last line
+like SelectLine does.
+*/
+func (b *Buffer) lineRange(first, last int) (Position, Position, bool) {
+	return Position{Line: first}, Position{Line: last, Col: len(b.GetLine(last))}, true
+}
+
+/*
+paragraphObject selects the run of non-empty lines, or of empty lines, that
+holds the range. Around adds the empty lines that follow, or precede when it is
+the last paragraph.
+*/
+func paragraphObject(b *Buffer, start, end Position, around bool) (Position, Position, bool) {
+	first, last := start.Line, b.lastSelected(start, end).Line
+	empty := b.emptyLine(first)
+	for first > 0 && b.emptyLine(first-1) == empty {
+		first--
+	}
+	for last+1 < b.LineCount() && b.emptyLine(last+1) == b.emptyLine(last) {
+		last++
+	}
+	if around {
+		if last+1 < b.LineCount() {
+			empty := b.emptyLine(last + 1)
+			for last+1 < b.LineCount() && b.emptyLine(last+1) == empty {
+				last++
+			}
+		} else if first > 0 {
+			empty := b.emptyLine(first - 1)
+			for first > 0 && b.emptyLine(first-1) == empty {
+				first--
+			}
+		}
+	}
+	return b.lineRange(first, last)
+}
+
+/*
+indentWidth measures a line's indentation in columns, with tabs advancing to the
+next multiple of eight.
+*/
+func indentWidth(line string) int {
+	width := 0
+	for _, c := range line {
+		switch c {
+		case ' ':
+			width++
+		case '\t':
+			width += 8 - width%8
+		default:
+			return width
+		}
+	}
+	return width
+}
+
+func blankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+/*
+indentObject selects the block of lines indented at least as deeply as the
+range, with blank lines inside it. Around adds the less indented line that
+introduces the block, such as the line holding a function's signature.
+*/
+func indentObject(b *Buffer, start, end Position, around bool) (Position, Position, bool) {
+	first, last := start.Line, b.lastSelected(start, end).Line
+	level := -1
+	for i := first; i <= last; i++ {
+		if line := b.GetLine(i); !blankLine(line) && (level < 0 || indentWidth(line) < level) {
+			level = indentWidth(line)
+		}
+	}
+	if level < 0 {
+		// Only blank lines: go by the next line with text
+		for i := last + 1; i < b.LineCount() && level < 0; i++ {
+			if line := b.GetLine(i); !blankLine(line) {
+				level = indentWidth(line)
+			}
+		}
+		if level < 0 {
+			return start, end, false
+		}
+	}
+
+	inBlock := func(i int) bool {
+		line := b.GetLine(i)
+		return blankLine(line) || indentWidth(line) >= level
+	}
+	for first > 0 && inBlock(first-1) {
+		first--
+	}
+	for last+1 < b.LineCount() && inBlock(last+1) {
+		last++
+	}
+	for first < last && blankLine(b.GetLine(first)) {
+		first++
+	}
+	for last > first && blankLine(b.GetLine(last)) {
+		last--
+	}
+	if around && first > 0 {
+		first--
+	}
+	return b.lineRange(first, last)
+}
+
+/*
+objectWindow bounds how many lines around the cursor delimited objects look at,
+so finding brackets in a huge file does not read all of it.
+*/
+const objectWindow = 5000
+
+/*
+flatText is a stretch of lines joined by newlines, for objects whose delimiters
+may sit on different lines.
+*/
+type flatText struct {
+	text   string
+	first  int
+	starts []int
+}
+
+func (b *Buffer) flatten(first, last int) flatText {
+	f := flatText{first: first}
+	var text strings.Builder
+	for i := first; i <= last; i++ {
+		f.starts = append(f.starts, text.Len())
+		text.WriteString(b.GetLine(i))
+		if i < last {
+			text.WriteByte('\n')
+		}
+	}
+	f.text = text.String()
+	return f
+}
+
+func (b *Buffer) flattenAround(start, end Position) flatText {
+	return b.flatten(max(start.Line-objectWindow, 0), min(end.Line+objectWindow, b.LineCount()-1))
+}
+
+func (f flatText) offset(pos Position) int {
+	return f.starts[pos.Line-f.first] + pos.Col
+}
+
+func (f flatText) position(offset int) Position {
+	i := sort.Search(len(f.starts), func(i int) bool { return f.starts[i] > offset }) - 1
+	return Position{Line: f.first + i, Col: offset - f.starts[i]}
+}
+
+/*
+delimited converts an object found in flat text back to positions.
+*/
+func (f flatText) delimited(s, t int) (Position, Position, bool) {
+	return f.position(s), f.position(t), true
+}
+
+/*
+pairObject selects between matching brackets, counting nested pairs. A cursor on
+a bracket selects the pair it belongs to.
+*/
+func pairObject(open, close byte) textObject {
+	return func(b *Buffer, start, end Position, around bool) (Position, Position, bool) {
+		f := b.flattenAround(start, end)
+		s, t := f.offset(start), f.offset(end)
+		text := f.text
+
+		o, c := -1, -1
+		if s == t && s < len(text) {
+			switch text[s] {
+			case open:
+				o, t = s, s+1
+			case close:
+				c = s
+			}
+		}
+		if o < 0 {
+			for i, depth := s-1, 0; i >= 0; i-- {
+				if text[i] == close {
+					depth++
+				} else if text[i] == open {
+					if depth == 0 {
+						o = i
+						break
+					}
+					depth--
+				}
+			}
+		}
+		if c < 0 {
+			for i, depth := t, 0; i < len(text); i++ {
+				if text[i] == open {
+					depth++
+				} else if text[i] == close {
+					if depth == 0 {
+						c = i
+						break
+					}
+					depth--
+				}
+			}
+		}
+		if o < 0 || c < 0 {
+			return start, end, false
+		}
+		if around {
+			return f.delimited(o, c+1)
+		}
+		return f.delimited(o+1, c)
+	}
+}
+
+/*
+quoteObject selects a quoted string on the cursor's line. Quotes pair up from
+the start of the line, skipping escaped ones; with the cursor before the first
+quote the next string on the line is taken. Around adds the whitespace after
+the closing quote, or before the opening one if there is none after.
+*/
+func quoteObject(quote byte) textObject {
+	return func(b *Buffer, start, end Position, around bool) (Position, Position, bool) {
+		last := b.lastSelected(start, end)
+		if last.Line != start.Line {
+			return start, end, false
+		}
+		line := b.GetLine(start.Line)
+
+		var quotes []int
+		for i := 0; i < len(line); i++ {
+			if line[i] == '\\' {
+				i++
+			} else if line[i] == quote {
+				quotes = append(quotes, i)
+			}
+		}
+		a, z := -1, -1
+		for k := 0; k+1 < len(quotes); k += 2 {
+			if quotes[k] <= start.Col && last.Col <= quotes[k+1] {
+				a, z = quotes[k], quotes[k+1]
+				break
+			}
+			if start == end && quotes[k] > start.Col {
+				a, z = quotes[k], quotes[k+1]
+				break
+			}
+		}
+		if a < 0 {
+			return start, end, false
+		}
+
+		s, t := a+1, z
+		if around {
+			s, t = a, z+1
+			if t < len(line) && charClass(line, t, false) == classBlank {
+				t = runEnd(line, t, false)
+			} else {
+				for s > 0 && charClass(line, s-1, false) == classBlank {
+					s--
+				}
+			}
+		}
+		return Position{Line: start.Line, Col: s}, Position{Line: start.Line, Col: t}, true
+	}
+}
+
+var tagPattern = regexp.MustCompile(`<(/?)([A-Za-z][\w:.-]*)[^<>]*?(/?)>`)
+
+/*
+tagObject selects between an XML or HTML tag and its closing tag, choosing the
+innermost pair around the range. Self-closing tags have no inside and closing
+tags without an opening one are ignored.
+*/
+func tagObject(b *Buffer, start, end Position, around bool) (Position, Position, bool) {
+	f := b.flattenAround(start, end)
+	s, t := f.offset(start), f.offset(end)
+
+	type openTag struct {
+		name       string
+		start, end int
+	}
+	var stack []openTag
+	best := [4]int{-1}
+	for _, m := range tagPattern.FindAllStringSubmatchIndex(f.text, -1) {
+		closing := m[3] > m[2]
+		selfClosing := m[7] > m[6]
+		name := f.text[m[4]:m[5]]
+		switch {
+		case selfClosing:
+		case !closing:
+			stack = append(stack, openTag{name: name, start: m[0], end: m[1]})
+		default:
+			k := len(stack) - 1
+			for k >= 0 && stack[k].name != name {
+				k--
+			}
+			if k < 0 {
+				continue
+			}
+			open := stack[k]
+			stack = stack[:k]
+			if open.start <= s && t <= m[1] && (best[0] < 0 || open.start > best[0]) {
+				best = [4]int{open.start, open.end, m[0], m[1]}
+			}
+		}
+	}
+	if best[0] < 0 {
+		return start, end, false
+	}
+	if around {
+		return f.delimited(best[0], best[3])
+	}
+	return f.delimited(best[1], best[2])
+}
+
+/*
+sentenceObject selects sentences within the paragraph holding the range. A
+sentence ends with '.', '!' or '?', optionally followed by closing quotes or
+brackets, and then whitespace or the end of the paragraph. Around adds the
+whitespace that follows.
+*/
+func sentenceObject(b *Buffer, start, end Position, around bool) (Position, Position, bool) {
+	if b.emptyLine(start.Line) {
+		return start, end, false
+	}
+	first, last := start.Line, b.lastSelected(start, end).Line
+	for first > 0 && !b.emptyLine(first-1) {
+		first--
+	}
+	for last+1 < b.LineCount() && !b.emptyLine(last+1) {
+		last++
+	}
+	f := b.flatten(first, last)
+	text := f.text
+
+	// Each sentence as its start, end and the start of the next
+	type sentence struct{ start, end, next int }
+	var sentences []sentence
+	begin := len(text) - len(strings.TrimLeft(text, " \t\n"))
+	for i := begin; i < len(text); i++ {
+		if !strings.ContainsRune(".!?", rune(text[i])) {
+			continue
+		}
+		stop := i + 1
+		for stop < len(text) && strings.ContainsRune(`"')]`, rune(text[stop])) {
+			stop++
+		}
+		if stop < len(text) && !strings.ContainsRune(" \t\n", rune(text[stop])) {
+			continue
+		}
+		next := stop
+		for next < len(text) && strings.ContainsRune(" \t\n", rune(text[next])) {
+			next++
+		}
+		sentences = append(sentences, sentence{begin, stop, next})
+		begin, i = next, next-1
+	}
+	if begin < len(text) {
+		sentences = append(sentences, sentence{begin, len(strings.TrimRight(text, " \t\n")), len(text)})
+	}
+
+	s, t := f.offset(start), f.offset(b.lastSelected(start, end))
+	from, to := -1, -1
+	for k, sen := range sentences {
+		if from < 0 && s < sen.next {
+			from = k
+		}
+		if t < sen.next {
+			to = k
+			break
+		}
+	}
+	if from < 0 || to < 0 {
+		return start, end, false
+	}
+	if around {
+		return f.delimited(sentences[from].start, sentences[to].next)
+	}
+	return f.delimited(sentences[from].start, sentences[to].end)
+}
@@ -0,0 +1,79 @@
+package editor
+
+import (
+	"testing"
+)
+
+func TestTextObjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		lines  []string
+		cursor Position
+		keys   string
+		want   string // the selected text; empty when nothing is selected
+	}{
+		{name: "inner word", lines: []string{"foo bar baz"}, cursor: Position{Col: 5}, keys: "miw", want: "bar"},
+		{name: "around word takes the space after", lines: []string{"foo bar baz"}, cursor: Position{Col: 5}, keys: "maw", want: "bar "},
+		{name: "around the last word takes the space before", lines: []string{"foo bar"}, cursor: Position{Col: 5}, keys: "maw", want: " bar"},
+		{name: "inner WORD", lines: []string{"a foo.bar b"}, cursor: Position{Col: 3}, keys: "miW", want: "foo.bar"},
+		{name: "word grows with a count", lines: []string{"foo bar"}, keys: "2miw", want: "foo "},
+
+		{name: "inner parens", lines: []string{"f(a, b)"}, cursor: Position{Col: 3}, keys: "mi(", want: "a, b"},
+		{name: "around parens", lines: []string{"f(a, b)"}, cursor: Position{Col: 3}, keys: "ma(", want: "(a, b)"},
+		{name: "on the opening paren", lines: []string{"f(a, b)"}, cursor: Position{Col: 1}, keys: "mi)", want: "a, b"},
+		{name: "on the closing paren", lines: []string{"f(a, b)"}, cursor: Position{Col: 6}, keys: "mib", want: "a, b"},
+		{name: "nested pairs take the innermost", lines: []string{"(a (b) c)"}, cursor: Position{Col: 4}, keys: "mi(", want: "b"},
+		{name: "repeating grows to around", lines: []string{"(a (b) c)"}, cursor: Position{Col: 4}, keys: "mi(mi(", want: "(b)"},
+		{name: "then to the enclosing pair", lines: []string{"(a (b) c)"}, cursor: Position{Col: 4}, keys: "mi(mi(mi(", want: "a (b) c"},
+		{name: "a count grows as far", lines: []string{"(a (b) c)"}, cursor: Position{Col: 4}, keys: "3mi(", want: "a (b) c"},
+		{name: "other pairs inside are skipped", lines: []string{"[a (b] c)"}, cursor: Position{Col: 7}, keys: "mi(", want: "b] c"},
+		{name: "unbalanced opening paren", lines: []string{"f((a)"}, cursor: Position{Col: 4}, keys: "ma(", want: "(a)"},
+		{name: "unbalanced with nothing around", lines: []string{"a) (b"}, cursor: Position{Col: 0}, keys: "mi(", want: ""},
+		{name: "pair across lines", lines: []string{"f {", "  x", "}"}, cursor: Position{Line: 1, Col: 2}, keys: "ma{", want: "{\n  x\n}"},
+		{name: "empty pair", lines: []string{"f()"}, cursor: Position{Col: 1}, keys: "ma(", want: "()"},
+		{name: "brackets", lines: []string{"a[1][2]"}, cursor: Position{Col: 5}, keys: "mi[", want: "2"},
+		{name: "braces by B", lines: []string{"{x}"}, cursor: Position{Col: 1}, keys: "maB", want: "{x}"},
+		{name: "angle brackets", lines: []string{"Vec<int>"}, cursor: Position{Col: 5}, keys: "mi<", want: "int"},
+
+		{name: "inner quotes", lines: []string{`say "hi there" now`}, cursor: Position{Col: 7}, keys: `mi"`, want: "hi there"},
+		{name: "around quotes takes the space after", lines: []string{`say "hi" now`}, cursor: Position{Col: 6}, keys: `ma"`, want: `"hi" `},
+		{name: "around quotes at the end of the line", lines: []string{`say "hi"`}, cursor: Position{Col: 6}, keys: `ma"`, want: ` "hi"`},
+		{name: "quote at the start of the line", lines: []string{`"hi" x`}, cursor: Position{Col: 0}, keys: `mi"`, want: "hi"},
+		{name: "quote at the end of the line", lines: []string{`x "hi"`}, cursor: Position{Col: 5}, keys: `mi"`, want: "hi"},
+		{name: "between two quoted strings takes the next", lines: []string{`"a" + "b"`}, cursor: Position{Col: 4}, keys: `mi"`, want: "b"},
+		{name: "second quoted string", lines: []string{`"a" + "b"`}, cursor: Position{Col: 7}, keys: `mi"`, want: "b"},
+		{name: "escaped quote inside", lines: []string{`"a\"b"`}, cursor: Position{Col: 1}, keys: `mi"`, want: `a\"b`},
+		{name: "single quotes", lines: []string{"x = 'y'"}, cursor: Position{Col: 5}, keys: "mi'", want: "y"},
+		{name: "unclosed quote", lines: []string{`say "hi`}, cursor: Position{Col: 6}, keys: `mi"`, want: ""},
+
+		{name: "inner tag", lines: []string{"<p><b>x</b></p>"}, cursor: Position{Col: 6}, keys: "mit", want: "x"},
+		{name: "around tag", lines: []string{"<p><b>x</b></p>"}, cursor: Position{Col: 6}, keys: "mat", want: "<b>x</b>"},
+		{name: "tag grows to around", lines: []string{"<p><b>x</b></p>"}, cursor: Position{Col: 6}, keys: "mitmit", want: "<b>x</b>"},
+		{name: "then around the enclosing tag, whose content is no larger", lines: []string{"<p><b>x</b></p>"}, cursor: Position{Col: 6}, keys: "mitmitmit", want: "<p><b>x</b></p>"},
+		{name: "the outermost tag does not grow", lines: []string{"<p><b>x</b></p>"}, cursor: Position{Col: 6}, keys: "4mit", want: "<p><b>x</b></p>"},
+		{name: "tag with attributes", lines: []string{`<a href="x">link</a>`}, cursor: Position{Col: 13}, keys: "mit", want: "link"},
+		{name: "self-closing tags are skipped", lines: []string{"<p>a<br/>b</p>"}, cursor: Position{Col: 10}, keys: "mit", want: "a<br/>b"},
+
+		{name: "inner paragraph", lines: []string{"a", "b", "", "c"}, keys: "mip", want: "a\nb"},
+		{name: "around paragraph takes the blank lines after", lines: []string{"a", "b", "", "", "c"}, keys: "map", want: "a\nb\n\n"},
+		{name: "inner indentation block", lines: []string{"if x {", "\ta", "\tb", "}"}, cursor: Position{Line: 1}, keys: "mii", want: "\ta\n\tb"},
+		{name: "inner sentence", lines: []string{"One two. Three four. Five."}, cursor: Position{Col: 11}, keys: "mis", want: "Three four."},
+		{name: "around sentence", lines: []string{"One two. Three four. Five."}, cursor: Position{Col: 11}, keys: "mas", want: "Three four. "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := newTestEditor(tt.lines...)
+			e.cursor = tt.cursor
+			e.selection = NewSelection(tt.cursor)
+			typeKeys(e, tt.keys)
+
+			got := e.buffer.GetSelectedText(e.selection)
+			if got != tt.want {
+				t.Errorf("selected %q, want %q", got, tt.want)
+			}
+			if visual := e.GetMode() == ModeVisual; visual != (tt.want != "") {
+				t.Errorf("visual mode = %v with %q selected", visual, got)
+			}
+		})
+	}
+}
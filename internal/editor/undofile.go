@@ -0,0 +1,172 @@
+package editor
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const undoFileVersion = 1
+
+/*
+undoFile is the on-disk form of an undo tree. Nodes are stored in creation order
+with parents and redo branches as sequence numbers, so the tree can be rebuilt
+without pointers. Hash is the SHA-256 of the file content the history ends in;
+the history is only trusted again if the file on disk still hashes the same.
+*/
+type undoFile struct {
+	Version int
+	Hash    string
+	Current int
+	Nodes   []undoFileNode
+}
+
+type undoFileNode struct {
+	Parent       int
+	RedoChild    int
+	Time         time.Time
+	Edits        []edit
+	CursorBefore Position
+	SelBefore    Selection
+	CursorAfter  Position
+}
+
+/*
+undoDir returns the directory persistent undo files live in, under the user's
+cache directory so history never litters project trees.
+*/
+func undoDir() (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cache, "threadweaver", "undo"), nil
+}
+
+/*
+undoFilePath maps a file to its undo file by hashing the absolute path, which
+keeps names flat and unambiguous regardless of where the file lives.
+*/
+func undoFilePath(filename string) (string, error) {
+	dir, err := undoDir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json"), nil
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+saveHistory writes the undo tree for filename, tagged with hash, the content hash
+of what was just written. Files are private to the user since history can hold
+text that was deleted from the file.
+*/
+func saveHistory(filename string, h *history, hash string) error {
+	path, err := undoFilePath(filename)
+	if err != nil {
+		return err
+	}
+
+	uf := undoFile{
+		Version: undoFileVersion,
+		Hash:    hash,
+		Current: h.current.seq,
+		Nodes:   make([]undoFileNode, len(h.nodes)),
+	}
+	for i, n := range h.nodes {
+		node := undoFileNode{Parent: -1, RedoChild: -1, Time: n.time}
+		if n.parent != nil {
+			node.Parent = n.parent.seq
+		}
+		if n.redoChild != nil {
+			node.RedoChild = n.redoChild.seq
+		}
+		if n.change != nil {
+			node.Edits = n.change.edits
+			node.CursorBefore = n.change.cursorBefore
+			node.SelBefore = n.change.selBefore
+			node.CursorAfter = n.change.cursorAfter
+		}
+		uf.Nodes[i] = node
+	}
+
+	data, err := json.Marshal(uf)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, 0600)
+}
+
+/*
+loadHistory restores the undo tree for filename if one was saved for content with
+exactly this hash. A history whose hash no longer matches describes a different
+file, so it is deleted rather than risk replaying edits against the wrong text.
+Any problem reading the history yields a fresh one; persistent undo is never
+fatal to loading.
+*/
+func loadHistory(filename, hash string) *history {
+	path, err := undoFilePath(filename)
+	if err != nil {
+		return newHistory()
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return newHistory()
+	}
+
+	var uf undoFile
+	if err := json.Unmarshal(data, &uf); err != nil || uf.Version != undoFileVersion || len(uf.Nodes) == 0 {
+		return newHistory()
+	}
+	if uf.Hash != hash {
+		os.Remove(path)
+		return newHistory()
+	}
+
+	h := &history{nodes: make([]*undoNode, len(uf.Nodes))}
+	for i, fn := range uf.Nodes {
+		h.nodes[i] = &undoNode{seq: i, time: fn.Time}
+	}
+	for i, fn := range uf.Nodes {
+		n := h.nodes[i]
+		if i > 0 {
+			if fn.Parent < 0 || fn.Parent >= i {
+				return newHistory()
+			}
+			n.parent = h.nodes[fn.Parent]
+			n.parent.children = append(n.parent.children, n)
+			n.change = &change{
+				edits:        fn.Edits,
+				cursorBefore: fn.CursorBefore,
+				selBefore:    fn.SelBefore,
+				cursorAfter:  fn.CursorAfter,
+			}
+		}
+		if fn.RedoChild > i && fn.RedoChild < len(h.nodes) {
+			n.redoChild = h.nodes[fn.RedoChild]
+		}
+	}
+	if uf.Current < 0 || uf.Current >= len(h.nodes) {
+		return newHistory()
+	}
+
+	h.root = h.nodes[0]
+	h.current = h.nodes[uf.Current]
+	h.saved = h.current
+	return h
+}
@@ -0,0 +1,129 @@
+package editor
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+/*
+tempFile writes content to a file in a fresh directory, with the user's cache
+directory, where undo and swap files go, moved into it as well.
+*/
+func tempFile(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(dir, "cache"))
+	t.Setenv("HOME", dir)
+	filename := filepath.Join(dir, "file.txt")
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func openTestFile(t *testing.T, filename string) *Editor {
+	t.Helper()
+	e := New()
+	if err := e.LoadFile(filename); err != nil {
+		t.Fatalf("LoadFile: %v", err)
+	}
+	return e
+}
+
+func TestUndoFileRoundTrip(t *testing.T) {
+	tests := []struct {
+		name    string
+		keys    string
+		touch   func(t *testing.T, filename string)
+		entries int
+		undone  []string
+	}{
+		{
+			name:    "history survives reopening",
+			keys:    "ione <Esc>itwo <Esc>",
+			entries: 3,
+			undone:  []string{"one abc"},
+		},
+		{
+			name:    "branches survive reopening",
+			keys:    "ione <Esc>uitwo <Esc>",
+			entries: 3,
+			undone:  []string{"abc"},
+		},
+		{
+			name: "file changed elsewhere discards the history",
+			keys: "ione <Esc>",
+			touch: func(t *testing.T, filename string) {
+				if err := os.WriteFile(filename, []byte("other\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			},
+			entries: 1,
+			undone:  []string{"other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filename := tempFile(t, "abc\n")
+			e := openTestFile(t, filename)
+			feed(t, e, tt.keys)
+			if err := e.SaveFile(false); err != nil {
+				t.Fatalf("SaveFile: %v", err)
+			}
+			e.Close()
+			if tt.touch != nil {
+				tt.touch(t, filename)
+			}
+
+			reopened := openTestFile(t, filename)
+			if got := len(reopened.UndoTree()); got != tt.entries {
+				t.Errorf("%d undo states, want %d", got, tt.entries)
+			}
+			feed(t, reopened, "u")
+			if got := reopened.buffer.allLines(); !slices.Equal(got, tt.undone) {
+				t.Errorf("after undo lines = %q, want %q", got, tt.undone)
+			}
+			path, err := undoFilePath(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			switch {
+			case tt.touch != nil:
+				if !os.IsNotExist(err) {
+					t.Errorf("stale undo file kept: %v", err)
+				}
+			case err != nil:
+				t.Errorf("undo file: %v", err)
+			case info.Mode().Perm() != 0600:
+				t.Errorf("undo file mode %v, want private to the user", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestUndoFileNotSaved(t *testing.T) {
+	filename := tempFile(t, "abc\n")
+	e := openTestFile(t, filename)
+	feed(t, e, "ix<Esc>")
+	// A file where the cache directory should be stops the history being written
+	cache := os.Getenv("XDG_CACHE_HOME")
+	if err := os.RemoveAll(cache); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cache, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := e.SaveFile(false); err != nil {
+		t.Fatalf("SaveFile: %v", err)
+	}
+	if e.buffer.IsDirty() {
+		t.Error("buffer still dirty after the file was written")
+	}
+	if msg := e.GetMessage(); msg.Level != MessageWarning {
+		t.Errorf("message %q at level %v, want a warning", msg.Text, msg.Level)
+	}
+}
@@ -0,0 +1,89 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss/v2"
+	"github.com/user/editor/internal/editor"
+)
+
+var (
+	hexOffsetStyle = lipgloss.NewStyle().
+			Foreground(lipgloss.Color("240"))
+
+	hexCursorStyle = lipgloss.NewStyle().
+			Reverse(true)
+
+	// The byte's twin in the column not being edited
+	hexShadowStyle = lipgloss.NewStyle().
+			Underline(true).
+			Foreground(lipgloss.Color("172"))
+)
+
+/*
+renderHex draws the hex session as rows of HexRowBytes bytes: the offset, the
+bytes in hex split into two groups of eight, and the same bytes as characters
+with anything unprintable shown as a dot. The cursor highlights the nibble or
+character being edited and underlines the same byte in the other column.
+*/
+func (r *Renderer) renderHex(ed *editor.Editor, scrollOffset int) string {
+	view := ed.HexView()
+	viewportHeight := r.height - 2
+
+	var rows []string
+	for y := 0; y < viewportHeight; y++ {
+		start := (y + scrollOffset) * editor.HexRowBytes
+		// A row starting at the end is only drawn to hold the append slot
+		if start > len(view.Data) || (start == len(view.Data) && start > 0 && view.Cursor < start) {
+			rows = append(rows, "")
+			continue
+		}
+		rows = append(rows, hexRow(view, start))
+	}
+	return strings.Join(rows, "\n")
+}
+
+func hexRow(view editor.HexView, start int) string {
+	var hex, chars strings.Builder
+	for i := start; i < start+editor.HexRowBytes; i++ {
+		if i > start && (i-start)%8 == 0 {
+			hex.WriteString(" ")
+		}
+		if i >= len(view.Data) {
+			if i == view.Cursor {
+				hex.WriteString(hexCursorStyle.Render("__") + " ")
+				chars.WriteString(hexCursorStyle.Render(" "))
+			} else {
+				hex.WriteString("   ")
+			}
+			continue
+		}
+
+		digits := fmt.Sprintf("%02x", view.Data[i])
+		char := printable(view.Data[i])
+		if i == view.Cursor {
+			if view.ASCII {
+				digits = hexShadowStyle.Render(digits)
+				char = hexCursorStyle.Render(char)
+			} else {
+				if view.Low {
+					digits = digits[:1] + hexCursorStyle.Render(digits[1:])
+				} else {
+					digits = hexCursorStyle.Render(digits[:1]) + digits[1:]
+				}
+				char = hexShadowStyle.Render(char)
+			}
+		}
+		hex.WriteString(digits + " ")
+		chars.WriteString(char)
+	}
+	return fmt.Sprintf("%s  %s |%s|", hexOffsetStyle.Render(fmt.Sprintf("%08x", start)), hex.String(), chars.String())
+}
+
+func printable(c byte) string {
+	if c >= 0x20 && c < 0x7f {
+		return string(rune(c))
+	}
+	return "."
+}
@@ -3,6 +3,7 @@ package ui
 import (
 	"strings"
 
+	"github.com/charmbracelet/lipgloss/v2"
 	uv "github.com/charmbracelet/ultraviolet"
 	"github.com/user/editor/internal/editor"
 )
@@ -31,15 +32,18 @@ func (r *Renderer) SetSize(width, height int) {
 
 /*
 Render transforms editor state into terminal output. Creates viewport of visible
-lines, applies selection highlighting in visual mode, and adds block cursor for
+lines, applies selection highlighting in visual mode, and adds block cursors for
 normal/visual modes. Uses Ultraviolet screen buffers for cell-level styling.
 */
 func (r *Renderer) Render(ed *editor.Editor, scrollOffset int) string {
+	if ed.HexActive() {
+		return r.renderHex(ed, scrollOffset)
+	}
+
 	buffer := ed.GetBuffer()
-	cursor := ed.GetCursor()
-	selection := ed.GetSelection()
+	selections := ed.GetSelections()
 
-	viewportHeight := r.height - 2 // Leave room for status bar
+	viewportHeight := r.height - 2 // Leave room for status bar and message area
 
 	var lines []string
 
@@ -53,15 +57,17 @@ func (r *Renderer) Render(ed *editor.Editor, scrollOffset int) string {
 
 		line := buffer.GetLine(lineNum)
 
-		// Pad line to ensure cursor can be rendered anywhere
-		// In normal mode, cursor can be at most len(line)-1
+		// Pad line to ensure cursors can be rendered anywhere
+		// In normal mode, a cursor can be at most len(line)-1
 		// but we need to ensure there's at least one character
 		if line == "" {
 			line = " " // Ensure empty lines have at least a space
-		} else if ed.GetMode() == editor.ModeNormal && cursor.Line == lineNum {
-			// Make sure line is long enough for cursor position
-			for len(line) <= cursor.Col {
-				line += " "
+		} else if ed.GetMode() == editor.ModeNormal {
+			// Make sure line is long enough for every cursor on it
+			for _, sel := range selections {
+				for sel.Head.Line == lineNum && len(line) <= sel.Head.Col {
+					line += " "
+				}
 			}
 		}
 
@@ -70,25 +76,68 @@ func (r *Renderer) Render(ed *editor.Editor, scrollOffset int) string {
 
 	content := strings.Join(lines, "\n")
 
-	// Apply selection highlighting if in visual mode
-	if ed.GetMode() == editor.ModeVisual && !selection.IsEmpty() {
-		content = r.applySelection(content, selection, scrollOffset)
-		// Also show cursor at the head of selection in visual mode
-		content = r.applyCursor(content, cursor, scrollOffset, ed.GetMode())
-	} else {
-		// Apply cursor highlighting in normal mode
-		content = r.applyCursor(content, cursor, scrollOffset, ed.GetMode())
+	if matches := ed.SearchMatches(scrollOffset, scrollOffset+viewportHeight); len(matches) > 0 {
+		content = r.applySearch(content, buffer, matches, ed.GetCursor(), scrollOffset)
+	}
+
+	// Map byte columns to screen cells before styling cells
+	for i, sel := range selections {
+		selections[i] = editor.Selection{
+			Anchor: displayPosition(buffer, sel.Anchor),
+			Head:   displayPosition(buffer, sel.Head),
+		}
+	}
+	content = r.applySelections(content, selections, ed.PrimarySelection(), scrollOffset, ed.GetMode())
+
+	if ed.GetMode() == editor.ModeUndoTree {
+		content = r.applyUndoTree(content, ed)
 	}
 
 	return content
 }
 
+var (
+	// The primary selection and cursor are reverse video; the others are set
+	// apart with a background so the primary stands out.
+	primaryStyle   = uv.NewStyle().Reverse(true)
+	secondaryStyle = uv.NewStyle().Background(lipgloss.Color("240"))
+	cursorStyle    = uv.NewStyle().Background(lipgloss.Color("250")).Foreground(lipgloss.Color("235"))
+
+	searchStyle       = uv.NewStyle().Background(lipgloss.Color("178")).Foreground(lipgloss.Color("235"))
+	currentMatchStyle = uv.NewStyle().Background(lipgloss.Color("208")).Foreground(lipgloss.Color("235"))
+)
+
+/*
+applySearch highlights the search matches on screen, the one at the cursor in
+a brighter color. Selections are drawn over them.
+*/
+func (r *Renderer) applySearch(content string, buffer *editor.Buffer, matches []editor.Selection, cursor editor.Position, scrollOffset int) string {
+	area := uv.Rect(0, 0, r.width, r.height-2)
+	scr := uv.NewScreenBuffer(area.Dx(), area.Dy())
+	uv.NewStyledString(content).Draw(scr, area)
+
+	for _, match := range matches {
+		style := searchStyle
+		if match.Start() == cursor {
+			style = currentMatchStyle
+		}
+		match = editor.Selection{
+			Anchor: displayPosition(buffer, match.Anchor),
+			Head:   displayPosition(buffer, match.Head),
+		}
+		r.highlight(&scr, match, scrollOffset, style)
+	}
+
+	return scr.Render()
+}
+
 /*
-applySelection overlays reverse-video styling on selected text regions.
-Handles both single-line and multi-line selections by iterating through
-affected cells and applying style changes at the cell level.
+applySelections highlights every non-empty selection and draws a block
+cursor at the head of each one. The primary selection is drawn in reverse video
+and the others in a dimmer style. Insert/command modes use the native terminal
+line cursor for the primary one, so only the other cursors are drawn as blocks.
 */
-func (r *Renderer) applySelection(content string, sel editor.Selection, scrollOffset int) string {
+func (r *Renderer) applySelections(content string, sels []editor.Selection, primary, scrollOffset int, mode editor.Mode) string {
 	// Create a screen buffer
 	area := uv.Rect(0, 0, r.width, r.height-2)
 	scr := uv.NewScreenBuffer(area.Dx(), area.Dy())
@@ -96,6 +145,29 @@ func (r *Renderer) applySelection(content string, sel editor.Selection, scrollOf
 	// Draw the content
 	uv.NewStyledString(content).Draw(scr, area)
 
+	for i, sel := range sels {
+		style, cursor := secondaryStyle, cursorStyle
+		if i == primary {
+			style, cursor = primaryStyle, primaryStyle
+		}
+		if !sel.IsEmpty() {
+			r.highlight(&scr, sel, scrollOffset, style)
+		}
+		if i == primary && (mode == editor.ModeInsert || mode == editor.ModeCommand) {
+			continue
+		}
+		r.drawCursor(&scr, sel.Head, scrollOffset, cursor)
+	}
+
+	return scr.Render()
+}
+
+/*
+highlight styles the cells of one selection. Handles both single-line and
+multi-line selections by iterating through affected cells and applying style
+changes at the cell level.
+*/
+func (r *Renderer) highlight(scr *uv.ScreenBuffer, sel editor.Selection, scrollOffset int, style uv.Style) {
 	// Calculate selection area adjusted for scroll
 	start, end := sel.Start(), sel.End()
 	selArea := uv.Rectangle{
@@ -104,8 +176,6 @@ func (r *Renderer) applySelection(content string, sel editor.Selection, scrollOf
 	}
 	selArea = selArea.Canon()
 
-	// Apply reverse style to selected cells
-	reverseStyle := uv.NewStyle().Reverse(true)
 	for y := range scr.Height() {
 		if y >= selArea.Min.Y && y <= selArea.Max.Y {
 			startX := 0
@@ -125,56 +195,52 @@ func (r *Renderer) applySelection(content string, sel editor.Selection, scrollOf
 
 			for x := startX; x < endX && x < scr.Width(); x++ {
 				cell := scr.CellAt(x, y)
-				if cell != nil {
-					cell.Style = reverseStyle
+				// Skip the placeholder cells trailing a wide character
+				if cell != nil && cell.Width > 0 {
+					cell = cell.Clone()
+					cell.Style = style
 					scr.SetCell(x, y, cell)
 				}
 			}
 		}
 	}
-
-	return scr.Render()
 }
 
 /*
-applyCursor renders block cursor for normal/visual modes by reversing the cell
-at cursor position. Insert/command modes use native terminal line cursor instead.
+drawCursor renders a block cursor by styling the cell at the cursor position.
 Creates empty cell with space if cursor is beyond line content.
 */
-func (r *Renderer) applyCursor(content string, cursor editor.Position, scrollOffset int, mode editor.Mode) string {
-	// Don't show block cursor in insert/command mode (they use line cursor)
-	if mode == editor.ModeInsert || mode == editor.ModeCommand {
-		return content
-	}
-
-	// Create a screen buffer
-	area := uv.Rect(0, 0, r.width, r.height-2)
-	scr := uv.NewScreenBuffer(area.Dx(), area.Dy())
-
-	// Draw the content
-	uv.NewStyledString(content).Draw(scr, area)
-
-	// Apply cursor
+func (r *Renderer) drawCursor(scr *uv.ScreenBuffer, cursor editor.Position, scrollOffset int, style uv.Style) {
 	cursorY := cursor.Line - scrollOffset
-	if cursorY >= 0 && cursorY < scr.Height() {
-		cell := scr.CellAt(cursor.Col, cursorY)
-		if cell != nil {
-			// Clone the cell and apply reverse style
-			cell = cell.Clone()
-			cell.Style = uv.NewStyle().Reverse(true)
-			scr.SetCell(cursor.Col, cursorY, cell)
-		} else {
-			// No cell at cursor position, create one with a space
-			newCell := &uv.Cell{
-				Content: " ",
-				Width:   1,
-				Style:   uv.NewStyle().Reverse(true),
-			}
-			scr.SetCell(cursor.Col, cursorY, newCell)
+	if cursorY < 0 || cursorY >= scr.Height() {
+		return
+	}
+	cell := scr.CellAt(cursor.Col, cursorY)
+	if cell != nil {
+		// Clone the cell and apply the cursor style
+		cell = cell.Clone()
+		cell.Style = style
+		scr.SetCell(cursor.Col, cursorY, cell)
+	} else {
+		// No cell at cursor position, create one with a space
+		newCell := &uv.Cell{
+			Content: " ",
+			Width:   1,
+			Style:   style,
 		}
+		scr.SetCell(cursor.Col, cursorY, newCell)
 	}
+}
 
-	return scr.Render()
+/*
+displayPosition converts a buffer position into screen coordinates, where the
+column counts terminal cells rather than bytes.
+*/
+func displayPosition(buffer *editor.Buffer, pos editor.Position) editor.Position {
+	return editor.Position{
+		Line: pos.Line,
+		Col:  editor.DisplayColumn(buffer.GetLine(pos.Line), pos.Col),
+	}
 }
 
 func (r *Renderer) CalculateScrollOffset(cursor editor.Position, currentOffset int) int {
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/charmbracelet/lipgloss/v2"
 	"github.com/user/editor/internal/editor"
@@ -48,8 +49,52 @@ var (
 	dirtyStyle = lipgloss.NewStyle().
 			Foreground(lipgloss.Color("203")).
 			Bold(true)
+
+	messageInfoStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("252"))
+
+	messageWarningStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("214"))
+
+	messageErrorStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("203")).
+				Bold(true)
 )
 
+/*
+fileFlags lists how the file's bytes differ from UTF-8 with unix line endings, so
+a save that preserves them is never a surprise, and whether it is open in a
+restricted mode: read-only, large-file, or read-only because the content is not
+text.
+*/
+func fileFlags(buffer *editor.Buffer) string {
+	var flags string
+	if buffer.ReadOnly() {
+		flags += " [RO]"
+	}
+	if buffer.Indexing() {
+		flags += fmt.Sprintf(" [indexing %d%%]", int(buffer.IndexProgress()*100))
+	} else if buffer.IsLarge() {
+		flags += " [large]"
+	}
+	if reason := buffer.Undecodable(); reason != "" {
+		flags += " [" + reason + "]"
+	}
+	if buffer.Encoding() != editor.EncodingUTF8 {
+		flags += " [" + buffer.Encoding().String() + "]"
+	}
+	if buffer.FileFormat() != editor.FormatUnix {
+		flags += " [" + buffer.FileFormat().String() + "]"
+	}
+	if buffer.HasBOM() {
+		flags += " [BOM]"
+	}
+	if !buffer.HasFinalNewline() && !buffer.Indexing() && (buffer.LineCount() > 1 || buffer.GetLine(0) != "") {
+		flags += " [noeol]"
+	}
+	return flags
+}
+
 /*
 RenderStatusLine creates the bottom status bar showing mode, filename, and cursor position.
 Layouts components with mode indicator on left, filename center-left, and position on right.
@@ -76,6 +121,18 @@ func RenderStatusLine(width int, ed *editor.Editor) string {
 	case editor.ModeCommand:
 		modeText = " COMMAND "
 		style = modeStyle
+	case editor.ModeUndoTree:
+		modeText = " UNDO "
+		style = modeVisualStyle
+	case editor.ModeConfirm:
+		modeText = " CONFIRM "
+		style = modeVisualStyle
+	case editor.ModeHex:
+		modeText = " HEX "
+		if ed.HexView().Insert {
+			modeText = " HEX INSERT "
+		}
+		style = modeVisualStyle
 	}
 
 	modeBlock := style.Render(modeText)
@@ -84,9 +141,35 @@ func RenderStatusLine(width int, ed *editor.Editor) string {
 	if buffer.IsDirty() {
 		filename = dirtyStyle.Render(filename + " [+]")
 	}
-	fileBlock := fileStyle.Render(filename)
-
-	position := fmt.Sprintf("%d:%d", cursor.Line+1, cursor.Col+1)
+	fileBlock := fileStyle.Render(filename + fileFlags(buffer))
+
+	var position string
+	if ed.HexActive() {
+		view := ed.HexView()
+		position = fmt.Sprintf("0x%08x/%d", view.Cursor, len(view.Data))
+	} else {
+		column := editor.GraphemeColumn(buffer.GetLine(cursor.Line), cursor.Col)
+		position = fmt.Sprintf("%d:%d", cursor.Line+1, column+1)
+		// Which selection is primary, when there are several
+		if sels := ed.GetSelections(); len(sels) > 1 {
+			position = fmt.Sprintf("%d/%d sels  %s", ed.PrimarySelection()+1, len(sels), position)
+		}
+		// Which search match the cursor is at
+		if current, total := ed.SearchCount(); total > 0 {
+			position = fmt.Sprintf("%d/%d  %s", current, total, position)
+		}
+	}
+	// Keys typed so far of an unfinished command, like vim's showcmd
+	showcmd := ed.GetPending()
+	if count := ed.GetCount(); count > 0 {
+		showcmd = strconv.Itoa(count) + showcmd
+	}
+	if showcmd != "" {
+		position = showcmd + "  " + position
+	}
+	if reg := ed.Recording(); reg != 0 {
+		position = "recording @" + string(reg) + "  " + position
+	}
 	posBlock := positionStyle.Render(position)
 
 	leftContent := lipgloss.JoinHorizontal(lipgloss.Top, modeBlock, fileBlock)
@@ -108,4 +191,25 @@ func RenderStatusLine(width int, ed *editor.Editor) string {
 	)
 
 	return statusStyle.Width(width).Render(fullLine)
+}
+
+/*
+RenderMessageLine draws the line beneath the status bar. It shows the command
+line while one is being typed and otherwise the editor's latest message, colored
+by level so errors stand out from routine reports.
+*/
+func RenderMessageLine(width int, ed *editor.Editor) string {
+	if ed.GetMode() == editor.ModeCommand {
+		return lipgloss.NewStyle().Width(width).Render(ed.GetPrompt().String() + ed.GetCommand() + "█")
+	}
+
+	msg := ed.GetMessage()
+	style := messageInfoStyle
+	switch msg.Level {
+	case editor.MessageWarning:
+		style = messageWarningStyle
+	case editor.MessageError:
+		style = messageErrorStyle
+	}
+	return style.Width(width).MaxHeight(1).Render(msg.Text)
 }
\ No newline at end of file
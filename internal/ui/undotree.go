@@ -0,0 +1,106 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss/v2"
+	uv "github.com/charmbracelet/ultraviolet"
+	"github.com/user/editor/internal/editor"
+)
+
+const undoTreeWidth = 40
+
+var (
+	undoTreeStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("62")).
+			Background(lipgloss.Color("235")).
+			Foreground(lipgloss.Color("252")).
+			Width(undoTreeWidth)
+
+	undoTreeSelectedStyle = lipgloss.NewStyle().
+				Background(lipgloss.Color("62")).
+				Foreground(lipgloss.Color("230"))
+
+	undoTreeTitleStyle = lipgloss.NewStyle().
+				Foreground(lipgloss.Color("172")).
+				Bold(true)
+)
+
+/*
+applyUndoTree draws the undo tree as a panel over the right edge of the content.
+Each row is one state, newest first, with a rail per branch and a dot on the
+branch the state belongs to. The list scrolls to keep the highlighted row visible.
+*/
+func (r *Renderer) applyUndoTree(content string, ed *editor.Editor) string {
+	area := uv.Rect(0, 0, r.width, r.height-2)
+	scr := uv.NewScreenBuffer(area.Dx(), area.Dy())
+	uv.NewStyledString(content).Draw(scr, area)
+
+	entries := ed.UndoTree()
+	selected := ed.UndoTreeSelection()
+
+	columns := 0
+	for _, entry := range entries {
+		if entry.Column+1 > columns {
+			columns = entry.Column + 1
+		}
+	}
+
+	visible := area.Dy() - 3 // Border plus title
+	if visible < 1 {
+		visible = 1
+	}
+	first := 0
+	if selected >= visible {
+		first = selected - visible + 1
+	}
+
+	rows := []string{undoTreeTitleStyle.Render("Undo tree")}
+	for i := first; i < len(entries) && i < first+visible; i++ {
+		row := undoTreeRow(entries[i], columns)
+		if i == selected {
+			row = undoTreeSelectedStyle.Width(undoTreeWidth - 2).Render(row) // Inside the border
+		}
+		rows = append(rows, row)
+	}
+
+	panel := undoTreeStyle.Render(strings.Join(rows, "\n"))
+	panelWidth := lipgloss.Width(panel)
+	x := area.Dx() - panelWidth
+	if x < 0 {
+		x = 0
+	}
+	uv.NewStyledString(panel).Draw(scr, uv.Rect(x, 0, panelWidth, lipgloss.Height(panel)))
+
+	return scr.Render()
+}
+
+func undoTreeRow(entry editor.UndoTreeEntry, columns int) string {
+	var graph strings.Builder
+	for c := 0; c < columns; c++ {
+		if c == entry.Column {
+			graph.WriteString("● ")
+		} else {
+			graph.WriteString("│ ")
+		}
+	}
+
+	label := "original"
+	if entry.Seq > 0 {
+		label = fmt.Sprintf("%d edit", entry.Edits)
+		if entry.Edits != 1 {
+			label += "s"
+		}
+	}
+
+	row := fmt.Sprintf("%s%3d  %s  %s", graph.String(), entry.Seq, entry.Time.Format("15:04:05"), label)
+	if entry.Current {
+		row += " <"
+	}
+	if entry.Saved {
+		row += " [w]"
+	}
+	return row
+}
@@ -1,9 +1,10 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"log"
 	"os"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea/v2"
 	"github.com/charmbracelet/lipgloss/v2"
@@ -20,11 +21,14 @@ type model struct {
 	quitting     bool
 }
 
-func initialModel(filename string) model {
+func initialModel(filename string, readOnly bool) model {
 	ed := editor.New()
 	if filename != "" {
-		if err := ed.LoadFile(filename); err != nil {
-			log.Printf("Error loading file: %v", err)
+		// Failures are reported in the editor's message area
+		if readOnly {
+			ed.ViewFile(filename)
+		} else {
+			ed.LoadFile(filename)
 		}
 	}
 
@@ -37,8 +41,38 @@ func initialModel(filename string) model {
 	}
 }
 
+/*
+swapInterval is how often unsaved changes are journaled to the swap file.
+*/
+const swapInterval = 4 * time.Second
+
+type swapTickMsg struct{}
+
+func swapTick() tea.Cmd {
+	return tea.Tick(swapInterval, func(time.Time) tea.Msg {
+		return swapTickMsg{}
+	})
+}
+
+/*
+indexInterval is how often the view refreshes while a large file is indexed in
+the background, so the line count and progress keep up.
+*/
+const indexInterval = 100 * time.Millisecond
+
+type indexTickMsg struct{}
+
+func indexTick() tea.Cmd {
+	return tea.Tick(indexInterval, func(time.Time) tea.Msg {
+		return indexTickMsg{}
+	})
+}
+
 func (m model) Init() tea.Cmd {
-	return nil
+	if m.editor.GetBuffer().Indexing() {
+		return tea.Batch(swapTick(), indexTick())
+	}
+	return swapTick()
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -51,181 +85,59 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 	case tea.KeyMsg:
 		return m.handleKeyPress(msg)
-	}
 
-	return m, nil
-}
-
-func (m model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	mode := m.editor.GetMode()
-
-	switch mode {
-	case editor.ModeNormal:
-		return m.handleNormalMode(msg)
-	case editor.ModeInsert:
-		return m.handleInsertMode(msg)
-	case editor.ModeVisual:
-		return m.handleVisualMode(msg)
-	case editor.ModeCommand:
-		return m.handleCommandMode(msg)
-	}
-
-	return m, nil
-}
+	case tea.FocusMsg:
+		m.editor.CheckDisk()
+		return m, nil
 
-func (m model) handleNormalMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "ctrl+c", "q":
-		if !m.editor.GetBuffer().IsDirty() {
-			m.quitting = true
-			return m, tea.Quit
+	case swapTickMsg:
+		if err := m.editor.WriteSwap(); err != nil {
+			m.editor.SetMessage(editor.MessageError, err.Error())
 		}
+		return m, swapTick()
 
-	case "h", "left":
-		m.editor.MoveCursor(0, -1)
-	case "j", "down":
-		m.editor.MoveCursor(1, 0)
-	case "k", "up":
-		m.editor.MoveCursor(-1, 0)
-	case "l", "right":
-		m.editor.MoveCursor(0, 1)
-
-	case "w":
-		m.editor.MoveWordForward()
-	case "b":
-		m.editor.MoveWordBackward()
-
-	case "0":
-		m.editor.MoveToLineStart()
-	case "$":
-		m.editor.MoveToLineEnd()
-
-	case "i":
-		m.editor.SetMode(editor.ModeInsert)
-	case "a":
-		m.editor.MoveCursor(0, 1)
-		m.editor.SetMode(editor.ModeInsert)
-
-	case "v":
-		m.editor.SetMode(editor.ModeVisual)
-
-	case "x":
-		m.editor.SelectLine()
-		m.editor.SetMode(editor.ModeVisual)
-
-	case "d":
-		if !m.editor.GetSelection().IsEmpty() {
-			m.editor.DeleteSelection()
+	case indexTickMsg:
+		if m.editor.PollIndex() {
+			return m, indexTick()
 		}
-	case "c":
-		if !m.editor.GetSelection().IsEmpty() {
-			m.editor.DeleteSelection()
-			m.editor.SetMode(editor.ModeInsert)
-		}
-	case "y":
-		m.editor.YankSelection()
-	case "p":
-		m.editor.Paste()
-
-	case ":":
-		m.editor.SetMode(editor.ModeCommand)
-		m.editor.ClearCommand()
+		return m, nil
 	}
 
-	m.scrollOffset = m.renderer.CalculateScrollOffset(m.editor.GetCursor(), m.scrollOffset)
 	return m, nil
 }
 
-func (m model) handleInsertMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "esc":
-		m.editor.MoveCursor(0, -1)
-		m.editor.SetMode(editor.ModeNormal)
-
-	case "enter":
-		m.editor.InsertNewline()
-
-	case "backspace":
-		m.editor.Backspace()
-
-	case "left":
-		m.editor.MoveCursor(0, -1)
-	case "right":
-		m.editor.MoveCursor(0, 1)
-	case "up":
-		m.editor.MoveCursor(-1, 0)
-	case "down":
-		m.editor.MoveCursor(1, 0)
-
-	default:
-		// Handle all single character input including space
-		runes := []rune(msg.String())
-		if len(runes) == 1 {
-			m.editor.InsertChar(runes[0])
-		}
+/*
+handleKeyPress passes a key to the editor, which runs it in the current mode,
+then scrolls to follow the cursor, starting from wherever a half-page scroll
+left the view.
+*/
+func (m model) handleKeyPress(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	indexing := m.editor.GetBuffer().Indexing()
+	m.editor.SetViewport(m.scrollOffset, m.height-2)
+	if m.editor.HandleKey(msg.String()) {
+		m.quitting = true
+		return m, tea.Quit
 	}
 
-	m.scrollOffset = m.renderer.CalculateScrollOffset(m.editor.GetCursor(), m.scrollOffset)
-	return m, nil
-}
-
-func (m model) handleVisualMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "esc":
-		m.editor.SetMode(editor.ModeNormal)
-
-	case "h", "left":
-		m.editor.MoveCursor(0, -1)
-	case "j", "down":
-		m.editor.MoveCursor(1, 0)
-	case "k", "up":
-		m.editor.MoveCursor(-1, 0)
-	case "l", "right":
-		m.editor.MoveCursor(0, 1)
-
-	case "w":
-		m.editor.MoveWordForward()
-	case "b":
-		m.editor.MoveWordBackward()
-
-	case "d":
-		m.editor.DeleteSelection()
-		m.editor.SetMode(editor.ModeNormal)
-	case "c":
-		m.editor.DeleteSelection()
-		m.editor.SetMode(editor.ModeInsert)
-	case "y":
-		m.editor.YankSelection()
-		m.editor.SetMode(editor.ModeNormal)
+	m.scrollOffset, _ = m.editor.GetViewport()
+	m.scrollOffset = m.calculateScrollOffset()
+	if !indexing && m.editor.GetBuffer().Indexing() {
+		// :e! reopened a large file
+		return m, indexTick()
 	}
-
-	m.scrollOffset = m.renderer.CalculateScrollOffset(m.editor.GetCursor(), m.scrollOffset)
 	return m, nil
 }
 
-func (m model) handleCommandMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
-	switch msg.String() {
-	case "esc":
-		m.editor.SetMode(editor.ModeNormal)
-		m.editor.ClearCommand()
-
-	case "enter":
-		if m.editor.ExecuteCommand() {
-			m.quitting = true
-			return m, tea.Quit
-		}
-		m.editor.SetMode(editor.ModeNormal)
-
-	case "backspace":
-		m.editor.BackspaceCommand()
-
-	default:
-		if len(msg.String()) == 1 {
-			m.editor.AppendCommand(rune(msg.String()[0]))
-		}
+/*
+calculateScrollOffset keeps the cursor in view, which in a hex session is the
+row holding the byte under the cursor.
+*/
+func (m model) calculateScrollOffset() int {
+	if m.editor.HexActive() {
+		row := m.editor.HexView().Cursor / editor.HexRowBytes
+		return m.renderer.CalculateScrollOffset(editor.Position{Line: row}, m.scrollOffset)
 	}
-
-	return m, nil
+	return m.renderer.CalculateScrollOffset(m.editor.GetCursor(), m.scrollOffset)
 }
 
 func (m model) View() tea.View {
@@ -243,24 +155,15 @@ func (m model) View() tea.View {
 	// Render the status line
 	statusLine := ui.RenderStatusLine(m.width, m.editor)
 
-	// Handle command mode display
-	if m.editor.GetMode() == editor.ModeCommand {
-		commandLine := ":" + m.editor.GetCommand()
-		// Add cursor to command line
-		commandLine += "█"
-		// Replace last line with command
-		statusLine = lipgloss.NewStyle().
-			Width(m.width).
-			Background(lipgloss.Color("235")).
-			Foreground(lipgloss.Color("252")).
-			Render(commandLine)
-	}
+	// Render the message area, which doubles as the command line
+	messageLine := ui.RenderMessageLine(m.width, m.editor)
 
-	// Combine editor content and status line
+	// Combine editor content, status line and message area
 	fullView := lipgloss.JoinVertical(
 		lipgloss.Top,
 		editorContent,
 		statusLine,
+		messageLine,
 	)
 
 	// Create the view with layers
@@ -275,7 +178,8 @@ func (m model) View() tea.View {
 		cursor := m.editor.GetCursor()
 		cursorY := cursor.Line - m.scrollOffset
 		if cursorY >= 0 && cursorY < m.height-2 {
-			view.Cursor = tea.NewCursor(cursor.Col, cursorY)
+			cursorX := editor.DisplayColumn(m.editor.GetBuffer().GetLine(cursor.Line), cursor.Col)
+			view.Cursor = tea.NewCursor(cursorX, cursorY)
 		}
 	}
 
@@ -283,15 +187,19 @@ func (m model) View() tea.View {
 }
 
 func main() {
-	filename := ""
-	if len(os.Args) > 1 {
-		filename = os.Args[1]
-	}
+	readOnly := flag.Bool("R", false, "open the file read-only; :w! still writes it")
+	flag.Parse()
+	filename := flag.Arg(0)
+
+	// Exiting through os.Exit skips Close, leaving the swap file for recovery
+	m := initialModel(filename, *readOnly)
+	defer m.editor.Close()
 
 	p := tea.NewProgram(
-		initialModel(filename),
+		m,
 		tea.WithAltScreen(),
 		tea.WithMouseCellMotion(),
+		tea.WithReportFocus(),
 	)
 
 	if _, err := p.Run(); err != nil {